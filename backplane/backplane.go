@@ -0,0 +1,150 @@
+// Package backplane relays messages between the instances of the server,
+// so clients of the same room can meet while connected to different ones.
+package backplane
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+var ErrClosed = errors.New("backplane: closed")
+
+// Backplane is a publish/subscribe channel shared by the server instances.
+// Messages of a topic are delivered to every subscriber, including the
+// publishing instance, in the order they were published. A subscriber
+// which falls too far behind loses messages rather than hold up others.
+type Backplane interface {
+	// Publish sends the message to every subscriber of the topic.
+	Publish(topic string, data []byte) error
+	// Subscribe calls the handler for every message of the topic until
+	// the returned function is called. The handler is called from a single
+	// goroutine per subscription.
+	Subscribe(topic string, handle func(data []byte)) (unsubscribe func(), err error)
+	// SetOnConnection sets the function called when the backplane loses
+	// its connection to the other instances and when it is back. Messages
+	// published in the meantime are lost.
+	SetOnConnection(onConnection func(connected bool))
+	// Close releases the backplane and stops all its subscriptions.
+	Close() error
+}
+
+// Memory is a Backplane within a single process, it lets several room
+// managers of one process behave like separate instances.
+type Memory struct {
+	subscriptions map[string]map[*subscription]bool
+	isClosed      bool
+	mutex         sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{subscriptions: map[string]map[*subscription]bool{}}
+}
+
+func (m *Memory) Publish(topic string, data []byte) error {
+	m.mutex.Lock()
+	if m.isClosed {
+		m.mutex.Unlock()
+		return ErrClosed
+	}
+	subscriptions := []*subscription{}
+	for s := range m.subscriptions[topic] {
+		subscriptions = append(subscriptions, s)
+	}
+	m.mutex.Unlock()
+
+	for _, s := range subscriptions {
+		s.deliver(data)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, handle func(data []byte)) (func(), error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isClosed {
+		return nil, ErrClosed
+	}
+	s := newSubscription(topic, handle)
+	if m.subscriptions[topic] == nil {
+		m.subscriptions[topic] = map[*subscription]bool{}
+	}
+	m.subscriptions[topic][s] = true
+
+	unsubscribe := func() {
+		m.mutex.Lock()
+		delete(m.subscriptions[topic], s)
+		if len(m.subscriptions[topic]) == 0 {
+			delete(m.subscriptions, topic)
+		}
+		m.mutex.Unlock()
+		s.cancel()
+	}
+	return unsubscribe, nil
+}
+
+// SetOnConnection does nothing, the instances within a process never
+// lose their connection.
+func (m *Memory) SetOnConnection(onConnection func(connected bool)) {}
+
+func (m *Memory) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.isClosed = true
+	for _, subscriptions := range m.subscriptions {
+		for s := range subscriptions {
+			s.cancel()
+		}
+	}
+	m.subscriptions = map[string]map[*subscription]bool{}
+	return nil
+}
+
+// subscription delivers messages to its handler one by one, so a slow
+// handler holds up neither the publisher nor other subscriptions.
+type subscription struct {
+	topic      string
+	handle     func(data []byte)
+	queue      chan []byte
+	done       chan struct{}
+	cancelOnce sync.Once
+}
+
+func newSubscription(topic string, handle func(data []byte)) *subscription {
+	s := &subscription{
+		topic:  topic,
+		handle: handle,
+		queue:  make(chan []byte, 256),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// deliver queues the message. It never blocks, as it runs on the read loop
+// of the connection, so the message is dropped while the queue is full.
+func (s *subscription) deliver(data []byte) {
+	select {
+	case s.queue <- data:
+	case <-s.done:
+	default:
+		slog.Warn("Backplane subscription lagging:", "topic", s.topic)
+	}
+}
+
+func (s *subscription) run() {
+	for {
+		select {
+		case data := <-s.queue:
+			s.handle(data)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *subscription) cancel() {
+	s.cancelOnce.Do(func() { close(s.done) })
+}
@@ -0,0 +1,149 @@
+package backplane
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// How long a test waits for a message before it gives up.
+const testTimeout = 2 * time.Second
+
+// collect subscribes to the topic and returns the channel of its messages.
+func collect(t *testing.T, b Backplane, topic string) (<-chan string, func()) {
+	t.Helper()
+	messages := make(chan string, 100)
+	unsubscribe, err := b.Subscribe(topic, func(data []byte) {
+		messages <- string(data)
+	})
+	if err != nil {
+		t.Fatalf("subscribe to %s: %v", topic, err)
+	}
+	return messages, unsubscribe
+}
+
+// expectMessages waits for the messages in the given order.
+func expectMessages(t *testing.T, messages <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-messages:
+			if got != w {
+				t.Fatalf("got message %q, want %q", got, w)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("no message %q in %v", w, testTimeout)
+		}
+	}
+}
+
+// expectNoMessage checks that no message arrives for a while.
+func expectNoMessage(t *testing.T, messages <-chan string) {
+	t.Helper()
+	select {
+	case got := <-messages:
+		t.Fatalf("got unexpected message %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// waitSubscribed waits until the subscriptions of the subscriber made so
+// far receive the messages of the publisher. A broker handles the
+// subscriptions of a connection in order, so once a new one receives
+// a message, so do the ones before.
+func waitSubscribed(t *testing.T, publisher, subscriber Backplane) {
+	t.Helper()
+	messages, unsubscribe := collect(t, subscriber, "sync")
+	defer unsubscribe()
+
+	timeout := time.After(testTimeout)
+	for {
+		if err := publisher.Publish("sync", []byte("sync")); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+		select {
+		case <-messages:
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("subscriptions not active in %v", testTimeout)
+		}
+	}
+}
+
+// testRoundTrip publishes over one backplane and receives over the other,
+// which may be the same one.
+func testRoundTrip(t *testing.T, publisher, subscriber Backplane) {
+	first, unsubscribe := collect(t, subscriber, "room.1")
+	second, _ := collect(t, subscriber, "room.1")
+	other, _ := collect(t, subscriber, "room.2")
+	waitSubscribed(t, publisher, subscriber)
+
+	want := []string{}
+	for i := range 10 {
+		message := fmt.Sprintf("message %d", i)
+		want = append(want, message)
+		if err := publisher.Publish("room.1", []byte(message)); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	expectMessages(t, first, want...)
+	expectMessages(t, second, want...)
+	expectNoMessage(t, other)
+
+	unsubscribe()
+	if err := publisher.Publish("room.1", []byte("after")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	expectMessages(t, second, "after")
+	expectNoMessage(t, first)
+}
+
+// testLaggingSubscriber stalls a subscriber of one topic and checks that
+// it holds up neither the publisher nor the subscribers of other topics.
+func testLaggingSubscriber(t *testing.T, publisher, subscriber Backplane) {
+	release := make(chan struct{})
+	defer close(release)
+	unsubscribe, err := subscriber.Subscribe("room.1", func([]byte) { <-release })
+	if err != nil {
+		t.Fatalf("subscribe to room.1: %v", err)
+	}
+	defer unsubscribe()
+	other, _ := collect(t, subscriber, "room.2")
+	waitSubscribed(t, publisher, subscriber)
+
+	// The stalled subscriber queues some of the messages and loses the rest.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := range 1000 {
+			_ = publisher.Publish("room.1", []byte(fmt.Sprintf("message %d", i)))
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(testTimeout):
+		t.Fatalf("publish held up by a lagging subscriber for %v", testTimeout)
+	}
+	if err := publisher.Publish("room.2", []byte("other")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	expectMessages(t, other, "other")
+}
+
+func TestMemory(t *testing.T) {
+	b := NewMemory()
+	testRoundTrip(t, b, b)
+	testLaggingSubscriber(t, b, b)
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := b.Publish("room.1", []byte("closed")); !errors.Is(err, ErrClosed) {
+		t.Errorf("publish after close: got %v, want %v", err, ErrClosed)
+	}
+	if _, err := b.Subscribe("room.1", func([]byte) {}); !errors.Is(err, ErrClosed) {
+		t.Errorf("subscribe after close: got %v, want %v", err, ErrClosed)
+	}
+}
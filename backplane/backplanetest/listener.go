@@ -0,0 +1,50 @@
+// Package backplanetest provides utilities for the tests of the backplanes
+// and of the instances sharing one.
+package backplanetest
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// DroppingListener keeps the accepted connections to drop them all at once,
+// as a network failure does.
+type DroppingListener struct {
+	net.Listener
+	conns []net.Conn
+	mutex sync.Mutex
+}
+
+// Listen listens on a local port until the test ends.
+func Listen(tb testing.TB) *DroppingListener {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	dropping := &DroppingListener{Listener: listener}
+	tb.Cleanup(func() { dropping.Close() })
+	return dropping
+}
+
+func (l *DroppingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mutex.Lock()
+		l.conns = append(l.conns, conn)
+		l.mutex.Unlock()
+	}
+	return conn, err
+}
+
+// Drop closes every connection accepted so far.
+func (l *DroppingListener) Drop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
@@ -0,0 +1,190 @@
+package backplane
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Broker is a stand-in for a NATS server, which is enough to run a few
+// instances locally. It understands the subset of the protocol Nats uses
+// and matches subjects exactly, without wildcards.
+type Broker struct {
+	subscribers map[string]map[*brokerClient]map[string]bool // Subject to clients and their sids
+	mutex       sync.Mutex
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[string]map[*brokerClient]map[string]bool{}}
+}
+
+// Serve accepts connections on the listener until it fails.
+func (b *Broker) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go b.serveClient(conn)
+	}
+}
+
+// brokerClient is a connection of the broker to a single NATS client.
+type brokerClient struct {
+	conn   net.Conn
+	writer *bufio.Writer
+	subs   map[string]string // Sid to subject
+	mutex  sync.Mutex        // Guards writer
+}
+
+func (b *Broker) serveClient(conn net.Conn) {
+	client := &brokerClient{conn: conn, writer: bufio.NewWriter(conn), subs: map[string]string{}}
+	defer func() {
+		b.removeClient(client)
+		conn.Close()
+	}()
+
+	info := fmt.Sprintf("INFO {\"server_name\":\"peer-chat-broker\",\"max_payload\":%d}\r\n", maxPayload)
+	if err := client.write(info, nil); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return
+		}
+
+		op, args, _ := strings.Cut(line, " ")
+		fields := strings.Fields(args)
+		switch strings.ToUpper(op) {
+		case "CONNECT", "PONG":
+		case "PING":
+			err = client.write("PONG\r\n", nil)
+		case "SUB":
+			// SUB <subject> [queue group] <sid>
+			if len(fields) < 2 {
+				err = client.write("-ERR 'Invalid Subscription'\r\n", nil)
+				break
+			}
+			b.subscribe(client, fields[0], fields[len(fields)-1])
+		case "UNSUB":
+			if len(fields) < 1 {
+				err = client.write("-ERR 'Invalid Subscription'\r\n", nil)
+				break
+			}
+			b.unsubscribe(client, fields[0])
+		case "PUB":
+			// PUB <subject> [reply-to] <#bytes>
+			if len(fields) < 2 {
+				_ = client.write("-ERR 'Unknown Protocol Operation'\r\n", nil)
+				return
+			}
+			size, convErr := strconv.Atoi(fields[len(fields)-1])
+			if convErr != nil || size < 0 {
+				_ = client.write("-ERR 'Unknown Protocol Operation'\r\n", nil)
+				return
+			}
+			if size > maxPayload {
+				_ = client.write("-ERR 'Maximum Payload Violation'\r\n", nil)
+				return
+			}
+			payload, readErr := readPayload(reader, size)
+			if readErr != nil {
+				return
+			}
+			b.publish(fields[0], payload)
+		default:
+			_ = client.write("-ERR 'Unknown Protocol Operation'\r\n", nil)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *Broker) subscribe(client *brokerClient, subject, sid string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	client.subs[sid] = subject
+	if b.subscribers[subject] == nil {
+		b.subscribers[subject] = map[*brokerClient]map[string]bool{}
+	}
+	if b.subscribers[subject][client] == nil {
+		b.subscribers[subject][client] = map[string]bool{}
+	}
+	b.subscribers[subject][client][sid] = true
+}
+
+func (b *Broker) unsubscribe(client *brokerClient, sid string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subject, ok := client.subs[sid]
+	if !ok {
+		return
+	}
+	delete(client.subs, sid)
+	delete(b.subscribers[subject][client], sid)
+	if len(b.subscribers[subject][client]) == 0 {
+		delete(b.subscribers[subject], client)
+	}
+	if len(b.subscribers[subject]) == 0 {
+		delete(b.subscribers, subject)
+	}
+}
+
+func (b *Broker) removeClient(client *brokerClient) {
+	b.mutex.Lock()
+	sids := []string{}
+	for sid := range client.subs {
+		sids = append(sids, sid)
+	}
+	b.mutex.Unlock()
+
+	for _, sid := range sids {
+		b.unsubscribe(client, sid)
+	}
+}
+
+// publish forwards the payload to every subscription of the subject.
+// Messages are written while the broker is locked, so every client
+// receives them in the order they were published.
+func (b *Broker) publish(subject string, payload []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for client, sids := range b.subscribers[subject] {
+		for sid := range sids {
+			line := fmt.Sprintf("MSG %s %s %d\r\n", subject, sid, len(payload))
+			if err := client.write(line, payload); err != nil {
+				slog.Debug("Broker write:", "error", err)
+			}
+		}
+	}
+}
+
+func (c *brokerClient) write(line string, payload []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := c.writer.WriteString(line); err != nil {
+		return err
+	}
+	if payload != nil {
+		if _, err := c.writer.Write(payload); err != nil {
+			return err
+		}
+		if _, err := c.writer.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return c.writer.Flush()
+}
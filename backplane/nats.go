@@ -0,0 +1,349 @@
+package backplane
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout of dialing and of the handshake with a NATS server.
+const natsDialTimeout = 5 * time.Second
+
+// Timeout of a write to a NATS server, a server which does not take
+// the write in time is taken for lost.
+const natsWriteTimeout = 5 * time.Second
+
+// Delays between the attempts to reconnect to a lost NATS server, which
+// double from the first to the last one.
+const (
+	natsReconnectDelay    = time.Second
+	natsMaxReconnectDelay = 30 * time.Second
+)
+
+// The largest payload of a message, the default limit of a NATS server.
+const maxPayload = 1 << 20
+
+var (
+	ErrProtocol        = errors.New("backplane: protocol error")
+	ErrPayloadTooLarge = errors.New("backplane: payload too large")
+)
+
+// Nats is a Backplane over a NATS server. It speaks the plain text core
+// protocol, which a real NATS server as well as the stand-in Broker
+// understand. Topics are used as subjects, so they must not contain
+// spaces or wildcards.
+//
+// When the connection to the server is lost, Nats reconnects and
+// subscribes to the topics again. Messages published in the meantime
+// are lost, so the loss and the return of the connection are reported
+// to the connection handler.
+type Nats struct {
+	addr          string
+	conn          net.Conn
+	writer        *bufio.Writer
+	writeMutex    sync.Mutex // Guards conn and writer
+	subscriptions map[int]*subscription
+	lastSid       int
+	isClosed      bool
+	onConnection  func(connected bool)
+	mutex         sync.Mutex    // Guards subscriptions, lastSid, isClosed and onConnection
+	done          chan struct{} // Is closed when the backplane is closed
+}
+
+// DialNats connects to the NATS server at the given address.
+func DialNats(addr string) (*Nats, error) {
+	n := &Nats{
+		addr:          addr,
+		subscriptions: map[int]*subscription{},
+		onConnection:  func(bool) {},
+		done:          make(chan struct{}),
+	}
+	reader, err := n.connect()
+	if err != nil {
+		return nil, err
+	}
+	go n.run(reader)
+	return n, nil
+}
+
+func (n *Nats) Publish(topic string, data []byte) error {
+	if len(data) > maxPayload {
+		return fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(data))
+	}
+	return n.write(fmt.Sprintf("PUB %s %d\r\n", topic, len(data)), data)
+}
+
+func (n *Nats) Subscribe(topic string, handle func(data []byte)) (func(), error) {
+	n.mutex.Lock()
+	if n.isClosed {
+		n.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	n.lastSid++
+	sid := n.lastSid
+	s := newSubscription(topic, handle)
+	n.subscriptions[sid] = s
+	n.mutex.Unlock()
+
+	if err := n.write(fmt.Sprintf("SUB %s %d\r\n", topic, sid), nil); err != nil {
+		n.unsubscribe(sid)
+		return nil, err
+	}
+
+	unsubscribe := func() {
+		if n.unsubscribe(sid) {
+			_ = n.write(fmt.Sprintf("UNSUB %d\r\n", sid), nil)
+		}
+	}
+	return unsubscribe, nil
+}
+
+func (n *Nats) SetOnConnection(onConnection func(connected bool)) {
+	if onConnection != nil {
+		n.mutex.Lock()
+		n.onConnection = onConnection
+		n.mutex.Unlock()
+	}
+}
+
+func (n *Nats) Close() error {
+	n.mutex.Lock()
+	if n.isClosed {
+		n.mutex.Unlock()
+		return nil
+	}
+	n.isClosed = true
+	close(n.done)
+	for sid, s := range n.subscriptions {
+		s.cancel()
+		delete(n.subscriptions, sid)
+	}
+	n.mutex.Unlock()
+
+	// The connection is already closed if it has been lost.
+	n.writeMutex.Lock()
+	defer n.writeMutex.Unlock()
+	if err := n.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// unsubscribe stops the subscription, it reports whether it was active.
+func (n *Nats) unsubscribe(sid int) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	s, ok := n.subscriptions[sid]
+	if ok {
+		delete(n.subscriptions, sid)
+		s.cancel()
+	}
+	return ok
+}
+
+// connect dials the server, makes the handshake and subscribes to
+// the topics of the active subscriptions. It returns the reader of
+// the new connection.
+func (n *Nats) connect() (*bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", n.addr, natsDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	line, err := readLine(reader)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return nil, fmt.Errorf("%w: expected INFO, got '%s'", ErrProtocol, line)
+	}
+
+	// The subscriptions are made again while no other write may come
+	// in between and reach the server before them.
+	n.writeMutex.Lock()
+	defer n.writeMutex.Unlock()
+
+	if n.closed() {
+		conn.Close()
+		return nil, ErrClosed
+	}
+	writer := bufio.NewWriter(conn)
+	_, _ = writer.WriteString("CONNECT {\"verbose\":false,\"pedantic\":false}\r\n")
+	n.mutex.Lock()
+	for sid, s := range n.subscriptions {
+		_, _ = fmt.Fprintf(writer, "SUB %s %d\r\n", s.topic, sid)
+	}
+	n.mutex.Unlock()
+	if err := writer.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	n.conn = conn
+	n.writer = writer
+	return reader, nil
+}
+
+// write sends the protocol line followed by the payload, if there is any.
+// A failed write closes the connection, which is then made again.
+func (n *Nats) write(line string, payload []byte) error {
+	n.writeMutex.Lock()
+	defer n.writeMutex.Unlock()
+
+	_ = n.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	err := n.writeTo(line, payload)
+	if err != nil {
+		_ = n.conn.Close()
+	}
+	return err
+}
+
+func (n *Nats) writeTo(line string, payload []byte) error {
+	if _, err := n.writer.WriteString(line); err != nil {
+		return err
+	}
+	if payload != nil {
+		if _, err := n.writer.Write(payload); err != nil {
+			return err
+		}
+		if _, err := n.writer.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return n.writer.Flush()
+}
+
+// run processes the messages of the server. When the connection is lost,
+// it reconnects until the backplane is closed.
+func (n *Nats) run(reader *bufio.Reader) {
+	for {
+		err := n.read(reader)
+		n.writeMutex.Lock()
+		_ = n.conn.Close()
+		n.writeMutex.Unlock()
+		if n.closed() {
+			return
+		}
+
+		slog.Error("Backplane connection lost:", "addr", n.addr, "error", err)
+		n.notify(false)
+		if reader = n.reconnect(); reader == nil {
+			return
+		}
+		slog.Info("Backplane reconnected:", "addr", n.addr)
+		n.notify(true)
+	}
+}
+
+// reconnect tries to connect to the server until it succeeds or
+// the backplane is closed, then it returns nil.
+func (n *Nats) reconnect() *bufio.Reader {
+	delay := natsReconnectDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-n.done:
+			return nil
+		}
+
+		reader, err := n.connect()
+		if err == nil {
+			return reader
+		}
+		slog.Warn("Backplane reconnect:", "addr", n.addr, "error", err)
+		delay = min(2*delay, natsMaxReconnectDelay)
+	}
+}
+
+// read processes the messages of the server until the connection fails.
+// A protocol error ends the connection, since the rest of it can no longer
+// be parsed.
+func (n *Nats) read(reader *bufio.Reader) error {
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(args)
+			if len(fields) < 3 {
+				return fmt.Errorf("%w: '%s'", ErrProtocol, line)
+			}
+			sid, err1 := strconv.Atoi(fields[1])
+			size, err2 := strconv.Atoi(fields[len(fields)-1])
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("%w: '%s'", ErrProtocol, line)
+			}
+			payload, err := readPayload(reader, size)
+			if err != nil {
+				return err
+			}
+			n.mutex.Lock()
+			s, ok := n.subscriptions[sid]
+			n.mutex.Unlock()
+			if ok {
+				s.deliver(payload)
+			}
+		case "PING":
+			if err := n.write("PONG\r\n", nil); err != nil {
+				return err
+			}
+		case "-ERR":
+			slog.Error("Backplane server error:", "error", args)
+		}
+	}
+}
+
+func (n *Nats) closed() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.isClosed
+}
+
+// notify reports the loss or the return of the connection.
+func (n *Nats) notify(connected bool) {
+	n.mutex.Lock()
+	onConnection := n.onConnection
+	n.mutex.Unlock()
+	onConnection(connected)
+}
+
+// readLine reads a protocol line without its CRLF ending.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readPayload reads a payload of the given size followed by CRLF. The size
+// comes from the wire, so it is checked before anything is allocated.
+func readPayload(reader *bufio.Reader, size int) ([]byte, error) {
+	if size < 0 || size > maxPayload {
+		return nil, fmt.Errorf("%w: invalid payload size %d", ErrProtocol, size)
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	return payload[:size], nil
+}
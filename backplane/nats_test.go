@@ -0,0 +1,202 @@
+package backplane
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/branow/peer-chat/backplane/backplanetest"
+)
+
+// startBroker serves a broker on a local port and returns its address.
+func startBroker(t *testing.T) string {
+	return startDroppingBroker(t).Addr().String()
+}
+
+// startDroppingBroker serves a broker whose connections may be dropped.
+func startDroppingBroker(t *testing.T) *backplanetest.DroppingListener {
+	listener := backplanetest.Listen(t)
+	go NewBroker().Serve(listener)
+	return listener
+}
+
+func dialNats(t *testing.T, addr string) *Nats {
+	t.Helper()
+	n, err := DialNats(addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// dialRaw connects to the broker and reads its INFO line.
+func dialRaw(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(testTimeout))
+
+	reader := bufio.NewReader(conn)
+	if line, err := readLine(reader); err != nil || !strings.HasPrefix(line, "INFO") {
+		t.Fatalf("handshake: got %q, %v", line, err)
+	}
+	return conn, reader
+}
+
+func TestNats(t *testing.T) {
+	addr := startBroker(t)
+	testRoundTrip(t, dialNats(t, addr), dialNats(t, addr))
+}
+
+func TestNatsLaggingSubscriber(t *testing.T) {
+	addr := startBroker(t)
+	testLaggingSubscriber(t, dialNats(t, addr), dialNats(t, addr))
+}
+
+func TestNatsPublishTooLarge(t *testing.T) {
+	n := dialNats(t, startBroker(t))
+
+	err := n.Publish("room.1", make([]byte, maxPayload+1))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("publish: got %v, want %v", err, ErrPayloadTooLarge)
+	}
+}
+
+func TestBrokerRejectsInvalidPayloadSize(t *testing.T) {
+	addr := startBroker(t)
+	for _, pub := range []string{"PUB room.1 -5", "PUB room.1 99999999999", "PUB room.1 size"} {
+		t.Run(pub, func(t *testing.T) {
+			conn, reader := dialRaw(t, addr)
+			if _, err := conn.Write([]byte(pub + "\r\n")); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if line, err := readLine(reader); err != nil || !strings.HasPrefix(line, "-ERR") {
+				t.Errorf("reply: got %q, %v, want -ERR", line, err)
+			}
+			if line, err := readLine(reader); err == nil {
+				t.Errorf("connection still open, got %q", line)
+			}
+		})
+	}
+
+	// The broker keeps serving the other clients.
+	testRoundTrip(t, dialNats(t, addr), dialNats(t, addr))
+}
+
+func TestNatsRejectsInvalidMessage(t *testing.T) {
+	for _, msg := range []string{"MSG room.1 1 -5", "MSG room.1 1 99999999999", "MSG room.1"} {
+		t.Run(msg, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			defer listener.Close()
+
+			// A server which sends the malformed message once the client
+			// has subscribed.
+			closed := make(chan error, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					closed <- err
+					return
+				}
+				defer conn.Close()
+				_, _ = conn.Write([]byte("INFO {}\r\n"))
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := readLine(reader)
+					if err != nil {
+						closed <- err
+						return
+					}
+					if strings.HasPrefix(line, "SUB") {
+						_, _ = conn.Write([]byte(msg + "\r\n"))
+					}
+				}
+			}()
+
+			n := dialNats(t, listener.Addr().String())
+			if _, err := n.Subscribe("room.1", func([]byte) {}); err != nil {
+				t.Fatalf("subscribe: %v", err)
+			}
+			select {
+			case <-closed:
+			case <-time.After(testTimeout):
+				t.Fatal("client kept the connection after a malformed message")
+			}
+		})
+	}
+}
+
+// watchConnection returns the channel of the connection reports.
+func watchConnection(n *Nats) <-chan bool {
+	connection := make(chan bool, 10)
+	n.SetOnConnection(func(connected bool) { connection <- connected })
+	return connection
+}
+
+// expectConnection waits for the connection to be reported as lost,
+// then as back.
+func expectConnection(t *testing.T, connection <-chan bool, want ...bool) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-connection:
+			if got != w {
+				t.Fatalf("connected: got %v, want %v", got, w)
+			}
+		case <-time.After(natsReconnectDelay + testTimeout):
+			t.Fatalf("connected not reported as %v", w)
+		}
+	}
+}
+
+func TestNatsReconnects(t *testing.T) {
+	broker := startDroppingBroker(t)
+	publisher := dialNats(t, broker.Addr().String())
+	subscriber := dialNats(t, broker.Addr().String())
+	published := watchConnection(publisher)
+	subscribed := watchConnection(subscriber)
+	messages, _ := collect(t, subscriber, "room.1")
+	waitSubscribed(t, publisher, subscriber)
+
+	broker.Drop()
+	expectConnection(t, published, false, true)
+	expectConnection(t, subscribed, false, true)
+
+	// The subscriptions are made again once the connection is back.
+	waitSubscribed(t, publisher, subscriber)
+	if err := publisher.Publish("room.1", []byte("back")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	expectMessages(t, messages, "back")
+}
+
+func TestNatsStopsReconnectingOnClose(t *testing.T) {
+	broker := startDroppingBroker(t)
+	n := dialNats(t, broker.Addr().String())
+	connection := watchConnection(n)
+
+	broker.Drop()
+	expectConnection(t, connection, false)
+	if err := n.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	select {
+	case <-connection:
+		t.Error("reconnected after close")
+	case <-time.After(natsReconnectDelay + 200*time.Millisecond):
+	}
+	if err := n.Publish("room.1", []byte("closed")); err == nil {
+		t.Error("published after close")
+	}
+}
@@ -0,0 +1,128 @@
+// Package certs provides the TLS certificates the server serves HTTPS with,
+// either from files which are reloaded when they change or self-signed
+// for development.
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNoCertificate = errors.New("certs: no certificate loaded")
+
+// How often the files of a certificate are checked for changes.
+const reloadInterval = 10 * time.Second
+
+// Reloader serves the certificate of a pair of PEM files and loads it
+// again once the files change, so renewed certificates are picked up
+// without a restart. The files are checked in the background, a pair
+// which fails to load keeps the previous certificate in use.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time // Latest modification time of the files
+	mutex    sync.RWMutex
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReloader loads the certificate of the files and starts checking them
+// for changes, it fails if they cannot be loaded at first.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	return newReloader(certFile, keyFile, reloadInterval)
+}
+
+func newReloader(certFile, keyFile string, interval time.Duration) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, done: make(chan struct{})}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	go r.watch(interval)
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, it suits
+// tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.cert == nil {
+		return nil, ErrNoCertificate
+	}
+	return r.cert, nil
+}
+
+// Close stops checking the files, the current certificate is still served.
+func (r *Reloader) Close() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+// watch reloads the certificate at every interval until the reloader
+// is closed.
+func (r *Reloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reload()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// reload loads the certificate again if its files have changed.
+func (r *Reloader) reload() {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		slog.Error("Check certificate:", "cert", r.certFile, "error", err)
+		return
+	}
+	if !modTime.After(r.modTime) {
+		return
+	}
+	if err := r.load(modTime); err != nil {
+		slog.Error("Reload certificate:", "cert", r.certFile, "error", err)
+		return
+	}
+	slog.Info("Reloaded certificate:", "cert", r.certFile)
+}
+
+func (r *Reloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.cert = &cert
+	r.mutex.Unlock()
+	r.modTime = modTime
+	return nil
+}
+
+// filesModTime returns the latest modification time of the files.
+func (r *Reloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
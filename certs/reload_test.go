@@ -0,0 +1,107 @@
+package certs
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testInterval lets the tests see the files checked quickly.
+const testInterval = 10 * time.Millisecond
+
+// writeTestPair writes a certificate for the name to the files, modified
+// at the given time.
+func writeTestPair(tb testing.TB, certFile, keyFile, name string, modTime time.Time) {
+	tb.Helper()
+	if err := writeSelfSigned(certFile, keyFile, []string{name}, []net.IP{net.IPv6loopback}); err != nil {
+		tb.Fatalf("write certificate: %v", err)
+	}
+	touch(tb, modTime, certFile, keyFile)
+}
+
+func touch(tb testing.TB, modTime time.Time, files ...string) {
+	tb.Helper()
+	for _, file := range files {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			tb.Fatalf("touch %s: %v", file, err)
+		}
+	}
+}
+
+// servedCert returns the DER of the certificate the reloader serves.
+func servedCert(tb testing.TB, r *Reloader) []byte {
+	tb.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		tb.Fatalf("get certificate: %v", err)
+	}
+	return cert.Certificate[0]
+}
+
+// fileCert returns the DER of the certificate of the file.
+func fileCert(tb testing.TB, certFile string) []byte {
+	tb.Helper()
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		tb.Fatalf("read certificate: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		tb.Fatalf("no certificate in %s", certFile)
+	}
+	return block.Bytes
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := newReloader(certFile, keyFile, testInterval); err == nil {
+		t.Fatal("reloader of missing files: got no error")
+	}
+
+	start := time.Now().Add(-time.Hour)
+	writeTestPair(t, certFile, keyFile, "first", start)
+	r, err := newReloader(certFile, keyFile, testInterval)
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+	t.Cleanup(r.Close)
+	first := fileCert(t, certFile)
+	if got := servedCert(t, r); !bytes.Equal(got, first) {
+		t.Fatal("served certificate is not the one of the files")
+	}
+
+	// A renewed pair is served once the files are checked.
+	writeTestPair(t, certFile, keyFile, "second", start.Add(time.Minute))
+	second := fileCert(t, certFile)
+	timeout := time.After(time.Second)
+	for !bytes.Equal(servedCert(t, r), second) {
+		select {
+		case <-timeout:
+			t.Fatal("renewed certificate not served in 1s")
+		case <-time.After(testInterval):
+		}
+	}
+
+	// A pair which fails to load keeps the previous one in use.
+	if err := os.WriteFile(certFile, []byte("broken"), 0o644); err != nil {
+		t.Fatalf("break certificate: %v", err)
+	}
+	touch(t, start.Add(2*time.Minute), certFile)
+	time.Sleep(5 * testInterval)
+	if got := servedCert(t, r); !bytes.Equal(got, second) {
+		t.Error("broken certificate replaced the served one")
+	}
+
+	// A closed reloader stops checking the files.
+	r.Close()
+	writeTestPair(t, certFile, keyFile, "third", start.Add(3*time.Minute))
+	time.Sleep(5 * testInterval)
+	if got := servedCert(t, r); !bytes.Equal(got, second) {
+		t.Error("certificate reloaded after closing")
+	}
+}
@@ -0,0 +1,86 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+	certFile, keyFile, err := SelfSigned(dir)
+	if err != nil {
+		t.Fatalf("self-signed: %v", err)
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load self-signed pair: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	names, ips := localHosts()
+	for _, name := range names {
+		if !slices.Contains(cert.DNSNames, name) {
+			t.Errorf("name %q not covered by %q", name, cert.DNSNames)
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+			t.Errorf("address %v not covered by %v", ip, cert.IPAddresses)
+		}
+	}
+
+	// The cached certificate is used again while it covers the host.
+	cached := fileCert(t, certFile)
+	if _, _, err := SelfSigned(dir); err != nil {
+		t.Fatalf("self-signed again: %v", err)
+	}
+	if !bytes.Equal(fileCert(t, certFile), cached) {
+		t.Error("cached certificate made again")
+	}
+
+	// A certificate which does not cover the host anymore is made again.
+	writeTestPair(t, certFile, keyFile, "other", time.Now())
+	if _, _, err := SelfSigned(dir); err != nil {
+		t.Fatalf("self-signed over another certificate: %v", err)
+	}
+	if cert := fileCert(t, certFile); bytes.Equal(cert, cached) || !cachedCertCovers(certFile, names, ips) {
+		t.Error("certificate of another host not made again")
+	}
+}
+
+func TestCachedCertCovers(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if cachedCertCovers(certFile, nil, nil) {
+		t.Error("missing certificate covers the host")
+	}
+	names := []string{"localhost", "box"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.ParseIP("192.0.2.7")}
+	if err := writeSelfSigned(certFile, keyFile, names, ips); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		names []string
+		ips   []net.IP
+		want  bool
+	}{
+		{"same host", names, ips, true},
+		{"part of the host", names[:1], ips[:1], true},
+		{"new name", append(names, "renamed"), ips, false},
+		{"new address", names, append(ips, net.ParseIP("192.0.2.8")), false},
+	} {
+		if got := cachedCertCovers(certFile, test.names, test.ips); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
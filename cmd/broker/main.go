@@ -0,0 +1,30 @@
+// Broker is a stand-in for a NATS server, which lets several instances
+// of the server share rooms locally. Start it and pass its address to
+// every instance with the -backplane flag.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/branow/peer-chat/backplane"
+)
+
+func main() {
+	addr := flag.String("addr", ":4222", "Address to listen on")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		slog.Error("Broker startup failed:", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("Broker started:", "addr", listener.Addr())
+	if err := backplane.NewBroker().Serve(listener); err != nil {
+		slog.Error("Broker stopped:", "error", err)
+		os.Exit(1)
+	}
+}
@@ -2,77 +2,319 @@ package config
 
 import (
 	"errors"
-	"flag"
+	"fmt"
 	"log/slog"
-	"sync"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 )
 
 var (
-	ErrInvalidLogLevel = errors.New("config: invalid log level, must be [-4, 0, 4, 8]")
-	ErrInvalidPort     = errors.New("config: invalid port, must be between 1 and 65535")
+	ErrInvalidLogLevel      = errors.New("config: invalid log level, must be [-4, 0, 4, 8]")
+	ErrInvalidPort          = errors.New("config: invalid port, must be between 1 and 65535")
+	ErrInvalidSignalTimeout = errors.New("config: invalid signaling timeout, must be positive")
+	ErrInvalidResumeTimeout = errors.New("config: invalid resume timeout, must not be negative")
+	ErrInvalidKeepAlive     = errors.New("config: invalid keep-alive, intervals must be positive " +
+		"and the ping interval shorter than the pong timeout")
+	ErrInvalidCertificate = errors.New("config: invalid certificate, both the certificate " +
+		"and the key files must be set")
+	ErrInvalidRateLimit = errors.New("config: invalid rate limit, the rate must not be negative " +
+		"and the burst must be positive")
+	ErrInvalidIceServer = errors.New("config: invalid ICE server, must be a stun:, stuns:, turn: " +
+		"or turns: URL")
+	ErrMissingTurnCredentials = errors.New("config: missing TURN credentials, TURN servers need " +
+		"a username and a credential")
+	ErrInvalidDir           = errors.New("config: invalid directory, must exist")
+	ErrInvalidShutdownGrace = errors.New("config: invalid shutdown grace period, must not be negative")
 )
 
-var (
-	cfg  *config
-	once sync.Once
+const (
+	defaultPort          = 8080
+	defaultLogLevel      = int(slog.LevelInfo)
+	defaultSecurity      = true
+	defaultPingInterval  = 25 * time.Second
+	defaultPongTimeout   = 60 * time.Second
+	defaultWriteTimeout  = 10 * time.Second
+	defaultOfferTimeout  = 15 * time.Second
+	defaultAnswerTimeout = 15 * time.Second
+	defaultResumeTimeout = 30 * time.Second
+	defaultShutdownGrace = 10 * time.Second
+	defaultWebDir        = "./web"
+	defaultLocalesDir    = "./locales"
 )
 
-const (
-	defaultPort     = 8080
-	defaultLogLevel = int(slog.LevelInfo)
-	defaultSecurity = true
+// Default rate limits of the requests of a client address.
+var (
+	defaultCreateRateLimit = RateLimit{PerMinute: 10, Burst: 5}
+	defaultJoinRateLimit   = RateLimit{PerMinute: 60, Burst: 20}
 )
 
-func GetConfig() *config {
-	once.Do(func() {
-		cfg = initConfig()
-	})
-	return cfg
+// Default ICE servers of peer connections.
+var defaultIceServers = []string{"stun:stun0.l.google.com:19302", "stun:stun2.l.google.com:19302"}
+
+// RateLimit limits the requests of a client address to a route with
+// a token bucket, which holds up to the burst of requests and refills at
+// the rate. A zero rate leaves the requests unlimited.
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// IceServer is a STUN or TURN server which peers find a route to each
+// other with. TURN servers need credentials, STUN servers do not.
+type IceServer struct {
+	URLs       []string
+	Username   string
+	Credential string
+}
+
+// Config is the configuration of the server, see Load.
+type Config struct {
+	port           int
+	logLevel       int
+	secured        bool
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	writeTimeout   time.Duration
+	offerTimeout   time.Duration
+	answerTimeout  time.Duration
+	resumeTimeout  time.Duration
+	shutdownGrace  time.Duration
+	storePath      string
+	backplaneAddr  string
+	secret         string
+	createLimit    RateLimit
+	joinLimit      RateLimit
+	origins        []string
+	certFile       string
+	keyFile        string
+	devTLS         bool
+	devTLSDir      string
+	redirectPort   int
+	iceServers     []string
+	turnUsername   string
+	turnCredential string
+	webDir         string
+	localesDir     string
+}
+
+func (c Config) Port() int {
+	return c.port
+}
+
+func (c Config) LogLevel() int {
+	return c.logLevel
+}
+
+func (c Config) Secured() bool {
+	return c.secured
+}
+
+// PingInterval is how often WebSocket clients are pinged.
+func (c Config) PingInterval() time.Duration {
+	return c.pingInterval
+}
+
+// PongTimeout is how long a WebSocket client may stay silent
+// before it is considered gone.
+func (c Config) PongTimeout() time.Duration {
+	return c.pongTimeout
+}
+
+// WriteTimeout limits a single write to a WebSocket client.
+func (c Config) WriteTimeout() time.Duration {
+	return c.writeTimeout
+}
+
+// OfferTimeout is how long a peer may take to reply to an offer request.
+func (c Config) OfferTimeout() time.Duration {
+	return c.offerTimeout
+}
+
+// AnswerTimeout is how long a peer may take to answer an offer.
+func (c Config) AnswerTimeout() time.Duration {
+	return c.answerTimeout
+}
+
+// ResumeTimeout is how long a dropped WebSocket client keeps its slot
+// in a room waiting to be resumed.
+func (c Config) ResumeTimeout() time.Duration {
+	return c.resumeTimeout
+}
+
+// ShutdownGrace is how long signaling under way may take to end when
+// the server shuts down.
+func (c Config) ShutdownGrace() time.Duration {
+	return c.shutdownGrace
+}
+
+// StorePath is the file which keeps rooms across restarts,
+// empty if rooms are kept in memory.
+func (c Config) StorePath() string {
+	return c.storePath
+}
+
+// BackplaneAddr is the address of the NATS server which the instances
+// share rooms over, empty if rooms are kept to this instance.
+func (c Config) BackplaneAddr() string {
+	return c.backplaneAddr
+}
+
+// Secret is the key which signs join tickets of password-protected rooms,
+// empty if every instance makes up its own.
+func (c Config) Secret() string {
+	return c.secret
+}
+
+// CreateRateLimit limits how many rooms a client address may create.
+func (c Config) CreateRateLimit() RateLimit {
+	return c.createLimit
+}
+
+// JoinRateLimit limits how often a client address may join rooms.
+func (c Config) JoinRateLimit() RateLimit {
+	return c.joinLimit
+}
+
+// AllowedOrigins are the origins of the pages which may connect to rooms,
+// besides the origin of the server. A "*." prefix of a host matches its
+// subdomains, a lone "*" matches any origin.
+func (c Config) AllowedOrigins() []string {
+	return append([]string{}, c.origins...)
+}
+
+// CertFile and KeyFile are the PEM files of the certificate the server
+// serves HTTPS with, empty if it does not serve HTTPS with its own one.
+func (c Config) CertFile() string {
+	return c.certFile
+}
+
+func (c Config) KeyFile() string {
+	return c.keyFile
+}
+
+// DevTLS tells whether the server serves HTTPS with a self-signed
+// certificate, which is cached in DevTLSDir.
+func (c Config) DevTLS() bool {
+	return c.devTLS
 }
 
-func initConfig() *config {
-	port := flag.Int("p", defaultPort, "Server port")
-	logLevel := flag.Int("log", int(defaultLogLevel), "Log Level [-4,0,4,8]")
-	ssl := flag.Bool("s", true, "Secured connection (true/false)")
-	flag.Parse()
+func (c Config) DevTLSDir() string {
+	return c.devTLSDir
+}
+
+// RedirectPort is the port of the listener which redirects HTTP requests
+// to HTTPS, zero if there is none.
+func (c Config) RedirectPort() int {
+	return c.redirectPort
+}
 
-	if err := validatePort(*port); err != nil {
-		*port = defaultPort
-		slog.Error("Validate port:", "error", err, "default value", defaultPort)
+// IceServers are the servers peers find a route to each other with.
+// The STUN servers come first, then the TURN servers with the credentials.
+func (c Config) IceServers() []IceServer {
+	stun := IceServer{}
+	turn := IceServer{Username: c.turnUsername, Credential: c.turnCredential}
+	for _, url := range c.iceServers {
+		if isTurnURL(url) {
+			turn.URLs = append(turn.URLs, url)
+		} else {
+			stun.URLs = append(stun.URLs, url)
+		}
 	}
 
-	if err := validateLogLevel(*logLevel); err != nil {
-		*logLevel = defaultLogLevel
-		slog.Error("Validate log level:", "error", err, "default value", defaultLogLevel)
+	servers := []IceServer{}
+	for _, server := range []IceServer{stun, turn} {
+		if len(server.URLs) > 0 {
+			servers = append(servers, server)
+		}
 	}
+	return servers
+}
 
-	return &config{
-		port:     *port,
-		logLevel: *logLevel,
-		secured:  *ssl,
+// WebDir is the directory with the templates and static files of the site.
+func (c Config) WebDir() string {
+	return c.webDir
+}
+
+// LocalesDir is the directory with the translations of the site.
+func (c Config) LocalesDir() string {
+	return c.localesDir
+}
+
+// validate reports every invalid field of the config at once.
+func (c *Config) validate() []error {
+	errs := []error{
+		fieldError(validatePort(c.port), "port", c.port),
+		fieldError(validateLogLevel(c.logLevel), "log-level", c.logLevel),
+		fieldError(validateKeepAlive(c.pingInterval, c.pongTimeout, c.writeTimeout),
+			"ping, pong, write", fmt.Sprint(c.pingInterval, ", ", c.pongTimeout, ", ", c.writeTimeout)),
+		fieldError(validateSignalTimeout(c.offerTimeout), "offer", c.offerTimeout),
+		fieldError(validateSignalTimeout(c.answerTimeout), "answer", c.answerTimeout),
+		fieldError(validateResumeTimeout(c.resumeTimeout), "resume", c.resumeTimeout),
+		fieldError(validateShutdownGrace(c.shutdownGrace), "shutdown-grace", c.shutdownGrace),
+		fieldError(validateRateLimit(c.createLimit), "create-rate, create-burst", c.createLimit),
+		fieldError(validateRateLimit(c.joinLimit), "join-rate, join-burst", c.joinLimit),
+		fieldError(validateCertificate(c.certFile, c.keyFile), "cert, key", c.certFile+", "+c.keyFile),
+		fieldError(validateDir(c.webDir), "web-dir", c.webDir),
+		fieldError(validateDir(c.localesDir), "locales-dir", c.localesDir),
+	}
+	if c.redirectPort != 0 {
+		errs = append(errs, fieldError(validatePort(c.redirectPort), "redirect", c.redirectPort))
+	}
+	for _, url := range c.iceServers {
+		errs = append(errs, fieldError(validateIceServer(url), "ice-servers", url))
 	}
+	if slices.ContainsFunc(c.iceServers, isTurnURL) && (c.turnUsername == "" || c.turnCredential == "") {
+		errs = append(errs, fieldError(ErrMissingTurnCredentials, "turn-username, turn-credential", "empty"))
+	}
+	return errs
 }
 
-type config struct {
-	port     int
-	logLevel int
-	secured  bool
+// reconcile settles the fields which contradict each other but still let
+// the server run, it logs a warning for each.
+func (c *Config) reconcile() {
+	serveTLS := c.certFile != "" || c.devTLS
+	if serveTLS && !c.secured {
+		c.secured = true
+		slog.Warn("Reconcile config:", "warning", "a server which serves HTTPS itself is always secured")
+	}
+	if c.certFile != "" && c.devTLS {
+		c.devTLS = false
+		slog.Warn("Reconcile config:", "warning", "the certificate files are served instead of a self-signed one")
+	}
+	if !serveTLS && c.redirectPort != 0 {
+		c.redirectPort = 0
+		slog.Warn("Reconcile config:", "warning", "no HTTPS is served to redirect to, the redirect is off")
+	}
 }
 
-func (c config) Port() int {
-	return c.port
+// fieldError names the field and the value of the validation error,
+// it returns nil if there is no error.
+func fieldError(err error, field string, value any) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w (%s: %v)", err, field, value)
 }
 
-func (c config) LogLevel() int {
-	return c.logLevel
+// defaultDevTLSDir returns the cache directory of the user, or the working
+// directory if there is none.
+func defaultDevTLSDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".peer-chat"
+	}
+	return filepath.Join(dir, "peer-chat")
 }
 
-func (c config) Secured() bool {
-	return c.secured
+func isTurnURL(url string) bool {
+	return strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:")
 }
 
 func validatePort(port int) error {
-	if port == 0 || port > 65535 {
+	if port <= 0 || port > 65535 {
 		return ErrInvalidPort
 	}
 	return nil
@@ -90,3 +332,62 @@ func validateLogLevel(levelCode int) error {
 	}
 	return nil
 }
+
+func validateKeepAlive(pingInterval, pongTimeout, writeTimeout time.Duration) error {
+	if pingInterval <= 0 || pongTimeout <= 0 || writeTimeout <= 0 || pingInterval >= pongTimeout {
+		return ErrInvalidKeepAlive
+	}
+	return nil
+}
+
+func validateSignalTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return ErrInvalidSignalTimeout
+	}
+	return nil
+}
+
+func validateResumeTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return ErrInvalidResumeTimeout
+	}
+	return nil
+}
+
+func validateShutdownGrace(grace time.Duration) error {
+	if grace < 0 {
+		return ErrInvalidShutdownGrace
+	}
+	return nil
+}
+
+func validateRateLimit(limit RateLimit) error {
+	if limit.PerMinute < 0 || limit.Burst <= 0 {
+		return ErrInvalidRateLimit
+	}
+	return nil
+}
+
+func validateCertificate(certFile, keyFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return ErrInvalidCertificate
+	}
+	return nil
+}
+
+func validateIceServer(url string) error {
+	for _, scheme := range []string{"stun:", "stuns:", "turn:", "turns:"} {
+		if rest, ok := strings.CutPrefix(url, scheme); ok && rest != "" {
+			return nil
+		}
+	}
+	return ErrInvalidIceServer
+}
+
+func validateDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return ErrInvalidDir
+	}
+	return nil
+}
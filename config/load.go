@@ -0,0 +1,366 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidConfigFile = errors.New("config: invalid config file")
+	ErrUnknownConfigKey  = errors.New("config: unknown key")
+	ErrNestedConfigValue = errors.New("config: nested values are not supported")
+	ErrInvalidValue      = errors.New("config: invalid value")
+)
+
+// The prefix of the environment variables of the config.
+const envPrefix = "PEERCHAT_"
+
+// Short flags of the settings, the files and the environment use the long
+// names only.
+var shorthands = map[string]string{
+	"p":   "port",
+	"s":   "secured",
+	"log": "log-level",
+}
+
+// Load loads the config in layers, each overriding the one before: the
+// defaults, the config file, the environment and the flags of the args.
+//
+// The config file is set by the -config flag or the PEERCHAT_CONFIG
+// variable. Its keys are named like the long flags and it is flat, either
+// a .json file of an object like {"port": 8443, "origins": ["a", "b"]}, or
+// a .yaml, .yml or .toml file of a "port: 8443" or "port = 8443" line per
+// key. The latter are not parsed as YAML or TOML, only their flat subset
+// of single line values and inline lists is read.
+// An environment variable of a key is its name in upper snake case with
+// the PEERCHAT_ prefix, like PEERCHAT_JOIN_RATE for join-rate.
+//
+// Load reports every invalid value at once, the server must not start
+// with any of them. It returns flag.ErrHelp if the args ask for help.
+func Load(args []string) (*Config, error) {
+	c := &Config{}
+	var configFile string
+	fs := c.flagSet(&configFile)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// The flags override the other layers, which skip the keys they set.
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[settingName(f.Name)] = true
+	})
+	if !set["config"] {
+		configFile = os.Getenv(envName("config"))
+	}
+
+	errs := []error{}
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrInvalidConfigFile, configFile, err)
+		}
+		errs = append(errs, applySettings(fs, values, set, configFile)...)
+	}
+
+	env := map[string]string{}
+	for _, name := range settingNames(fs) {
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			env[name] = value
+		}
+	}
+	errs = append(errs, applySettings(fs, env, set, "environment")...)
+	errs = append(errs, c.validate()...)
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	c.reconcile()
+	return c, nil
+}
+
+// flagSet returns the flags of the settings, bound to the fields of
+// the config and set to the defaults.
+func (c *Config) flagSet(configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("peer-chat", flag.ContinueOnError)
+	c.origins = []string{}
+	c.iceServers = append([]string{}, defaultIceServers...)
+
+	fs.StringVar(configFile, "config", "", "Flat config file, a JSON object or \"key: value\" (.yaml) or \"key = value\" (.toml) lines")
+	fs.IntVar(&c.port, "port", defaultPort, "Server port")
+	fs.IntVar(&c.logLevel, "log-level", defaultLogLevel, "Log Level [-4,0,4,8]")
+	fs.BoolVar(&c.secured, "secured", defaultSecurity, "Secured connection (true/false)")
+	fs.DurationVar(&c.pingInterval, "ping", defaultPingInterval, "WebSocket ping interval")
+	fs.DurationVar(&c.pongTimeout, "pong", defaultPongTimeout, "WebSocket pong timeout")
+	fs.DurationVar(&c.writeTimeout, "write", defaultWriteTimeout, "WebSocket write timeout")
+	fs.DurationVar(&c.offerTimeout, "offer", defaultOfferTimeout, "Signaling timeout for an offer")
+	fs.DurationVar(&c.answerTimeout, "answer", defaultAnswerTimeout, "Signaling timeout for an answer")
+	fs.DurationVar(&c.resumeTimeout, "resume", defaultResumeTimeout, "Grace period to resume a dropped WebSocket, 0 disables it")
+	fs.DurationVar(&c.shutdownGrace, "shutdown-grace", defaultShutdownGrace, "How long signaling under way may take to end on shutdown")
+	fs.StringVar(&c.storePath, "store", "", "File to keep rooms in across restarts, rooms are kept in memory if empty")
+	fs.StringVar(&c.backplaneAddr, "backplane", "", "Address of a NATS server to share rooms with other instances")
+	fs.StringVar(&c.secret, "secret", "", "Key to sign join tickets, shared by all instances, random if empty")
+	fs.Float64Var(&c.createLimit.PerMinute, "create-rate", defaultCreateRateLimit.PerMinute, "Rooms a client address may create per minute, 0 disables the limit")
+	fs.IntVar(&c.createLimit.Burst, "create-burst", defaultCreateRateLimit.Burst, "Rooms a client address may create at once")
+	fs.Float64Var(&c.joinLimit.PerMinute, "join-rate", defaultJoinRateLimit.PerMinute, "Joins to rooms a client address may make per minute, 0 disables the limit")
+	fs.IntVar(&c.joinLimit.Burst, "join-burst", defaultJoinRateLimit.Burst, "Joins to rooms a client address may make at once")
+	fs.Var(listValue{&c.origins}, "origins", "Comma-separated origins which may open WebSockets, "+
+		"like https://*.example.com, the origin of the server only if empty")
+	fs.StringVar(&c.certFile, "cert", "", "PEM certificate file to serve HTTPS with, reloaded when it changes")
+	fs.StringVar(&c.keyFile, "key", "", "PEM key file of the certificate")
+	fs.BoolVar(&c.devTLS, "dev-tls", false, "Serve HTTPS with a self-signed certificate for the local hosts")
+	fs.StringVar(&c.devTLSDir, "dev-tls-dir", defaultDevTLSDir(), "Directory to cache the self-signed certificate in")
+	fs.IntVar(&c.redirectPort, "redirect", 0, "Port of a listener which redirects HTTP to HTTPS, 0 disables it")
+	fs.Var(listValue{&c.iceServers}, "ice-servers", "Comma-separated STUN and TURN server URLs of peer connections")
+	fs.StringVar(&c.turnUsername, "turn-username", "", "Username of the TURN servers")
+	fs.StringVar(&c.turnCredential, "turn-credential", "", "Credential of the TURN servers")
+	fs.StringVar(&c.webDir, "web-dir", defaultWebDir, "Directory of the templates and static files")
+	fs.StringVar(&c.localesDir, "locales-dir", defaultLocalesDir, "Directory of the translations")
+
+	fs.IntVar(&c.port, "p", defaultPort, "Shorthand for -port")
+	fs.IntVar(&c.logLevel, "log", defaultLogLevel, "Shorthand for -log-level")
+	fs.BoolVar(&c.secured, "s", defaultSecurity, "Shorthand for -secured")
+	return fs
+}
+
+// applySettings sets the values of the source, except the ones set by the
+// flags, and reports every unknown key and invalid value.
+func applySettings(fs *flag.FlagSet, values map[string]string, set map[string]bool, source string) []error {
+	names := settingNames(fs)
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	errs := []error{}
+	for _, key := range keys {
+		value := values[key]
+		if !slices.Contains(names, key) {
+			errs = append(errs, fmt.Errorf("%w %q in %s", ErrUnknownConfigKey, key, source))
+			continue
+		}
+		if set[key] {
+			continue
+		}
+		// A flag which fails to parse a value may still change, it keeps
+		// the value of the layer before instead.
+		previous := fs.Lookup(key).Value.String()
+		if err := fs.Set(key, value); err != nil {
+			_ = fs.Set(key, previous)
+			errs = append(errs, fmt.Errorf("%w %q of %s in %s: %v", ErrInvalidValue, value, key, source, err))
+		}
+	}
+	return errs
+}
+
+// settingNames returns the names of the settings which may be set by
+// the config file and the environment.
+func settingNames(fs *flag.FlagSet) []string {
+	names := []string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := shorthands[f.Name]; !ok && f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+// settingName returns the long name of the flag.
+func settingName(flagName string) string {
+	if name, ok := shorthands[flagName]; ok {
+		return name
+	}
+	return flagName
+}
+
+// envName returns the environment variable of the setting.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// listValue is a flag of a comma-separated list.
+type listValue struct {
+	items *[]string
+}
+
+func (v listValue) String() string {
+	if v.items == nil {
+		return ""
+	}
+	return strings.Join(*v.items, ",")
+}
+
+func (v listValue) Set(list string) error {
+	*v.items = splitList(list)
+	return nil
+}
+
+// splitList splits the comma-separated list, dropping empty items.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readConfigFile reads the values of the config file by the format of its
+// extension, lists are joined with commas as if they were set by flags.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		return parseJSON(data)
+	case ".yaml", ".yml":
+		return parseFlat(data, ":")
+	case ".toml":
+		return parseFlat(data, "=")
+	default:
+		return nil, fmt.Errorf("unknown format %q, must be .json, .yaml, .yml or .toml", ext)
+	}
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	raw := map[string]any{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		str, err := jsonValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = str
+	}
+	return values, nil
+}
+
+func jsonValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	case []any:
+		items := []string{}
+		for _, item := range v {
+			if _, ok := item.([]any); ok {
+				return "", ErrNestedConfigValue
+			}
+			str, err := jsonValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", ErrNestedConfigValue
+	}
+}
+
+// parseFlat parses a file of a "key: value" or "key = value" pair per
+// line, the flat subset of YAML and TOML. Values may be quoted and lists
+// are written inline, like [a, b]. Nested and multi-line values, tables
+// and the other syntax of the formats are rejected.
+func parseFlat(data []byte, separator string) (map[string]string, error) {
+	values := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(stripComment(line))
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(trimmed, "-") {
+			return nil, fmt.Errorf("line %d: %w", i+1, ErrNestedConfigValue)
+		}
+
+		key, value, ok := strings.Cut(trimmed, separator)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key %s value", i+1, separator)
+		}
+		value, err := flatValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, nil
+}
+
+func flatValue(value string) (string, error) {
+	list, ok := strings.CutPrefix(value, "[")
+	if !ok {
+		return unquote(value)
+	}
+	list, ok = strings.CutSuffix(list, "]")
+	if !ok {
+		return "", fmt.Errorf("unclosed list %s", value)
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item, err := unquote(strings.TrimSpace(item))
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ","), nil
+}
+
+func unquote(value string) (string, error) {
+	if len(value) < 2 {
+		return value, nil
+	}
+	switch first, last := value[0], value[len(value)-1]; {
+	case first == '"' && last == '"':
+		return strconv.Unquote(value)
+	case first == '\'' && last == '\'':
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
+
+// stripComment cuts the comment off the line, a "#" which starts a comment
+// is not quoted and follows a space or starts the line.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
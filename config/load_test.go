@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDirs are the flags of the directories of the repo, which the
+// defaults do not find from the package directory.
+var testDirs = []string{"-web-dir", "../web", "-locales-dir", "../locales"}
+
+// writeConfigFile writes the config file of the given name and returns
+// its path.
+func writeConfigFile(tb testing.TB, name, content string) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		tb.Fatalf("write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{
+		"port": 8001,
+		"log-level": 4,
+		"offer": "20s",
+		"answer": "21s",
+		"origins": ["https://file.example.com"]
+	}`)
+	t.Setenv("PEERCHAT_CONFIG", path)
+	t.Setenv("PEERCHAT_PORT", "8002")
+	t.Setenv("PEERCHAT_LOG_LEVEL", "8")
+	t.Setenv("PEERCHAT_OFFER", "30s")
+
+	// Every layer overrides the ones before it, the keys it does not set
+	// keep their values.
+	c, err := Load(append(slices.Clone(testDirs), "-p", "8003"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, check := range []struct {
+		name      string
+		got, want any
+	}{
+		{"port of the flags", c.Port(), 8003},
+		{"log level of the environment", c.LogLevel(), 8},
+		{"offer timeout of the environment", c.OfferTimeout(), 30 * time.Second},
+		{"answer timeout of the file", c.AnswerTimeout(), 21 * time.Second},
+		{"origins of the file", strings.Join(c.AllowedOrigins(), ","), "https://file.example.com"},
+		{"default resume timeout", c.ResumeTimeout(), defaultResumeTimeout},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s: got %v, want %v", check.name, check.got, check.want)
+		}
+	}
+
+	// A config file of the flags replaces the one of the environment.
+	other := writeConfigFile(t, "other.toml", "answer = \"22s\"\n")
+	c, err = Load(append(slices.Clone(testDirs), "-config", other))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if c.AnswerTimeout() != 22*time.Second || len(c.AllowedOrigins()) != 0 {
+		t.Errorf("config of the flags: got answer %v and origins %q, want 22s and none",
+			c.AnswerTimeout(), c.AllowedOrigins())
+	}
+}
+
+func TestLoadReportsEveryInvalidValue(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", strings.Join([]string{
+		"port: 70000",
+		"offer: soon",
+		"colour: blue",
+	}, "\n"))
+	t.Setenv("PEERCHAT_LOG_LEVEL", "3")
+	t.Setenv("PEERCHAT_SECURED", "maybe")
+
+	_, err := Load(append(slices.Clone(testDirs), "-config", path, "-answer", "-1s"))
+	if err == nil {
+		t.Fatal("load of invalid values: got no error")
+	}
+	for _, want := range []error{ErrInvalidPort, ErrInvalidValue, ErrUnknownConfigKey,
+		ErrInvalidLogLevel, ErrInvalidSignalTimeout} {
+		if !errors.Is(err, want) {
+			t.Errorf("error: got %v, want %v among the errors", err, want)
+		}
+	}
+	for _, want := range []string{"70000", `"soon" of offer`, `"colour"`, "log-level: 3", `"maybe" of secured`, "answer: -1s"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error: got %v, want it to tell %s", err, want)
+		}
+	}
+	if got := len(err.(interface{ Unwrap() []error }).Unwrap()); got != 6 {
+		t.Errorf("errors: got %d, want 6", got)
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	want := map[string]string{"port": "8443", "origins": "https://a.com,https://b.com", "secret": "a # b"}
+	for name, content := range map[string]string{
+		"config.json": `{"port": 8443, "origins": ["https://a.com", "https://b.com"], "secret": "a # b"}`,
+		"config.yaml": "---\n# Server\nport: 8443 # HTTPS\norigins: [https://a.com, 'https://b.com']\nsecret: \"a # b\"\n",
+		"config.toml": "port = 8443\norigins = [\"https://a.com\", \"https://b.com\"]\nsecret = 'a # b'\n",
+	} {
+		values, err := readConfigFile(writeConfigFile(t, name, content))
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+			continue
+		}
+		if len(values) != len(want) {
+			t.Errorf("values of %s: got %v, want %v", name, values, want)
+		}
+		for key, value := range want {
+			if values[key] != value {
+				t.Errorf("%s of %s: got %q, want %q", key, name, values[key], value)
+			}
+		}
+	}
+}
+
+func TestReadConfigFileRejectsNonFlat(t *testing.T) {
+	for name, content := range map[string]string{
+		"nested.json":    `{"rate": {"create": 10}}`,
+		"nested.yaml":    "rate:\n  create: 10\n",
+		"list.yaml":      "origins:\n- https://a.com\n",
+		"multiline.yaml": "secret: |\n  key\n",
+		"table.toml":     "[rate]\ncreate = 10\n",
+		"unclosed.toml":  "origins = [\"https://a.com\",\n\"https://b.com\"]\n",
+		"config.ini":     "port=8443\n",
+	} {
+		if values, err := readConfigFile(writeConfigFile(t, name, content)); err == nil {
+			t.Errorf("read %s: got %v, want an error", name, values)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/branow/peer-chat/model"
+	"github.com/branow/peer-chat/validation"
+)
+
+// How long a redeemed invite lets its guest into a private room.
+const invitePassTTL = 12 * time.Hour
+
+// invitePasses returns the passes into private rooms, which are given for
+// redeemed invites. They are signed with a key derived from the one of
+// the join tickets, so a join ticket is never taken for a pass. A pass is
+// bound to the generation of the invites of the room, so revoking invites
+// ends the passes given for them.
+func invitePasses(tickets []byte) *model.JoinTickets {
+	mac := hmac.New(sha256.New, tickets)
+	mac.Write([]byte("invite-pass"))
+	return model.NewJoinTickets(mac.Sum(nil), invitePassTTL)
+}
+
+// passCookie returns the name of the cookie with the pass into the room.
+func passCookie(roomId int) string {
+	return fmt.Sprintf("room-pass-%d", roomId)
+}
+
+// mayEnter reports whether the request may enter the room, which it may
+// if the room is public, the request has a pass into the room, given since
+// its invites were last revoked, or comes from the host.
+func (h RoomHandlers) mayEnter(r *http.Request, room model.RoomInfo) bool {
+	if !room.Private || h.isHost(r, room.Id) {
+		return true
+	}
+	cookie, err := r.Cookie(passCookie(room.Id))
+	if err != nil {
+		return false
+	}
+	return h.passes.VerifyFor(cookie.Value, room.Id, room.InviteGen) == nil
+}
+
+// enterRoom checks that the request may enter the room. A request without
+// a pass redeems the invite code of its "invite" query parameter and gets
+// a pass for it.
+func (h RoomHandlers) enterRoom(w http.ResponseWriter, r *http.Request, room model.RoomInfo) error {
+	if h.mayEnter(r, room) {
+		return nil
+	}
+	code := r.URL.Query().Get("invite")
+	if code == "" {
+		return model.ErrInvalidInvite
+	}
+	if err := h.manager.RedeemInvite(room.Id, code); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     passCookie(room.Id),
+		Value:    h.passes.IssueFor(room.Id, room.InviteGen),
+		Path:     "/",
+		MaxAge:   int(h.passes.TTL().Seconds()),
+		Secure:   h.cfg.Secured(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// inviteListModel is the model of the list of invites the host sees.
+type inviteListModel struct {
+	RoomId  int
+	Invites []inviteDTO
+}
+
+type inviteDTO struct {
+	Code       string
+	Uses       int
+	MaxUses    int    // Zero if the uses are not limited
+	ExpiryTime string // RFC 3339, empty if the invite never expires
+}
+
+func newInviteDTO(invite model.Invite) inviteDTO {
+	dto := inviteDTO{Code: invite.Code, Uses: invite.Uses, MaxUses: invite.MaxUses}
+	if !invite.ExpiryTime.IsZero() {
+		dto.ExpiryTime = invite.ExpiryTime.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// GetInvites shows the invites of the room to its host.
+func (h RoomHandlers) GetInvites() HandlerAdapter {
+	return h.invitesHandler("GET /x/rooms/{roomId}/invites", func(roomId int, r *http.Request) error {
+		return nil
+	})
+}
+
+// PostCreateInvite adds an invite to the room, which may be limited to
+// the "uses" number of uses and to the "duration" in minutes.
+func (h RoomHandlers) PostCreateInvite() HandlerAdapter {
+	return h.invitesHandler("POST /x/rooms/{roomId}/invites", func(roomId int, r *http.Request) error {
+		usesStr := r.PostFormValue("uses")
+		durationStr := r.PostFormValue("duration")
+
+		var uses int64
+		if usesStr != "" {
+			if err := validation.Validate(usesStr, "invite uses", validation.AnInteger()); err != nil {
+				return err
+			}
+			uses, _ = strconv.ParseInt(usesStr, 10, 64)
+		}
+
+		// The duration of the invite is given in minutes.
+		var duration int64
+		if durationStr != "" {
+			if err := validation.Validate(durationStr, "invite duration", validation.AnInteger()); err != nil {
+				return err
+			}
+			duration, _ = strconv.ParseInt(durationStr, 10, 64)
+		}
+
+		invite := model.NewInviteDTO(int(uses), time.Duration(duration)*time.Minute)
+		if err := invite.Validate(); err != nil {
+			return err
+		}
+		_, err := h.manager.CreateInvite(roomId, *invite)
+		return err
+	})
+}
+
+// PostRevokeInvite revokes the invite of the room with the "code".
+func (h RoomHandlers) PostRevokeInvite() HandlerAdapter {
+	return h.invitesHandler("POST /x/rooms/{roomId}/invites/revoke", func(roomId int, r *http.Request) error {
+		return h.manager.RevokeInvite(roomId, r.PostFormValue("code"))
+	})
+}
+
+// PostRotateInvites revokes every invite of the room in favour of a new one.
+func (h RoomHandlers) PostRotateInvites() HandlerAdapter {
+	return h.invitesHandler("POST /x/rooms/{roomId}/invites/rotate", func(roomId int, r *http.Request) error {
+		_, err := h.manager.RotateInvites(roomId)
+		return err
+	})
+}
+
+// invitesHandler handles a request of the host about the invites of the room
+// and responds with the invites.
+func (h RoomHandlers) invitesHandler(path string, handle func(roomId int, r *http.Request) error) HandlerAdapter {
+	handler := NewHandlerAdapter(path)
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomId, err := h.hostRoomId(r)
+		if err != nil {
+			return err
+		}
+		if err := handle(roomId, r); err != nil {
+			return err
+		}
+
+		invites, err := h.manager.Invites(roomId)
+		if err != nil {
+			return err
+		}
+		model := inviteListModel{RoomId: roomId}
+		for _, invite := range invites {
+			model.Invites = append(model.Invites, newInviteDTO(invite))
+		}
+		return vr.ExecuteView(InviteListView, w, model)
+	})
+
+	addHostErrorHandlers(handler)
+	return *handler
+}
@@ -3,6 +3,9 @@ package handlers
 import (
 	"log/slog"
 	"net/http"
+	"strconv"
+
+	"github.com/branow/peer-chat/model"
 )
 
 // MatchError defines a function that determines if an error mathces
@@ -55,14 +58,54 @@ func (h *HandlerAdapter) AddErrorHandler(match MatchError, handle HandleError) {
 	h.errorHandlers = append(h.errorHandlers, handler)
 }
 
+// AddRateLimit limits the requests to the HandlerAdapter with the limiter,
+// a nil limiter leaves them unlimited. A request over the limit is not
+// handled, its errTooManyRequests is passed to the given error handler.
+func (h *HandlerAdapter) AddRateLimit(limiter *RateLimiter, handle HandleError) {
+	if limiter == nil {
+		return
+	}
+	h.addGuard(limiter.handle, errTooManyRequests, handle)
+}
+
+// AddOriginPolicy lets only the origins allowed by the policy make
+// requests to the HandlerAdapter. A request from another origin is not
+// handled, its errForbidden is passed to the given error handler.
+func (h *HandlerAdapter) AddOriginPolicy(policy *OriginPolicy, handle HandleError) {
+	h.addGuard(policy.handle, errForbidden, handle)
+}
+
+// AddDraining turns away the requests to the HandlerAdapter once the server
+// shuts down. Their model.ErrShuttingDown, also returned by the model
+// during a shutdown, is passed to the given error handler, after the
+// Retry-After header tells when to try again.
+func (h *HandlerAdapter) AddDraining(draining *Draining, handle HandleError) {
+	h.addGuard(draining.handle, model.ErrShuttingDown, func(err error, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", strconv.Itoa(shutdownRetryAfter))
+		handle(err, w, r)
+	})
+}
+
+// addGuard puts the guard in front of the handlers. The error handler of
+// the error the guard rejects requests with comes first too.
+func (h *HandlerAdapter) addGuard(guard Handle, guardErr error, handle HandleError) {
+	h.handlers = append([]Handle{guard}, h.handlers...)
+	errorHandler := errorCaseHandler{
+		match:  func(err error) bool { return err == guardErr },
+		handle: handle,
+	}
+	h.errorHandlers = append([]errorCaseHandler{errorHandler}, h.errorHandlers...)
+}
+
 // ServeHTTP processes incoming HTTP requests and handles errors as needed.
+// The handlers run in order until one of them fails.
 func (h HandlerAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for _, handle := range h.handlers {
 		if err := handle(w, r); err != nil {
 			if !h.serveError(err, w, r) {
 				slog.Error("ServeHTTP Unhandled Error", "error", err, "url", r.URL)
-				break
 			}
+			return
 		}
 	}
 }
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/branow/peer-chat/i18n"
+	"github.com/branow/peer-chat/model"
+	"github.com/branow/peer-chat/validation"
+)
+
+var (
+	errMalformedBody        = errors.New("malformed request body")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// Largest JSON body the API reads.
+const maxApiBodySize = 1 << 16
+
+// apiRoom is the JSON representation of a room.
+type apiRoom struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	Clients      int    `json:"clients"`
+	Waiting      int    `json:"waiting"`
+	Capacity     int    `json:"capacity"`
+	CreationTime string `json:"creationTime"`
+	Private      bool   `json:"private"`
+	Protected    bool   `json:"protected"`
+	Locked       bool   `json:"locked"`
+	Lobby        bool   `json:"lobby"`
+	StartTime    string `json:"startTime,omitempty"`  // RFC 3339, empty if the room opened on creation
+	ExpiryTime   string `json:"expiryTime,omitempty"` // RFC 3339, empty if the room never expires
+	URL          string `json:"url"`
+}
+
+func newApiRoom(room model.RoomInfo) apiRoom {
+	dto := apiRoom{
+		Id:           room.Id,
+		Name:         room.Name,
+		Clients:      room.Clients,
+		Waiting:      room.Waiting,
+		Capacity:     room.Capacity,
+		CreationTime: room.CreationTime.Format(time.RFC3339),
+		Private:      room.Private,
+		Protected:    room.Protected,
+		Locked:       room.Locked,
+		Lobby:        room.Lobby,
+		URL:          "/room/" + strconv.Itoa(room.Id),
+	}
+	if !room.StartTime.IsZero() {
+		dto.StartTime = room.StartTime.Format(time.RFC3339)
+	}
+	if !room.ExpiryTime.IsZero() {
+		dto.ExpiryTime = room.ExpiryTime.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// apiCreateRoom is the JSON body of a request to create a room, its fields
+// mean the same as the ones of the form on the home page.
+type apiCreateRoom struct {
+	Name     string `json:"name"`
+	Access   *int   `json:"access"` // 0 for a private room, 1 for a public one, mandatory
+	Capacity int    `json:"capacity,omitempty"`
+	Password string `json:"password,omitempty"`
+	Start    string `json:"start,omitempty"`    // RFC 3339
+	Duration int    `json:"duration,omitempty"` // In minutes
+	Lobby    bool   `json:"lobby,omitempty"`
+}
+
+// apiCreatedRoom is the JSON response to a created room. The host key
+// is sent as the "host" form value of the host requests.
+type apiCreatedRoom struct {
+	apiRoom
+	HostKey string `json:"hostKey"`
+	Invite  string `json:"invite,omitempty"` // Invite of a private room
+}
+
+// apiConnect is the JSON body of a request to connect to a room.
+type apiConnect struct {
+	Id     int    `json:"id"`
+	Invite string `json:"invite,omitempty"`
+}
+
+// apiError is the JSON body of an error response. A validation error
+// names the field it is about.
+type apiError struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"` // Localized error
+	Field   string `json:"field,omitempty"`
+}
+
+// ApiGetRoomList responds with the page of the public rooms which
+// the query parameters ask for, the same as the ones of the room list.
+func (h RoomHandlers) ApiGetRoomList() HandlerAdapter {
+	handler := NewHandlerAdapter("GET /api/v1/rooms")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		query, err := roomQuery(r)
+		if err != nil {
+			return err
+		}
+		page, err := h.manager.QueryPublicRooms(*query)
+		if err != nil {
+			return err
+		}
+
+		rooms := []apiRoom{}
+		for _, room := range page.Rooms {
+			rooms = append(rooms, newApiRoom(room))
+		}
+		return writeJSON(w, http.StatusOK, struct {
+			Rooms []apiRoom `json:"rooms"`
+			Total int       `json:"total"`
+			Next  string    `json:"next,omitempty"` // Cursor of the next page
+		}{Rooms: rooms, Total: page.Total, Next: page.Next})
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool {
+			var validErr *validation.ValidationError
+			return errors.As(err, &validErr) || errors.Is(err, model.ErrInvalidCursor)
+		},
+		handleJSONError(http.StatusBadRequest),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleJSONError(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// ApiGetRoom responds with the room. A private room is found only by
+// those who may enter it.
+func (h RoomHandlers) ApiGetRoom() HandlerAdapter {
+	handler := NewHandlerAdapter("GET /api/v1/rooms/{roomId}")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomId, err := strconv.ParseInt(r.PathValue("roomId"), 10, 64)
+		if err != nil {
+			return model.ErrRoomDoesNotExist
+		}
+
+		roomInfo, err := h.manager.GetRoom(int(roomId))
+		if err != nil {
+			return err
+		}
+		if !h.mayEnter(r, roomInfo) {
+			return model.ErrRoomDoesNotExist
+		}
+		return writeJSON(w, http.StatusOK, newApiRoom(roomInfo))
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return errors.Is(err, model.ErrRoomDoesNotExist) },
+		handleJSONError(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleJSONError(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// ApiPostCreateRoom creates a room and responds with it and its host key.
+func (h RoomHandlers) ApiPostCreateRoom() HandlerAdapter {
+	handler := NewHandlerAdapter("POST /api/v1/rooms")
+	handler.AddRateLimit(h.createLimit, handleJSONError(http.StatusTooManyRequests))
+	handler.AddDraining(h.draining, handleJSONError(http.StatusServiceUnavailable))
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		var body apiCreateRoom
+		if err := readJSON(w, r, &body); err != nil {
+			return err
+		}
+
+		// A missing access must not make a room private by default.
+		if body.Access == nil {
+			return &validation.ValidationError{Message: "is mandatory", Field: "room access"}
+		}
+
+		var start time.Time
+		if body.Start != "" {
+			if err := validation.Validate(body.Start, "room start time", validation.ATime(time.RFC3339)); err != nil {
+				return err
+			}
+			start, _ = time.Parse(time.RFC3339, body.Start)
+		}
+
+		capacity := body.Capacity
+		if capacity == 0 {
+			capacity = model.DefaultRoomCapacity
+		}
+
+		room := model.NewRoomDTO(body.Name, *body.Access, capacity, body.Password)
+		room.SetSchedule(start, time.Duration(body.Duration)*time.Minute)
+		room.SetLobby(body.Lobby)
+		roomId, hostKey, err := h.createRoom(w, room)
+		if err != nil {
+			return err
+		}
+
+		roomInfo, err := h.manager.GetRoom(roomId)
+		if err != nil {
+			return err
+		}
+		created := apiCreatedRoom{apiRoom: newApiRoom(roomInfo), HostKey: hostKey}
+		if roomInfo.Private {
+			invites, err := h.manager.Invites(roomId)
+			if err != nil {
+				return err
+			}
+			if len(invites) > 0 {
+				created.Invite = invites[0].Code
+			}
+		}
+		return writeJSON(w, http.StatusCreated, created)
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool {
+			var validErr *validation.ValidationError
+			return errors.As(err, &validErr) || err == errMalformedBody
+		},
+		handleJSONError(http.StatusBadRequest),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errUnsupportedMediaType },
+		handleJSONError(http.StatusUnsupportedMediaType),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return errors.Is(err, model.ErrRoomAlreadyExists) },
+		handleJSONError(http.StatusConflict),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleJSONError(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// ApiPutConnect finds the room to connect to and responds with the URL
+// of its page, which carries the invite code of a private room.
+func (h RoomHandlers) ApiPutConnect() HandlerAdapter {
+	handler := NewHandlerAdapter("PUT /api/v1/rooms/connect")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		var body apiConnect
+		if err := readJSON(w, r, &body); err != nil {
+			return err
+		}
+
+		roomURL, err := h.connectURL(r, body.Id, body.Invite)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, struct {
+			Id  int    `json:"id"`
+			URL string `json:"url"`
+		}{Id: body.Id, URL: roomURL})
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errMalformedBody },
+		handleJSONError(http.StatusBadRequest),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errUnsupportedMediaType },
+		handleJSONError(http.StatusUnsupportedMediaType),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return errors.Is(err, model.ErrRoomDoesNotExist) },
+		handleJSONError(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleJSONError(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// readJSON decodes the JSON body of the request into the value. The body
+// must be sent as application/json, which a cross-site form cannot do
+// without a preflight request.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApiBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		slog.Debug("Decode request body:", "url", r.URL, "error", err)
+		return errMalformedBody
+	}
+	return nil
+}
+
+// writeJSON responds with the value encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// handleJSONError responds with the error as JSON, the message of which
+// is localized like the messages of the HTML handlers.
+func handleJSONError(status int) HandleError {
+	return func(err error, w http.ResponseWriter, r *http.Request) {
+		body := apiError{Status: status, Error: err.Error(), Message: err.Error()}
+		var validErr *validation.ValidationError
+		if errors.As(err, &validErr) {
+			body.Field = validErr.Field
+		}
+		switch {
+		case status == http.StatusInternalServerError:
+			// The cause of an internal error is logged but not shown.
+			body.Error = http.StatusText(status)
+			body.Message = GetLocale(r).GetOr("error-500-title", body.Error)
+		case err == errTooManyRequests:
+			body.Error = http.StatusText(status)
+			body.Message = GetLocale(r).GetOr("error-429-message", body.Error)
+		default:
+			body.Message = GetLocale(r).GetOr(i18n.ResolveI18NKeyOfError(err), body.Message)
+		}
+
+		slog.Debug("Error Response", "status", status, "url", r.URL, "error", err)
+		if err := writeJSON(w, status, body); err != nil {
+			logError(status, r.URL.String(), err)
+		}
+	}
+}
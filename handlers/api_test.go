@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveJSON serves the request with the body sent as the given media type
+// and decodes the error of the response, if any.
+func serveJSON(mux http.Handler, method, target, mediaType, body string) (*httptest.ResponseRecorder, apiError) {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", mediaType)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	var apiErr apiError
+	if w.Code >= 400 {
+		_ = json.Unmarshal(w.Body.Bytes(), &apiErr)
+	}
+	return w, apiErr
+}
+
+func TestApiPostCreateRoom(t *testing.T) {
+	_, mux := newTestHandlers(t, "-create-rate", "0")
+
+	w, _ := serveJSON(mux, "POST", "/api/v1/rooms", "application/json; charset=utf-8",
+		`{"name": "Standup", "access": 1, "capacity": 4}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var created apiCreatedRoom
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode created room: %v", err)
+	}
+	if created.Name != "Standup" || created.Capacity != 4 || created.Private || created.HostKey == "" {
+		t.Errorf("created room: got %+v", created)
+	}
+
+	for _, check := range []struct {
+		name, mediaType, body string
+		status                int
+		field                 string
+	}{
+		{"taken name", "application/json", `{"name": "Standup", "access": 1}`, http.StatusConflict, ""},
+		{"form", "application/x-www-form-urlencoded", `name=Form&access=1`, http.StatusUnsupportedMediaType, ""},
+		{"no media type", "", `{"name": "Plain", "access": 1}`, http.StatusUnsupportedMediaType, ""},
+		{"unknown field", "application/json", `{"name": "Extra", "access": 1, "admin": true}`, http.StatusBadRequest, ""},
+		{"malformed", "application/json", `{"name": `, http.StatusBadRequest, ""},
+		{"too large", "application/json", `{"name": "` + strings.Repeat("a", maxApiBodySize) + `", "access": 1}`,
+			http.StatusBadRequest, ""},
+		{"no access", "application/json", `{"name": "Private"}`, http.StatusBadRequest, "room access"},
+		{"invalid name", "application/json", `{"name": "a", "access": 1}`, http.StatusBadRequest, "room name"},
+	} {
+		w, apiErr := serveJSON(mux, "POST", "/api/v1/rooms", check.mediaType, check.body)
+		if w.Code != check.status || apiErr.Status != check.status {
+			t.Errorf("%s: got %d with %+v, want %d", check.name, w.Code, apiErr, check.status)
+		}
+		if apiErr.Field != check.field {
+			t.Errorf("%s: got the field %q, want %q", check.name, apiErr.Field, check.field)
+		}
+	}
+}
+
+func TestApiNotFound(t *testing.T) {
+	h, mux := newTestHandlers(t)
+	privateId, _ := createTestRoom(t, h, "private room", privateAccess)
+
+	for _, check := range []struct {
+		method, target, body string
+	}{
+		{"GET", "/api/v1/rooms/404", ""},
+		{"GET", "/api/v1/rooms/room", ""},
+		{"GET", "/api/v1/rooms/" + strconv.Itoa(privateId), ""},
+		{"PUT", "/api/v1/rooms/connect", `{"id": 404}`},
+		{"PUT", "/api/v1/rooms/connect", `{"id": ` + strconv.Itoa(privateId) + `}`},
+	} {
+		w, apiErr := serveJSON(mux, check.method, check.target, "application/json", check.body)
+		if w.Code != http.StatusNotFound || apiErr.Status != http.StatusNotFound {
+			t.Errorf("%s %s %s: got %d with %+v, want %d",
+				check.method, check.target, check.body, w.Code, apiErr, http.StatusNotFound)
+		}
+	}
+}
+
+func TestApiShuttingDown(t *testing.T) {
+	h, mux := newTestHandlers(t, "-create-rate", "0")
+
+	// The model may refuse the room before the handlers start draining.
+	h.manager.Shutdown(context.Background())
+	for _, name := range []string{"Model", "Draining"} {
+		w, apiErr := serveJSON(mux, "POST", "/api/v1/rooms", "application/json",
+			`{"name": "`+name+`", "access": 1}`)
+		if w.Code != http.StatusServiceUnavailable || apiErr.Status != http.StatusServiceUnavailable {
+			t.Errorf("create while shutting down: got %d with %+v, want %d",
+				w.Code, apiErr, http.StatusServiceUnavailable)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("create while shutting down: no Retry-After header")
+		}
+		h.draining.Start()
+	}
+}
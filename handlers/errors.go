@@ -65,6 +65,45 @@ func newError404(err error) errorModel {
 	}
 }
 
+func newError403(err error) errorModel {
+	return errorModel{
+		Status:  http.StatusForbidden,
+		Title:   "Forbidden",
+		Message: "You are not allowed to join the room.",
+		Cause:   err.Error(),
+		localizationKeys: map[string]string{
+			"Title":   "error-403-title",
+			"Message": "error-403-message",
+		},
+	}
+}
+
+func newError429(err error) errorModel {
+	return errorModel{
+		Status:  http.StatusTooManyRequests,
+		Title:   "Too Many Requests",
+		Message: "You are doing this too often. Wait a little and try again.",
+		Cause:   err.Error(),
+		localizationKeys: map[string]string{
+			"Title":   "error-429-title",
+			"Message": "error-429-message",
+		},
+	}
+}
+
+func newError503(err error) errorModel {
+	return errorModel{
+		Status:  http.StatusServiceUnavailable,
+		Title:   "Service Unavailable",
+		Message: "The server is restarting. Try again in a minute.",
+		Cause:   err.Error(),
+		localizationKeys: map[string]string{
+			"Title":   "error-503-title",
+			"Message": "error-503-message",
+		},
+	}
+}
+
 func newError400(err error) errorModel {
 	return errorModel{
 		Status:  http.StatusBadRequest,
@@ -123,6 +162,15 @@ func handleError(newErrorModel newErrorModel) HandleError {
 	}
 }
 
+// handleStatus responds with the status code only, it suits requests
+// which are made by scripts.
+func handleStatus(status int) HandleError {
+	return func(err error, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		slog.Debug("Error Response", "status", status, "url", r.URL, "error", err)
+	}
+}
+
 func logError(status int, url string, err error) {
 	slog.Error("Error Response", "status", status, "url", url, "error", err)
 }
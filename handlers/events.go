@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/branow/peer-chat/model"
+)
+
+// Events a client of the room stream may fall behind by. A client which
+// falls further behind is disconnected and reloads the list on reconnect.
+const roomEventsBuffer = 64
+
+// roomEventDTO is the data of a room stream event, the room is rendered
+// with the room info view unless it has been removed.
+type roomEventDTO struct {
+	Id   int    `json:"id"`
+	Html string `json:"html,omitempty"`
+}
+
+// GetRoomEvents streams the lifecycle events of the public rooms as
+// server-sent events, so the room list on the home page stays live.
+// Private rooms never get into the stream.
+func (h RoomHandlers) GetRoomEvents() HandlerAdapter {
+	handler := NewHandlerAdapter("GET /x/rooms/events")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return errInternalServer
+		}
+		roomTmpl, err := vr.FindView(RoomInfoView)
+		if err != nil {
+			return err
+		}
+
+		events := make(chan model.LifecycleEvent, roomEventsBuffer)
+		lost := make(chan struct{})
+		var loseOnce sync.Once
+		unsubscribe := h.manager.SubscribeLifecycle(func(event model.LifecycleEvent) {
+			if event.Room.Private {
+				return
+			}
+			select {
+			case events <- event:
+			default:
+				loseOnce.Do(func() { close(lost) })
+			}
+		})
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// Comments keep the stream from being closed as idle by proxies.
+		ping := time.NewTicker(h.cfg.PingInterval())
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return nil
+			case <-h.draining.Done():
+				return nil
+			case <-lost:
+				return nil
+			case <-ping.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return nil
+				}
+			case event := <-events:
+				dto := roomEventDTO{Id: event.Room.Id}
+				if event.Type != model.LifecycleRemoved {
+					buf := bytes.NewBufferString("")
+					if err := roomTmpl.ExecuteTemplate(buf, RoomInfoView, newRoomInfoDTO(event.Room)); err != nil {
+						slog.Error("Render room event:", "room-id", event.Room.Id, "error", err)
+						continue
+					}
+					dto.Html = buf.String()
+				}
+				data, _ := json.Marshal(dto)
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+					return nil
+				}
+			}
+			flusher.Flush()
+		}
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleStatus(http.StatusInternalServerError),
+	)
+	return *handler
+}
@@ -5,41 +5,52 @@ import (
 	"errors"
 	"html/template"
 	"net/http"
+	"path/filepath"
 	"slices"
 
 	"github.com/branow/peer-chat/config"
 )
 
 const (
-	TemplateView = "template"
-	RoomView     = "room"
-	HomeView     = "home"
-	RoomInfoView = "room-info"
-	RoomListView = "room-list"
-	MessageView  = "message"
-	ErrorView    = "error"
-
-	ViewDir        = "./web/templates"
-	StaticFilesDir = "./web/static"
+	TemplateView      = "template"
+	RoomView          = "room"
+	RoomPasswordView  = "room-password"
+	RoomScheduledView = "room-scheduled"
+	HomeView          = "home"
+	RoomInfoView      = "room-info"
+	RoomListView      = "room-list"
+	RoomListPageView  = "room-list-page"
+	InviteListView    = "invite-list"
+	MessageView       = "message"
+	ErrorView         = "error"
 )
 
 var (
-	errNotFound       = errors.New("404")
-	errInternalServer = errors.New("500")
+	errForbidden       = errors.New("403")
+	errNotFound        = errors.New("404")
+	errGone            = errors.New("410")
+	errTooManyRequests = errors.New("429")
+	errInternalServer  = errors.New("500")
 )
 
-var vr = NewViewResolver(ViewDir)
+var vr *ViewResolver
+
+// HandleServeMux sets up routing for the application by the config.
+// It returns the room handlers, which shut the rooms down.
+func HandleServeMux(mux *http.ServeMux, cfg *config.Config) *RoomHandlers {
+	vr = NewViewResolver(filepath.Join(cfg.WebDir(), "templates"))
+	initLocalizor(cfg.LocalesDir())
 
-// HandleServeMux sets up routing for the application.
-func HandleServeMux(mux *http.ServeMux) {
 	// Static file handling
-	fs := http.FileServer(http.Dir(StaticFilesDir))
+	fs := http.FileServer(http.Dir(filepath.Join(cfg.WebDir(), "static")))
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	// Page handlers
-	GetHomePage().ServeMux(mux)
+	GetHomePage(cfg).ServeMux(mux)
 	GetIcon().ServeMux(mux)
-	NewRoomHandlers().HandleServeMux(mux)
+	rooms := NewRoomHandlers(cfg)
+	rooms.HandleServeMux(mux)
+	return rooms
 }
 
 // templateModel encapsulates data passed to the template view.
@@ -57,7 +68,7 @@ func GetIcon() HandlerAdapter {
 	return *hander
 }
 
-func GetHomePage() HandlerAdapter {
+func GetHomePage(cfg *config.Config) HandlerAdapter {
 	handler := NewHandlerAdapter("/", "/home")
 
 	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
@@ -72,7 +83,7 @@ func GetHomePage() HandlerAdapter {
 
 		model := templateModel{
 			Content: template.HTML(homeHtml.String()),
-			Secured: config.GetConfig().Secured(),
+			Secured: cfg.Secured(),
 		}
 
 		return vr.ExecuteView(TemplateView, w, model)
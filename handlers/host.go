@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/branow/peer-chat/model"
+	"github.com/branow/peer-chat/validation"
+)
+
+// How long the browser of the creator of a room keeps its host key.
+const hostCookieMaxAge = 30 * 24 * time.Hour
+
+// hostCookie returns the name of the cookie with the host key of the room.
+func hostCookie(roomId int) string {
+	return fmt.Sprintf("room-host-%d", roomId)
+}
+
+// hostKey returns the host key of the request, which is sent either in
+// the cookie set on creation or as the "host" form value.
+func hostKey(r *http.Request, roomId int) string {
+	if cookie, err := r.Cookie(hostCookie(roomId)); err == nil {
+		return cookie.Value
+	}
+	return r.PostFormValue("host")
+}
+
+// isHost reports whether the request comes from the host of the room.
+func (h RoomHandlers) isHost(r *http.Request, roomId int) bool {
+	key := hostKey(r, roomId)
+	return key != "" && h.manager.CheckHost(roomId, key) == nil
+}
+
+// Longest display name a client may have, longer ones are cut.
+const maxDisplayName = 50
+
+// displayName returns the display name the client of the request has
+// chosen, which it sends as the "name" query parameter.
+func displayName(r *http.Request) string {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if runes := []rune(name); len(runes) > maxDisplayName {
+		name = string(runes[:maxDisplayName])
+	}
+	return name
+}
+
+// clientAddr returns the IP address of the client of the request. It is
+// the address of the connection, so behind a reverse proxy every client
+// has the address of the proxy and shares its rate limits and bans.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// PostKickClient closes the client with the given peer id on behalf of
+// the host, who may also kick clients over the WebSocket.
+func (h RoomHandlers) PostKickClient() HandlerAdapter {
+	return h.kickClient("POST /x/rooms/{roomId}/kick", false)
+}
+
+// PostBanClient closes the client with the given peer id and keeps it
+// from coming back while the room lives.
+func (h RoomHandlers) PostBanClient() HandlerAdapter {
+	return h.kickClient("POST /x/rooms/{roomId}/ban", true)
+}
+
+func (h RoomHandlers) kickClient(path string, ban bool) HandlerAdapter {
+	handler := NewHandlerAdapter(path)
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomId, err := h.hostRoomId(r)
+		if err != nil {
+			return err
+		}
+
+		peerStr := r.PostFormValue("peer")
+		if err := validation.Validate(peerStr, "peer", validation.AnInteger()); err != nil {
+			return err
+		}
+		peer, _ := strconv.ParseInt(peerStr, 10, 64)
+
+		if err := h.manager.KickClient(roomId, int(peer), ban); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	addHostErrorHandlers(handler)
+	return *handler
+}
+
+// PostAdmitClient lets the client which knocked on the room with the given
+// knock id in from the lobby.
+func (h RoomHandlers) PostAdmitClient() HandlerAdapter {
+	return h.answerKnock("POST /x/rooms/{roomId}/admit", true)
+}
+
+// PostDenyClient turns the client which knocked on the room with the given
+// knock id away from the lobby.
+func (h RoomHandlers) PostDenyClient() HandlerAdapter {
+	return h.answerKnock("POST /x/rooms/{roomId}/deny", false)
+}
+
+func (h RoomHandlers) answerKnock(path string, admit bool) HandlerAdapter {
+	handler := NewHandlerAdapter(path)
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomId, err := h.hostRoomId(r)
+		if err != nil {
+			return err
+		}
+
+		knockStr := r.PostFormValue("knock")
+		if err := validation.Validate(knockStr, "knock", validation.AnInteger()); err != nil {
+			return err
+		}
+		knock, _ := strconv.ParseInt(knockStr, 10, 64)
+
+		if err := h.manager.AnswerKnock(roomId, int(knock), admit); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	addHostErrorHandlers(handler)
+	return *handler
+}
+
+// PostLockRoom locks the room, so new clients are refused, or unlocks it
+// with "locked" set to false.
+func (h RoomHandlers) PostLockRoom() HandlerAdapter {
+	handler := NewHandlerAdapter("POST /x/rooms/{roomId}/lock")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomId, err := h.hostRoomId(r)
+		if err != nil {
+			return err
+		}
+
+		locked := true
+		if lockedStr := r.PostFormValue("locked"); lockedStr != "" {
+			locked, err = strconv.ParseBool(lockedStr)
+			if err != nil {
+				return validation.NewValidationError("must be a boolean")
+			}
+		}
+
+		if err := h.manager.LockRoom(roomId, locked); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	addHostErrorHandlers(handler)
+	return *handler
+}
+
+// hostRoomId returns the id of the room of the request, which must come
+// from the host of the room.
+func (h RoomHandlers) hostRoomId(r *http.Request) (int, error) {
+	roomId, err := strconv.ParseInt(r.PathValue("roomId"), 10, 64)
+	if err != nil {
+		return 0, errNotFound
+	}
+	if err := h.manager.CheckHost(int(roomId), hostKey(r, int(roomId))); err != nil {
+		return 0, err
+	}
+	return int(roomId), nil
+}
+
+func addHostErrorHandlers(handler *HandlerAdapter) {
+	handler.AddErrorHandler(
+		func(err error) bool { return errors.Is(err, model.ErrNotHost) },
+		handleStatus(http.StatusForbidden),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool {
+			return err == errNotFound || errors.Is(err, model.ErrRoomDoesNotExist) ||
+				errors.Is(err, model.ErrPeerNotFound) || errors.Is(err, model.ErrInviteNotFound)
+		},
+		handleStatus(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool {
+			var validErr *validation.ValidationError
+			return errors.As(err, &validErr)
+		},
+		handleStatus(http.StatusBadRequest),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleStatus(http.StatusInternalServerError),
+	)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/branow/peer-chat/model"
+)
+
+// Length of the calendar event of a room which never expires.
+const defaultInviteDuration = time.Hour
+
+// GetRoomInvite serves an iCalendar invite to the room, which can be added
+// to a calendar. The event lasts for the window of the room. The invite
+// to a private room is given to its host only, its link carries the invite
+// code the room page shares, as the room cannot be entered by its id alone.
+func (h RoomHandlers) GetRoomInvite() HandlerAdapter {
+	handler := NewHandlerAdapter("GET /room/{roomId}/invite.ics")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomIdStr := r.PathValue("roomId")
+		roomId, err := strconv.ParseInt(roomIdStr, 10, 64)
+		if err != nil {
+			return errNotFound
+		}
+
+		roomInfo, err := h.manager.GetRoom(int(roomId))
+		if err != nil {
+			return err
+		}
+		if !h.mayEnter(r, roomInfo) {
+			return errNotFound
+		}
+
+		scheme := "http"
+		if h.cfg.Secured() {
+			scheme = "https"
+		}
+		roomURL := fmt.Sprintf("%s://%s/room/%d", scheme, r.Host, roomInfo.Id)
+		if roomInfo.Private {
+			code, err := h.shareableInvite(r, roomInfo.Id)
+			if err != nil {
+				return err
+			}
+			roomURL += "?invite=" + url.QueryEscape(code)
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"room-%d.ics\"", roomInfo.Id))
+		_, err = w.Write([]byte(newRoomInvite(roomInfo, roomURL, r.Host)))
+		return err
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errForbidden },
+		handleStatus(http.StatusForbidden),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errNotFound || errors.Is(err, model.ErrRoomDoesNotExist) },
+		handleStatus(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleStatus(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// shareableInvite returns the code of the first invite to the private room,
+// which only its host may share. A guest or a room without a valid invite
+// gets errForbidden.
+func (h RoomHandlers) shareableInvite(r *http.Request, roomId int) (string, error) {
+	if !h.isHost(r, roomId) {
+		return "", errForbidden
+	}
+	invites, err := h.manager.Invites(roomId)
+	if err != nil {
+		return "", err
+	}
+	if len(invites) == 0 {
+		return "", errForbidden
+	}
+	return invites[0].Code, nil
+}
+
+// newRoomInvite returns an iCalendar (RFC 5545) document with a single
+// event which links to the room.
+func newRoomInvite(room model.RoomInfo, url, host string) string {
+	start := room.StartTime
+	if start.IsZero() {
+		start = room.CreationTime
+	}
+	end := room.ExpiryTime
+	if end.IsZero() {
+		end = start.Add(defaultInviteDuration)
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Peer Chat//Room Invite//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:room-%d-%d@%s", room.Id, room.CreationTime.Unix(), host),
+		"DTSTAMP:" + icsTime(time.Now()),
+		"DTSTART:" + icsTime(start),
+		"DTEND:" + icsTime(end),
+		"SUMMARY:" + icsText(room.Name),
+		"DESCRIPTION:" + icsText("Join the room: "+url),
+		"URL:" + url,
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+
+	var builder strings.Builder
+	for _, line := range lines {
+		builder.WriteString(icsFold(line))
+		builder.WriteString("\r\n")
+	}
+	return builder.String()
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icsText escapes the characters which have a meaning in iCalendar text.
+func icsText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// icsFold splits a line longer than 75 octets into continuation lines,
+// without splitting a UTF-8 character.
+func icsFold(line string) string {
+	const limit = 75
+
+	var builder strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > limit {
+			builder.WriteString("\r\n ")
+			width = 1
+		}
+		builder.WriteRune(r)
+		width += size
+	}
+	return builder.String()
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/branow/peer-chat/config"
+	"github.com/branow/peer-chat/model"
+)
+
+// Access of the rooms, as the model takes it.
+const (
+	privateAccess = 0
+	publicAccess  = 1
+)
+
+// newTestHandlers returns the room handlers of a config with the given
+// flags, served by the returned mux, and loads the translations.
+func newTestHandlers(tb testing.TB, args ...string) (*RoomHandlers, *http.ServeMux) {
+	tb.Helper()
+	cfg, err := config.Load(append([]string{"-web-dir", "../web", "-locales-dir", "../locales"}, args...))
+	if err != nil {
+		tb.Fatalf("load config: %v", err)
+	}
+	initLocalizor(cfg.LocalesDir())
+	h := NewRoomHandlers(cfg)
+	mux := http.NewServeMux()
+	h.HandleServeMux(mux)
+	return h, mux
+}
+
+// createTestRoom creates a room with the given name and access and returns
+// its id and host key.
+func createTestRoom(tb testing.TB, h *RoomHandlers, name string, access int) (int, string) {
+	tb.Helper()
+	roomId, hostKey, err := h.manager.CreateRoom(*model.NewRoomDTO(name, access, 2, ""))
+	if err != nil {
+		tb.Fatalf("create room: %v", err)
+	}
+	return roomId, hostKey
+}
+
+func TestGetRoomInvite(t *testing.T) {
+	h, mux := newTestHandlers(t)
+	publicId, _ := createTestRoom(t, h, "public room", publicAccess)
+	privateId, hostKey := createTestRoom(t, h, "private room", privateAccess)
+	invites, err := h.manager.Invites(privateId)
+	if err != nil || len(invites) == 0 {
+		t.Fatalf("invites of the private room: got %+v, %v", invites, err)
+	}
+
+	get := func(roomId int, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/room/"+strconv.Itoa(roomId)+"/invite.ics", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(publicId, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("invite to the public room: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if want := "URL:https://example.com/room/" + strconv.Itoa(publicId) + "\r\n"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("invite to the public room: got %q, want it to contain %q", w.Body.String(), want)
+	}
+
+	// The link to a private room carries the invite code the host shares.
+	host := &http.Cookie{Name: hostCookie(privateId), Value: hostKey}
+	w = get(privateId, host)
+	if w.Code != http.StatusOK {
+		t.Fatalf("invite of the host: got %d, want %d", w.Code, http.StatusOK)
+	}
+	unfolded := strings.ReplaceAll(w.Body.String(), "\r\n ", "")
+	if want := "?invite=" + invites[0].Code; !strings.Contains(unfolded, want) {
+		t.Errorf("invite of the host: got %q, want it to contain %q", unfolded, want)
+	}
+
+	// Guests may not share the room, nor the host once no invite is left.
+	if w := get(privateId, nil); w.Code != http.StatusNotFound {
+		t.Errorf("invite of a stranger: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	pass := &http.Cookie{Name: passCookie(privateId), Value: h.passes.IssueFor(privateId, 0)}
+	if w := get(privateId, pass); w.Code != http.StatusForbidden {
+		t.Errorf("invite of a guest: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if err := h.manager.RevokeInvite(privateId, invites[0].Code); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if w := get(privateId, host); w.Code != http.StatusForbidden {
+		t.Errorf("invite without a valid invite: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
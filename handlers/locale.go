@@ -13,9 +13,10 @@ const DefaultLang = "en"
 
 var localizor *i18n.Localizor
 
-func init() {
+// initLocalizor loads the translations of the directory.
+func initLocalizor(dir string) {
 	var err error
-	if localizor, err = i18n.NewLocalizor("./locales"); err != nil {
+	if localizor, err = i18n.NewLocalizor(dir); err != nil {
 		slog.Error("Init localizor:", "error", err)
 	}
 }
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which pages may connect to rooms from a browser.
+// A page of the server itself always may, other pages need their origin
+// on the allow-list. Requests without an origin do not come from a page
+// of another site and are allowed.
+type OriginPolicy struct {
+	allowed []originPattern
+}
+
+// originPattern matches origins by scheme and host. An empty scheme
+// matches any scheme, a host with a "*." prefix matches its subdomains.
+type originPattern struct {
+	any    bool // Whether the pattern is a lone "*"
+	scheme string
+	host   string
+}
+
+// NewOriginPolicy returns a policy which allows the origins of the list,
+// like "https://example.com" or "*.example.com", and the origin of the
+// server.
+func NewOriginPolicy(origins []string) *OriginPolicy {
+	policy := &OriginPolicy{}
+	for _, origin := range origins {
+		pattern := originPattern{any: origin == "*"}
+		if scheme, host, ok := strings.Cut(origin, "://"); ok {
+			pattern.scheme, pattern.host = strings.ToLower(scheme), host
+		} else {
+			pattern.host = origin
+		}
+		pattern.host = strings.ToLower(strings.TrimSuffix(pattern.host, "/"))
+		policy.allowed = append(policy.allowed, pattern)
+	}
+	return policy
+}
+
+// Allowed reports whether the request may come from its origin.
+func (p OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, pattern := range p.allowed {
+		if pattern.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o originPattern) matches(u *url.URL) bool {
+	if o.any {
+		return true
+	}
+	if o.scheme != "" && o.scheme != strings.ToLower(u.Scheme) {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	if parent, ok := strings.CutPrefix(o.host, "*."); ok {
+		return strings.HasSuffix(host, "."+parent)
+	}
+	return host == o.host
+}
+
+// handle rejects the request with errForbidden if its origin is not
+// allowed.
+func (p *OriginPolicy) handle(w http.ResponseWriter, r *http.Request) error {
+	if p.Allowed(r) {
+		return nil
+	}
+	slog.Warn("Rejected origin:", "origin", r.Header.Get("Origin"), "url", r.URL, "addr", clientAddr(r))
+	return errForbidden
+}
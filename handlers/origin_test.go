@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "http://chat.example.com", true},
+		{"same origin in another case", nil, "http://Chat.Example.com", true},
+		{"other origin", nil, "https://evil.com", false},
+		{"null origin", []string{"*.example.com"}, "null", false},
+		{"unparsable origin", []string{"*"}, "http://%zz", false},
+		{"origin without host", []string{"*"}, "file:///index.html", false},
+		{"lone star", []string{"*"}, "https://evil.com", true},
+		{"exact origin", []string{"https://app.com"}, "https://app.com", true},
+		{"exact origin with slash", []string{"https://app.com/"}, "https://APP.com", true},
+		{"exact origin of another scheme", []string{"https://app.com"}, "http://app.com", false},
+		{"exact origin of another port", []string{"https://app.com"}, "https://app.com:8443", false},
+		{"host of any scheme", []string{"app.com"}, "http://app.com", true},
+		{"subdomain", []string{"*.app.com"}, "https://meet.app.com", true},
+		{"nested subdomain", []string{"https://*.app.com"}, "https://a.b.app.com", true},
+		{"subdomain of another scheme", []string{"https://*.app.com"}, "http://meet.app.com", false},
+		{"parent of subdomains", []string{"*.app.com"}, "https://app.com", false},
+		{"host ending with the suffix", []string{"*.app.com"}, "https://evilapp.com", false},
+		{"host containing the suffix", []string{"*.app.com"}, "https://meet.app.com.evil.com", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://chat.example.com/rooms/1/ws", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if got := NewOriginPolicy(test.allowed).Allowed(r); got != test.want {
+				t.Errorf("origin %q allowed by %q: got %v, want %v", test.origin, test.allowed, got, test.want)
+			}
+		})
+	}
+}
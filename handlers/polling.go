@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/branow/peer-chat/model"
+)
+
+// Maximum size of a message posted by a polling client.
+const maxPolledMessageSize = 1024 * 64
+
+// pollingSessions holds the transports of the clients which talk over
+// HTTP long polling by their session ids.
+type pollingSessions struct {
+	transports map[string]*model.PollingTransport
+	mutex      sync.Mutex
+}
+
+func newPollingSessions() *pollingSessions {
+	return &pollingSessions{transports: map[string]*model.PollingTransport{}}
+}
+
+// add registers the transport until it is closed.
+func (s *pollingSessions) add(transport *model.PollingTransport) {
+	s.mutex.Lock()
+	s.transports[transport.Id()] = transport
+	s.mutex.Unlock()
+
+	go func() {
+		<-transport.Done()
+		s.mutex.Lock()
+		delete(s.transports, transport.Id())
+		s.mutex.Unlock()
+	}()
+}
+
+func (s *pollingSessions) get(id string) (*model.PollingTransport, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	transport, ok := s.transports[id]
+	return transport, ok
+}
+
+// PostPollRoom joins the room over HTTP long polling, which is a fallback
+// for clients that cannot open a WebSocket. It responds with the id of
+// the polling session.
+func (h RoomHandlers) PostPollRoom() HandlerAdapter {
+	handler := NewHandlerAdapter("POST /poll/room/{roomId}")
+	handler.AddRateLimit(h.joinLimit, handleStatus(http.StatusTooManyRequests))
+	handler.AddOriginPolicy(h.origins, handleStatus(http.StatusForbidden))
+	handler.AddDraining(h.draining, handleStatus(http.StatusServiceUnavailable))
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomIdStr := r.PathValue("roomId")
+		roomId, err := strconv.ParseInt(roomIdStr, 10, 64)
+		if err != nil {
+			return errNotFound
+		}
+
+		// Check if the room exits.
+		roomInfo, err := h.manager.GetRoom(int(roomId))
+		if err != nil {
+			return err
+		}
+
+		guest, join, err := h.admit(r, roomInfo)
+		if err != nil {
+			return err
+		}
+
+		transport := model.NewPollingTransport()
+		h.polling.add(transport)
+		if _, err := join(int(roomId), guest, transport); err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(struct {
+			Session string `json:"session"`
+		}{Session: transport.Id()})
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool {
+			return err == errForbidden || errors.Is(err, model.ErrSessionNotFound) ||
+				errors.Is(err, model.ErrRoomNotStarted) || errors.Is(err, model.ErrRoomExpired) ||
+				errors.Is(err, model.ErrRoomLocked) || errors.Is(err, model.ErrBanned)
+		},
+		handleStatus(http.StatusForbidden),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool {
+			return err == errNotFound || errors.Is(err, model.ErrRoomDoesNotExist)
+		},
+		handleStatus(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleStatus(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// GetPoll waits for the messages to the polling client and responds with
+// a JSON array of them. Once the server has closed the connection on
+// purpose it responds with 410 Gone, an unknown session gets 404 and is
+// worth resuming.
+func (h RoomHandlers) GetPoll() HandlerAdapter {
+	handler := NewHandlerAdapter("GET /poll/{sessionId}")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		transport, ok := h.polling.get(r.PathValue("sessionId"))
+		if !ok {
+			return errNotFound
+		}
+
+		messages, err := transport.Poll(r.Context(), h.cfg.PingInterval())
+		if errors.Is(err, io.EOF) {
+			return errGone
+		}
+		if errors.Is(err, model.ErrTransportClosed) {
+			return errNotFound
+		}
+		if err != nil {
+			// The client has gone away.
+			return nil
+		}
+
+		response := make([]json.RawMessage, len(messages))
+		for i, message := range messages {
+			response[i] = message
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		return json.NewEncoder(w).Encode(response)
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errNotFound },
+		handleStatus(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errGone },
+		handleStatus(http.StatusGone),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleStatus(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// PostPoll passes a message of the polling client to the server.
+func (h RoomHandlers) PostPoll() HandlerAdapter {
+	handler := NewHandlerAdapter("POST /poll/{sessionId}")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		transport, ok := h.polling.get(r.PathValue("sessionId"))
+		if !ok {
+			return errNotFound
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolledMessageSize))
+		if err != nil {
+			return err
+		}
+		if err := transport.Push(r.Context(), data); err != nil {
+			if errors.Is(err, model.ErrTransportClosed) {
+				return errNotFound
+			}
+			return err
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errNotFound },
+		handleStatus(http.StatusNotFound),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool {
+			var maxBytesErr *http.MaxBytesError
+			return errors.As(err, &maxBytesErr)
+		},
+		handleStatus(http.StatusRequestEntityTooLarge),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleStatus(http.StatusInternalServerError),
+	)
+	return *handler
+}
+
+// DeletePoll closes the connection of the polling client on its behalf.
+func (h RoomHandlers) DeletePoll() HandlerAdapter {
+	handler := NewHandlerAdapter("DELETE /poll/{sessionId}")
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		transport, ok := h.polling.get(r.PathValue("sessionId"))
+		if !ok {
+			return errNotFound
+		}
+
+		transport.HangUp()
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool { return err == errNotFound },
+		handleStatus(http.StatusNotFound),
+	)
+	return *handler
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/branow/peer-chat/config"
+)
+
+// How often the buckets which have refilled are dropped.
+const ratePruneInterval = time.Minute
+
+// RateLimiter limits the requests of every client address with a token
+// bucket. A request takes a token from the bucket of its address, which
+// refills at a steady rate up to its burst. The routes of the same action
+// share a limiter, so a client cannot get more of it over another route.
+type RateLimiter struct {
+	rate      float64 // Tokens per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter returns a limiter of the given rate limit, nil if the rate
+// is zero and the requests are not limited.
+func NewRateLimiter(limit config.RateLimit) *RateLimiter {
+	if limit.PerMinute == 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:      limit.PerMinute / 60,
+		burst:     float64(limit.Burst),
+		buckets:   map[string]*bucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token for the request of the address at the given time.
+// If the bucket is empty, it returns false and how long the address has
+// to wait for the next token.
+func (l *RateLimiter) Allow(addr string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastPrune) > ratePruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[addr] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets which have refilled, they are no different
+// from new ones.
+func (l *RateLimiter) prune(now time.Time) {
+	for addr, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, addr)
+		}
+	}
+	l.lastPrune = now
+}
+
+// handle rejects the request with errTooManyRequests if its address is
+// over the limit, and tells in the Retry-After header when to try again.
+func (l *RateLimiter) handle(w http.ResponseWriter, r *http.Request) error {
+	ok, wait := l.Allow(clientAddr(r), time.Now())
+	if ok {
+		return nil
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return errTooManyRequests
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/branow/peer-chat/config"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := NewRateLimiter(config.RateLimit{PerMinute: 60, Burst: 2})
+	now := time.Now()
+
+	// The burst is taken at once, then a token comes every second.
+	for i := range 2 {
+		if ok, _ := l.Allow("192.0.2.1", now); !ok {
+			t.Fatalf("request %d of the burst not allowed", i)
+		}
+	}
+	ok, wait := l.Allow("192.0.2.1", now)
+	if ok || wait != time.Second {
+		t.Errorf("request over the burst: got %v with wait %v, want false with wait %v", ok, wait, time.Second)
+	}
+	if ok, _ := l.Allow("192.0.2.2", now); !ok {
+		t.Error("request of another address not allowed")
+	}
+	if ok, _ := l.Allow("192.0.2.1", now.Add(time.Second)); !ok {
+		t.Error("request after the refill not allowed")
+	}
+	if ok, wait := l.Allow("192.0.2.1", now.Add(1500*time.Millisecond)); ok || wait != 500*time.Millisecond {
+		t.Errorf("request before the refill: got %v with wait %v, want false with wait %v",
+			ok, wait, 500*time.Millisecond)
+	}
+
+	if l := NewRateLimiter(config.RateLimit{PerMinute: 0, Burst: 2}); l != nil {
+		t.Error("limiter of a zero rate: got a limiter, want none")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	l := NewRateLimiter(config.RateLimit{PerMinute: 1, Burst: 2})
+	now := time.Now()
+	l.Allow("192.0.2.1", now)
+	l.Allow("192.0.2.2", now)
+	l.Allow("192.0.2.2", now)
+
+	// Only the bucket which has refilled by the prune is dropped.
+	later := now.Add(ratePruneInterval + time.Second)
+	l.Allow("192.0.2.3", later)
+	if _, ok := l.buckets["192.0.2.1"]; ok {
+		t.Error("refilled bucket not pruned")
+	}
+	if _, ok := l.buckets["192.0.2.2"]; !ok {
+		t.Error("bucket which is refilling pruned")
+	}
+	if ok, _ := l.Allow("192.0.2.2", later); !ok {
+		t.Error("request of a refilling address not allowed")
+	}
+	if ok, _ := l.Allow("192.0.2.2", later); ok {
+		t.Error("request of a refilling address over its tokens allowed")
+	}
+}
+
+func TestRateLimiterSharedByRoutes(t *testing.T) {
+	l := NewRateLimiter(config.RateLimit{PerMinute: 1, Burst: 1})
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"POST /rooms", "POST /api/v1/rooms"} {
+		handler := NewHandlerAdapter(pattern)
+		handler.AddRateLimit(l, handleStatus(http.StatusTooManyRequests))
+		handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		})
+		handler.ServeMux(mux)
+	}
+
+	// The routes of the same action share the limit of the address.
+	post := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, nil))
+		return w
+	}
+	if w := post("/rooms"); w.Code != http.StatusCreated {
+		t.Fatalf("first request: got %d, want %d", w.Code, http.StatusCreated)
+	}
+	w := post("/api/v1/rooms")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("request over another route: got %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After: got %q, want 60", got)
+	}
+}
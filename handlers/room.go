@@ -2,47 +2,97 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/branow/peer-chat/backplane"
 	"github.com/branow/peer-chat/config"
 	"github.com/branow/peer-chat/model"
 	"github.com/branow/peer-chat/validation"
 	"github.com/gorilla/websocket"
 )
 
-// WebSocket upgrader to handle WebSocket connections.
+// WebSocket upgrader to handle WebSocket connections. The origin of
+// a WebSocket is checked by the origin policy of its handler before
+// the upgrade, which keeps one policy for every way into a room.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024 * 8,
 	WriteBufferSize: 1024 * 8,
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
+// How long a join ticket of a password-protected room is valid.
+const joinTicketTTL = 5 * time.Minute
+
 // RoomHandlers manages handlers related to chat rooms.
 type RoomHandlers struct {
-	manager *model.RoomManager
+	cfg         *config.Config
+	manager     *model.RoomManager
+	polling     *pollingSessions
+	tickets     *model.JoinTickets
+	passes      *model.JoinTickets // Passes into private rooms
+	createLimit *RateLimiter       // Limits the rooms a client address creates
+	joinLimit   *RateLimiter       // Limits the joins of a client address
+	origins     *OriginPolicy      // Pages which may connect to rooms
+	draining    *Draining          // Turns new rooms and joins away on shutdown
 }
 
-func NewRoomHandlers() *RoomHandlers {
+func NewRoomHandlers(cfg *config.Config) *RoomHandlers {
+	key := ticketKey(cfg)
 	return &RoomHandlers{
-		manager: model.NewRoomManager(),
+		cfg:         cfg,
+		manager:     model.NewRoomManager(keepAlive(cfg), signalTimeouts(cfg), roomStore(cfg), roomBackplane(cfg)),
+		polling:     newPollingSessions(),
+		tickets:     model.NewJoinTickets(key, joinTicketTTL),
+		passes:      invitePasses(key),
+		createLimit: NewRateLimiter(cfg.CreateRateLimit()),
+		joinLimit:   NewRateLimiter(cfg.JoinRateLimit()),
+		origins:     NewOriginPolicy(cfg.AllowedOrigins()),
+		draining:    NewDraining(),
 	}
 }
 
 // HandleServeMux registers all the routes handled by RoomHandlers.
 func (h RoomHandlers) HandleServeMux(mux *http.ServeMux) {
 	h.WsRoom().ServeMux(mux)
+	h.PostPollRoom().ServeMux(mux)
+	h.GetPoll().ServeMux(mux)
+	h.PostPoll().ServeMux(mux)
+	h.DeletePoll().ServeMux(mux)
 	h.GetRoomPage().ServeMux(mux)
+	h.GetRoomInvite().ServeMux(mux)
 	h.GetRoomList().ServeMux(mux)
+	h.GetRoomEvents().ServeMux(mux)
 	h.PostCreateRoom().ServeMux(mux)
+	h.PostUnlockRoom().ServeMux(mux)
+	h.PostKickClient().ServeMux(mux)
+	h.PostBanClient().ServeMux(mux)
+	h.PostLockRoom().ServeMux(mux)
+	h.PostAdmitClient().ServeMux(mux)
+	h.PostDenyClient().ServeMux(mux)
+	h.GetInvites().ServeMux(mux)
+	h.PostCreateInvite().ServeMux(mux)
+	h.PostRevokeInvite().ServeMux(mux)
+	h.PostRotateInvites().ServeMux(mux)
 	h.PutConnect().ServeMux(mux)
+	h.ApiGetRoomList().ServeMux(mux)
+	h.ApiGetRoom().ServeMux(mux)
+	h.ApiPostCreateRoom().ServeMux(mux)
+	h.ApiPutConnect().ServeMux(mux)
 }
 
 func (h RoomHandlers) WsRoom() HandlerAdapter {
 	handler := NewHandlerAdapter("GET /ws/room/{roomId}")
+	handler.AddRateLimit(h.joinLimit, handleErrorMessage(newError429))
+	handler.AddOriginPolicy(h.origins, handleErrorMessage(newError403))
+	handler.AddDraining(h.draining, handleErrorMessage(newError503))
 
 	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
 		roomIdStr := r.PathValue("roomId")
@@ -52,7 +102,12 @@ func (h RoomHandlers) WsRoom() HandlerAdapter {
 		}
 
 		// Check if the room exits.
-		_, err = h.manager.GetRoom(int(roomId))
+		roomInfo, err := h.manager.GetRoom(int(roomId))
+		if err != nil {
+			return err
+		}
+
+		guest, join, err := h.admit(r, roomInfo)
 		if err != nil {
 			return err
 		}
@@ -63,14 +118,25 @@ func (h RoomHandlers) WsRoom() HandlerAdapter {
 			return err
 		}
 
-		// Add client to the room and wait for interaction.
-		client := model.NewClient(conn)
-		_ = h.manager.AddClient(int(roomId), client)
+		// Join the room and wait for interaction.
+		transport := model.NewWebSocketTransport(conn)
+		client, err := join(int(roomId), guest, transport)
+		if err != nil {
+			slog.Debug("Join room:", "room-id", roomId, "error", err)
+			return nil
+		}
 		client.Wait()
 
 		return nil
 	})
 
+	handler.AddErrorHandler(
+		func(err error) bool {
+			return err == errForbidden || errors.Is(err, model.ErrRoomNotStarted) ||
+				errors.Is(err, model.ErrRoomExpired) || errors.Is(err, model.ErrRoomLocked)
+		},
+		handleErrorMessage(newError403),
+	)
 	handler.AddErrorHandler(
 		func(err error) bool { return true },
 		handleErrorMessage(newError500),
@@ -92,14 +158,40 @@ func (h RoomHandlers) GetRoomPage() HandlerAdapter {
 		if err != nil {
 			return err
 		}
+		if err := h.enterRoom(w, r, roomInfo); err != nil {
+			return err
+		}
+
+		// Ask for the password of a protected room unless it was entered
+		// recently, a scheduled room shows when it opens instead.
+		view := RoomView
+		switch err := roomInfo.CheckOpen(time.Now()); {
+		case errors.Is(err, model.ErrRoomNotStarted):
+			view = RoomScheduledView
+		case err != nil:
+			return err
+		case !h.mayJoin(r, roomInfo):
+			view = RoomPasswordView
+		}
 
+		page := roomPageModel{
+			RoomInfo:   roomInfo,
+			Host:       h.isHost(r, roomInfo.Id),
+			IceServers: iceServers(h.cfg),
+		}
+		if page.Host && roomInfo.Private {
+			// The host shares the room by its first invite.
+			if invites, err := h.manager.Invites(roomInfo.Id); err == nil && len(invites) > 0 {
+				page.InviteCode = invites[0].Code
+			}
+		}
 		buf := bytes.NewBufferString("")
-		if err := vr.ExecuteView(RoomView, buf, roomInfo); err != nil {
+		if err := vr.ExecuteView(view, buf, page); err != nil {
 			return err
 		}
 
 		roomHtml := buf.String()
-		model := templateModel{Content: template.HTML(roomHtml), Secured: config.GetConfig().Secured()}
+		model := templateModel{Content: template.HTML(roomHtml), Secured: h.cfg.Secured()}
 		return vr.ExecuteView(TemplateView, w, model)
 	})
 
@@ -108,7 +200,10 @@ func (h RoomHandlers) GetRoomPage() HandlerAdapter {
 		handleErrorPage(newError404),
 	)
 	handler.AddErrorHandler(
-		func(err error) bool { return errors.Is(err, model.ErrRoomDoesNotExist) },
+		func(err error) bool {
+			return errors.Is(err, model.ErrRoomDoesNotExist) || errors.Is(err, model.ErrRoomExpired) ||
+				errors.Is(err, model.ErrInvalidInvite)
+		},
 		handleErrorPage(newError404),
 	)
 	handler.AddErrorHandler(
@@ -118,20 +213,31 @@ func (h RoomHandlers) GetRoomPage() HandlerAdapter {
 	return *handler
 }
 
+// GetRoomList responds with the page of the public rooms which the query
+// parameters ask for. The first page comes in the room list view, the next
+// ones, asked for by their cursors, are appended to it as they scroll.
 func (h RoomHandlers) GetRoomList() HandlerAdapter {
 	handler := NewHandlerAdapter("GET /x/rooms")
 
 	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		query, err := roomQuery(r)
+		if err != nil {
+			return err
+		}
+		page, err := h.manager.QueryPublicRooms(*query)
+		if err != nil {
+			return err
+		}
+
 		// Fetch the template for individual room info.
 		roomTmpl, err := vr.FindView(RoomInfoView)
 		if err != nil {
 			return err
 		}
 
-		// Fetch public rooms and render each to HTML.
-		rooms := h.manager.GetPublicRooms()
+		// Render each room to HTML.
 		roomsHtml := []template.HTML{}
-		for _, room := range rooms {
+		for _, room := range page.Rooms {
 			buf := bytes.NewBufferString("")
 			dto := newRoomInfoDTO(room)
 			if err := roomTmpl.ExecuteTemplate(buf, RoomInfoView, dto); err != nil {
@@ -140,11 +246,33 @@ func (h RoomHandlers) GetRoomList() HandlerAdapter {
 			roomsHtml = append(roomsHtml, template.HTML(buf.String()))
 		}
 
-		// Render the room list view.
-		model := struct{ Rooms []template.HTML }{Rooms: roomsHtml}
+		// The next page is asked for with the same query.
+		pageModel := roomListPageModel{Rooms: roomsHtml}
+		if page.Next != "" {
+			params := r.URL.Query()
+			params.Set("cursor", page.Next)
+			pageModel.NextURL = "/x/rooms?" + params.Encode()
+		}
+		if r.URL.Query().Get("cursor") != "" {
+			return vr.ExecuteView(RoomListPageView, w, pageModel)
+		}
+
+		// Render the room list view with the first page.
+		buf := bytes.NewBufferString("")
+		if err := vr.ExecuteView(RoomListPageView, buf, pageModel); err != nil {
+			return err
+		}
+		model := roomListModel{Page: template.HTML(buf.String()), Total: page.Total}
 		return vr.ExecuteView(RoomListView, w, model)
 	})
 
+	handler.AddErrorHandler(
+		func(err error) bool {
+			var validErr *validation.ValidationError
+			return errors.As(err, &validErr) || errors.Is(err, model.ErrInvalidCursor)
+		},
+		handleErrorMessage(newError400),
+	)
 	handler.AddErrorHandler(
 		func(err error) bool { return true },
 		handleError(newError500),
@@ -152,24 +280,85 @@ func (h RoomHandlers) GetRoomList() HandlerAdapter {
 	return *handler
 }
 
+// roomQuery reads the query of the public rooms from the query parameters:
+// the "q" part of the name, the "min" and "max" number of clients, "free"
+// for rooms with a free slot, the "sort" order and the "cursor" and
+// "limit" of the page.
+func roomQuery(r *http.Request) (*model.RoomQuery, error) {
+	params := r.URL.Query()
+	query := model.NewRoomQuery(params.Get("q"), params.Get("sort"))
+
+	ints := map[string]int64{}
+	for _, param := range []struct{ name, field string }{
+		{"min", "room occupancy"}, {"max", "room occupancy"}, {"limit", "room page size"},
+	} {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if err := validation.Validate(value, param.field, validation.AnInteger()); err != nil {
+			return nil, err
+		}
+		ints[param.name], _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	query.SetOccupancy(int(ints["min"]), int(ints["max"]))
+	query.SetFree(params.Get("free") != "")
+	query.SetPage(params.Get("cursor"), int(ints["limit"]))
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
 func (h RoomHandlers) PostCreateRoom() HandlerAdapter {
 	handler := NewHandlerAdapter("POST /x/rooms/create")
+	handler.AddRateLimit(h.createLimit, handleErrorMessage(newError429))
+	handler.AddDraining(h.draining, handleErrorMessage(newError503))
 
 	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
 		name := r.PostFormValue("name")
 		accessStr := r.PostFormValue("access")
+		capacityStr := r.PostFormValue("capacity")
+		password := r.PostFormValue("password")
+		startStr := r.PostFormValue("start")
+		durationStr := r.PostFormValue("duration")
+		lobby := r.PostFormValue("lobby") != ""
 
 		if err := validation.Validate(accessStr, "room access", validation.AnInteger()); err != nil {
 			return err
 		}
 		access, _ := strconv.ParseInt(accessStr, 10, 64)
 
-		room := model.NewRoomDTO(name, int(access))
-		if err := room.Validate(); err != nil {
-			return err
+		capacity := int64(model.DefaultRoomCapacity)
+		if capacityStr != "" {
+			if err := validation.Validate(capacityStr, "room capacity", validation.AnInteger()); err != nil {
+				return err
+			}
+			capacity, _ = strconv.ParseInt(capacityStr, 10, 64)
 		}
 
-		roomId, err := h.manager.CreateRoom(*room)
+		var start time.Time
+		if startStr != "" {
+			if err := validation.Validate(startStr, "room start time", validation.ATime(time.RFC3339)); err != nil {
+				return err
+			}
+			start, _ = time.Parse(time.RFC3339, startStr)
+		}
+
+		// The duration of the room is given in minutes.
+		var duration int64
+		if durationStr != "" {
+			if err := validation.Validate(durationStr, "room duration", validation.AnInteger()); err != nil {
+				return err
+			}
+			duration, _ = strconv.ParseInt(durationStr, 10, 64)
+		}
+
+		room := model.NewRoomDTO(name, int(access), int(capacity), password)
+		room.SetSchedule(start, time.Duration(duration)*time.Minute)
+		room.SetLobby(lobby)
+		roomId, _, err := h.createRoom(w, room)
 		if err != nil {
 			return err
 		}
@@ -196,24 +385,82 @@ func (h RoomHandlers) PostCreateRoom() HandlerAdapter {
 	return *handler
 }
 
+// PostUnlockRoom checks the password of a protected room. On success it
+// gives the browser a join ticket in a cookie, so the password itself is
+// not sent again when the room page connects.
+func (h RoomHandlers) PostUnlockRoom() HandlerAdapter {
+	handler := NewHandlerAdapter("POST /x/rooms/{roomId}/unlock")
+	handler.AddRateLimit(h.joinLimit, handleErrorMessage(newError429))
+
+	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
+		roomIdStr := r.PathValue("roomId")
+		roomId, err := strconv.ParseInt(roomIdStr, 10, 64)
+		if err != nil {
+			return model.ErrRoomDoesNotExist
+		}
+
+		roomInfo, err := h.manager.GetRoom(int(roomId))
+		if err != nil {
+			return err
+		}
+		if !h.mayEnter(r, roomInfo) {
+			return model.ErrRoomDoesNotExist
+		}
+		if err := h.manager.CheckPassword(int(roomId), r.PostFormValue("password")); err != nil {
+			return err
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     ticketCookie(int(roomId)),
+			Value:    h.tickets.Issue(int(roomId)),
+			Path:     "/",
+			MaxAge:   int(h.tickets.TTL().Seconds()),
+			Secure:   h.cfg.Secured(),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		message := message{
+			Success:     GetLocale(r).GetOr("room-was-unlocked", "Room was unlocked successfully"),
+			RedirectURL: fmt.Sprintf("/room/%d", roomId),
+		}
+		return vr.ExecuteView(MessageView, w, message)
+	})
+
+	handler.AddErrorHandler(
+		func(err error) bool {
+			return errors.Is(err, model.ErrWrongPassword) || errors.Is(err, model.ErrRoomDoesNotExist)
+		},
+		handleErrorMessage(newError400),
+	)
+	handler.AddErrorHandler(
+		func(err error) bool { return true },
+		handleErrorMessage(newError500),
+	)
+
+	return *handler
+}
+
 func (h RoomHandlers) PutConnect() HandlerAdapter {
 	handler := NewHandlerAdapter("PUT /x/rooms/connect")
 
 	handler.AddHandler(func(w http.ResponseWriter, r *http.Request) error {
 		roomIdStr := r.PostFormValue("id")
+		invite := r.PostFormValue("invite")
 
 		if err := validation.Validate(roomIdStr, "room id", validation.AnInteger()); err != nil {
 			return err
 		}
 		roomId, _ := strconv.ParseInt(roomIdStr, 10, 64)
 
-		if _, err := h.manager.GetRoom(int(roomId)); err != nil {
+		redirectURL, err := h.connectURL(r, int(roomId), invite)
+		if err != nil {
 			return err
 		}
 
 		message := message{
 			Success:     GetLocale(r).GetOr("room-was-found", "Room was found successfully"),
-			RedirectURL: fmt.Sprintf("/room/%d", roomId),
+			RedirectURL: redirectURL,
 		}
 		return vr.ExecuteView(MessageView, w, message)
 	})
@@ -233,20 +480,239 @@ func (h RoomHandlers) PutConnect() HandlerAdapter {
 	return *handler
 }
 
+// createRoom validates and creates the room. The browser of the creator
+// keeps the host key of the room, which is returned along with its id.
+func (h RoomHandlers) createRoom(w http.ResponseWriter, room *model.RoomDTO) (int, string, error) {
+	if err := room.Validate(); err != nil {
+		return 0, "", err
+	}
+
+	roomId, hostKey, err := h.manager.CreateRoom(*room)
+	if err != nil {
+		return 0, "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     hostCookie(roomId),
+		Value:    hostKey,
+		Path:     "/",
+		MaxAge:   int(hostCookieMaxAge.Seconds()),
+		Secure:   h.cfg.Secured(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return roomId, hostKey, nil
+}
+
+// connectURL returns the URL of the room page to connect to. A private
+// room is not found by its id alone, its URL carries the invite code.
+func (h RoomHandlers) connectURL(r *http.Request, roomId int, invite string) (string, error) {
+	roomInfo, err := h.manager.GetRoom(roomId)
+	if err != nil {
+		return "", err
+	}
+
+	roomURL := fmt.Sprintf("/room/%d", roomId)
+	if !h.mayEnter(r, roomInfo) {
+		if invite == "" {
+			return "", model.ErrRoomDoesNotExist
+		}
+		roomURL += "?invite=" + url.QueryEscape(invite)
+	}
+	return roomURL, nil
+}
+
+// joinRoom connects a guest to a room, it is either JoinRoom or ResumeRoom.
+type joinRoom func(roomId int, guest model.Guest, transport model.Transport) (*model.Client, error)
+
+// admit decides whether the request may connect to the room and returns
+// the guest it connects as along with the way to connect it. A private
+// room is entered with the pass given for a redeemed invite.
+func (h RoomHandlers) admit(r *http.Request, room model.RoomInfo) (model.Guest, joinRoom, error) {
+	if !h.mayEnter(r, room) {
+		return model.Guest{}, nil, errForbidden
+	}
+
+	guest := model.Guest{
+		Token: r.URL.Query().Get("resume"),
+		Addr:  clientAddr(r),
+		Host:  h.isHost(r, room.Id),
+		Name:  displayName(r),
+	}
+
+	// Without a join ticket a client of a protected room may only resume.
+	join := h.manager.JoinRoom
+	if !h.mayJoin(r, room) {
+		if guest.Token == "" {
+			return guest, nil, errForbidden
+		}
+		join = h.manager.ResumeRoom
+	}
+
+	// A new client must come while the room is open and unlocked,
+	// the owner of the room checks it again.
+	if guest.Token == "" {
+		if err := room.CheckOpen(time.Now()); err != nil {
+			return guest, nil, err
+		}
+		if room.Locked && !guest.Host {
+			return guest, nil, model.ErrRoomLocked
+		}
+	}
+	return guest, join, nil
+}
+
+// mayJoin reports whether the request may join the room, which it may
+// if the room has no password, the request has a valid join ticket or
+// comes from the host.
+func (h RoomHandlers) mayJoin(r *http.Request, room model.RoomInfo) bool {
+	if !room.Protected || h.isHost(r, room.Id) {
+		return true
+	}
+	cookie, err := r.Cookie(ticketCookie(room.Id))
+	if err != nil {
+		return false
+	}
+	return h.tickets.Verify(cookie.Value, room.Id) == nil
+}
+
+// ticketCookie returns the name of the cookie with the join ticket of the room.
+func ticketCookie(roomId int) string {
+	return fmt.Sprintf("room-ticket-%d", roomId)
+}
+
+// keepAlive returns the WebSocket keep-alive settings of the config.
+func keepAlive(cfg *config.Config) model.KeepAlive {
+	return model.KeepAlive{
+		PingInterval:  cfg.PingInterval(),
+		PongTimeout:   cfg.PongTimeout(),
+		WriteTimeout:  cfg.WriteTimeout(),
+		ResumeTimeout: cfg.ResumeTimeout(),
+	}
+}
+
+// signalTimeouts returns the signaling deadlines of the config.
+func signalTimeouts(cfg *config.Config) model.SignalTimeouts {
+	return model.SignalTimeouts{
+		Offer:  cfg.OfferTimeout(),
+		Answer: cfg.AnswerTimeout(),
+	}
+}
+
+// roomStore returns the room store of the config. Rooms are kept in memory
+// if no file is set or the file cannot be opened.
+func roomStore(cfg *config.Config) model.RoomStore {
+	path := cfg.StorePath()
+	if path == "" {
+		return model.NewMemoryRoomStore()
+	}
+
+	store, err := model.NewFileRoomStore(path)
+	if err != nil {
+		slog.Error("Open room store:", "path", path, "error", err)
+		return model.NewMemoryRoomStore()
+	}
+	return store
+}
+
+// roomBackplane returns the backplane of the config, which shares rooms
+// with other instances. It returns nil if no backplane is set or it cannot
+// be reached, then rooms are kept to this instance.
+func roomBackplane(cfg *config.Config) backplane.Backplane {
+	addr := cfg.BackplaneAddr()
+	if addr == "" {
+		return nil
+	}
+
+	b, err := backplane.DialNats(addr)
+	if err != nil {
+		slog.Error("Connect to backplane:", "addr", addr, "error", err)
+		return nil
+	}
+	return b
+}
+
+// ticketKey returns the key which signs join tickets, it is the secret of
+// the config. Without a secret the key is random, then tickets of this
+// instance are not accepted by others.
+func ticketKey(cfg *config.Config) []byte {
+	key := []byte(cfg.Secret())
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		// crypto/rand.Read never returns an error and always fills the slice.
+		_, _ = rand.Read(key)
+	}
+	return key
+}
+
+// roomPageModel is the model of the room page.
+type roomPageModel struct {
+	model.RoomInfo
+	Host       bool   // Whether the page is opened by the host of the room
+	InviteCode string // Invite the host shares a private room by
+	IceServers []iceServerDTO
+}
+
+// iceServerDTO is an ICE server as RTCPeerConnection takes it.
+type iceServerDTO struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// iceServers returns the ICE servers of the config.
+func iceServers(cfg *config.Config) []iceServerDTO {
+	servers := []iceServerDTO{}
+	for _, server := range cfg.IceServers() {
+		servers = append(servers, iceServerDTO{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		})
+	}
+	return servers
+}
+
 type roomInfoDTO struct {
 	Id           int
 	Name         string
 	Clients      int
+	Waiting      int
+	Capacity     int
 	CreationTime string
+	Protected    bool
+	Lobby        bool
+	StartTime    string // RFC 3339, empty if the room opened on creation
 }
 
 func newRoomInfoDTO(room model.RoomInfo) *roomInfoDTO {
-	return &roomInfoDTO{
+	dto := &roomInfoDTO{
 		Id:           room.Id,
 		Name:         room.Name,
 		Clients:      room.Clients,
+		Waiting:      room.Waiting,
+		Capacity:     room.Capacity,
 		CreationTime: room.CreationTime.Format("15:04"),
+		Protected:    room.Protected,
+		Lobby:        room.Lobby,
 	}
+	if !room.StartTime.IsZero() {
+		dto.StartTime = room.StartTime.Format(time.RFC3339)
+	}
+	return dto
+}
+
+// roomListModel is the model of the list of the public rooms, which shows
+// the first page of the rooms found.
+type roomListModel struct {
+	Page  template.HTML
+	Total int
+}
+
+// roomListPageModel is the model of a page of the public rooms.
+type roomListPageModel struct {
+	Rooms   []template.HTML
+	NextURL string // Empty on the last page
 }
 
 type message struct {
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/branow/peer-chat/model"
+)
+
+// How long clients are asked to wait before they retry a request turned
+// away by a shutdown, the server is expected to be back by then.
+const shutdownRetryAfter = 30
+
+// Draining tells the handlers that the server shuts down. Once it starts,
+// requests for new rooms and joins are turned away and long-lived
+// responses end.
+type Draining struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func NewDraining() *Draining {
+	return &Draining{done: make(chan struct{})}
+}
+
+// Start starts draining, it may be called more than once.
+func (d *Draining) Start() {
+	d.once.Do(func() { close(d.done) })
+}
+
+// Done returns a channel which is closed once draining starts.
+func (d *Draining) Done() <-chan struct{} {
+	return d.done
+}
+
+// handle rejects the request with model.ErrShuttingDown once draining
+// starts.
+func (d *Draining) handle(w http.ResponseWriter, r *http.Request) error {
+	select {
+	case <-d.done:
+		return model.ErrShuttingDown
+	default:
+		return nil
+	}
+}
+
+// Shutdown turns away new rooms and joins, ends the streams of room events
+// and shuts the rooms down. Signaling under way may end until the context
+// is done.
+func (h RoomHandlers) Shutdown(ctx context.Context) {
+	h.draining.Start()
+	h.manager.Shutdown(ctx)
+}
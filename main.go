@@ -1,38 +1,178 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/branow/peer-chat/certs"
 	"github.com/branow/peer-chat/config"
 	"github.com/branow/peer-chat/handlers"
 )
 
+// How long the servers may take to end the requests under way on shutdown,
+// before their connections are closed.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
-	logLevel := slog.Level(config.GetConfig().LogLevel())
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logConfigErrors(err)
+		os.Exit(2)
+	}
+
+	logLevel := slog.Level(cfg.LogLevel())
 	slog.SetLogLoggerLevel(logLevel)
 
-	if err := start(); err != nil {
+	if err := start(cfg); err != nil {
 		slog.Error("Server startup failed:", "error", err)
 		os.Exit(1)
 	}
 }
 
-func start() error {
-	server := NewServer(config.GetConfig().Port())
-	slog.Info("Server started:", "addr", server.Addr)
-	return server.ListenAndServe()
+// logConfigErrors logs every error of the config on its own line.
+func logConfigErrors(err error) {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, err := range errs {
+		slog.Error("Load config:", "error", err)
+	}
+}
+
+func start(cfg *config.Config) error {
+	server, rooms := NewServer(cfg)
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	servers := []*http.Server{server}
+	serve := server.ListenAndServe
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+
+		if port := cfg.RedirectPort(); port != 0 {
+			redirect := NewRedirectServer(port, cfg.Port())
+			servers = append(servers, redirect)
+			go func() {
+				slog.Info("Redirect server started:", "addr", redirect.Addr)
+				if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					slog.Error("Redirect server failed:", "error", err)
+				}
+			}()
+		}
+	}
+
+	failed := make(chan error, 1)
+	go func() { failed <- serve() }()
+	slog.Info("Server started:", "addr", server.Addr, "tls", tlsConfig != nil)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-stop:
+		// Another signal kills the server right away.
+		signal.Stop(stop)
+		slog.Info("Shutting down server:", "signal", sig)
+	}
+	return shutdown(rooms, cfg.ShutdownGrace(), servers...)
+}
+
+// shutdown shuts the rooms down first, giving signaling under way the grace
+// period, then the servers. Connections which outlast the shutdown timeout
+// are closed.
+func shutdown(rooms *handlers.RoomHandlers, grace time.Duration, servers ...*http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	rooms.Shutdown(ctx)
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	errs := []error{}
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("Shut down server:", "addr", server.Addr, "error", err)
+			errs = append(errs, server.Close())
+		}
+	}
+	slog.Info("Server stopped:")
+	return errors.Join(errs...)
 }
 
-func NewServer(port int) *http.Server {
+// NewServer returns the server of the application along with its room
+// handlers, which shut the rooms down.
+func NewServer(cfg *config.Config) (*http.Server, *handlers.RoomHandlers) {
 	mux := &http.ServeMux{}
-	handlers.HandleServeMux(mux)
+	rooms := handlers.HandleServeMux(mux, cfg)
 
 	server := &http.Server{
-		Addr:    ":" + strconv.Itoa(int(port)),
+		Addr:    ":" + strconv.Itoa(cfg.Port()),
 		Handler: mux,
 	}
-	return server
+	return server, rooms
+}
+
+// NewRedirectServer returns a server which redirects HTTP requests to
+// the HTTPS server listening on the TLS port.
+func NewRedirectServer(port int, tlsPort int) *http.Server {
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+		}
+		url := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, url, http.StatusMovedPermanently)
+	}
+
+	return &http.Server{
+		Addr:    ":" + strconv.Itoa(port),
+		Handler: http.HandlerFunc(redirect),
+	}
+}
+
+// newTLSConfig returns the TLS config of the certificate files of
+// the config, or of a self-signed certificate in the dev mode. It returns
+// nil if the server does not serve HTTPS itself.
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	certFile, keyFile := cfg.CertFile(), cfg.KeyFile()
+	if certFile == "" && cfg.DevTLS() {
+		var err error
+		if certFile, keyFile, err = certs.SelfSigned(cfg.DevTLSDir()); err != nil {
+			return nil, err
+		}
+	}
+	if certFile == "" {
+		return nil, nil
+	}
+
+	reloader, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
 }
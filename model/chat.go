@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrChatTooLong = errors.New("chat message is too long")
+
+// Chat messages are relayed by the server, so they keep working while
+// the peers are not linked. A room keeps its latest messages for the
+// clients which join later.
+const (
+	chatHistorySize   = 50
+	maxChatMessageLen = 2000 // In characters
+)
+
+// addPeer adds the peer to the client list and returns the chat history
+// it has missed. Taken under the chat mutex, the history never overlaps
+// with the messages broadcast to the peer later.
+func (c *PeerConnection) addPeer(peer *Peer) []Message {
+	c.chatMutex.Lock()
+	defer c.chatMutex.Unlock()
+
+	c.clients.AddClient(peer)
+	return append([]Message{}, c.chat...)
+}
+
+// replayChat sends the chat history to the peer which has just joined.
+func (c *PeerConnection) replayChat(peer *Peer, history []Message) {
+	for _, message := range history {
+		if err := peer.SendMessage(message); err != nil {
+			slog.Debug("Replaying chat:", "peer-connection", c.Id(),
+				"client", peer.Id(), "error", err)
+			return
+		}
+	}
+}
+
+// relayChat broadcasts the chat message of the peer to every other client,
+// including the waiting ones, and keeps it in the history. The author is
+// set as the peer of the message along with its name.
+func (c *PeerConnection) relayChat(from *Peer, chat Message) {
+	text := strings.TrimSpace(chat.Data)
+	if text == "" {
+		return
+	}
+	if utf8.RuneCountInString(text) > maxChatMessageLen {
+		_ = from.SendMessage(Message{MessageType: Error, Data: ErrChatTooLong.Error()})
+		return
+	}
+	message := Message{MessageType: Chat, Data: text, Peer: from.PeerId(), Name: from.Name()}
+
+	c.chatMutex.Lock()
+	c.chat = append(c.chat, message)
+	if len(c.chat) > chatHistorySize {
+		c.chat = c.chat[len(c.chat)-chatHistorySize:]
+	}
+	recipients := c.clients.FindFirst(c.clients.Size())
+	c.chatMutex.Unlock()
+
+	// The chat is sent on the goroutine of the author, so a recipient
+	// which cannot keep up is dropped rather than hold the author up.
+	for _, peer := range recipients {
+		if peer == from {
+			continue
+		}
+		if err := peer.notify(message); err != nil {
+			slog.Debug("Relaying chat:", "peer-connection", c.Id(),
+				"client", peer.Id(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectChat waits for the next chat message and checks its text.
+func expectChat(tb testing.TB, transport *memoryTransport, text string) Message {
+	tb.Helper()
+	message := transport.expect(tb, Chat)
+	if message.Data != text {
+		tb.Fatalf("chat: got %q, want %q", message.Data, text)
+	}
+	return message
+}
+
+func TestChat(t *testing.T) {
+	m := newTestManager()
+	roomId := newTestRoom(t, m, 2)
+	alice := joinTestRoom(t, m, roomId, Guest{Name: "Alice"})
+	alice.expect(t, Session)
+	bob := joinTestRoom(t, m, roomId, Guest{Name: "Bob"})
+	bob.expect(t, Session)
+	waiting := joinTestRoom(t, m, roomId, Guest{})
+	waiting.expect(t, Wait)
+
+	// The chat reaches the waiting clients too, but not its author.
+	alice.send(Message{MessageType: Chat, Data: "  hello  "})
+	for _, transport := range []*memoryTransport{bob, waiting} {
+		if got := expectChat(t, transport, "hello"); got.Name != "Alice" || got.Peer == 0 {
+			t.Errorf("author: got %q with peer %d, want Alice", got.Name, got.Peer)
+		}
+	}
+	bob.send(Message{MessageType: Chat, Data: "hi"})
+	expectChat(t, alice, "hi")
+
+	// Empty and too long messages are not relayed.
+	alice.send(Message{MessageType: Chat, Data: strings.Repeat("a", maxChatMessageLen+1)})
+	if got := alice.expect(t, Error); got.Data != ErrChatTooLong.Error() {
+		t.Errorf("error: got %q, want %q", got.Data, ErrChatTooLong.Error())
+	}
+	alice.send(Message{MessageType: Chat, Data: "   "})
+	alice.send(Message{MessageType: Chat, Data: "after"})
+	expectChat(t, bob, "after")
+}
+
+func TestChatHistory(t *testing.T) {
+	m := newTestManager()
+	roomId := newTestRoom(t, m, 2)
+	alice := joinTestRoom(t, m, roomId, Guest{})
+	alice.expect(t, Session)
+	bob := joinTestRoom(t, m, roomId, Guest{})
+	bob.expect(t, Session)
+
+	sent := chatHistorySize + 5
+	for i := range sent {
+		alice.send(Message{MessageType: Chat, Data: fmt.Sprintf("message %d", i)})
+	}
+	for i := range sent {
+		expectChat(t, bob, fmt.Sprintf("message %d", i))
+	}
+
+	// A client joining later gets the latest messages only, in order.
+	late := joinTestRoom(t, m, roomId, Guest{})
+	for i := sent - chatHistorySize; i < sent; i++ {
+		expectChat(t, late, fmt.Sprintf("message %d", i))
+	}
+	alice.send(Message{MessageType: Chat, Data: "after"})
+	expectChat(t, late, "after")
+}
+
+func TestChatDropsSlowClient(t *testing.T) {
+	keepAlive := testKeepAlive
+	keepAlive.WriteTimeout = time.Hour
+	m := NewRoomManager(keepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), nil)
+	roomId := newTestRoom(t, m, 3)
+	alice := joinTestRoom(t, m, roomId, Guest{})
+	alice.expect(t, Session)
+	bob := joinTestRoom(t, m, roomId, Guest{})
+	bob.expect(t, Session)
+	slow := joinTestRoom(t, m, roomId, Guest{})
+	slow.expect(t, Session)
+
+	// A client which stops reading is dropped once the chat piles up,
+	// the others keep getting it in time.
+	slow.stalled.Store(true)
+	for i := range 3 * cap(slow.out) {
+		alice.send(Message{MessageType: Chat, Data: fmt.Sprintf("message %d", i)})
+		expectChat(t, bob, fmt.Sprintf("message %d", i))
+	}
+	select {
+	case <-slow.closed:
+	case <-time.After(testTimeout):
+		t.Fatalf("slow client not dropped in %v", testTimeout)
+	}
+}
@@ -1,39 +1,82 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log/slog"
 	"math/rand"
 	"sync"
 	"sync/atomic"
+	"time"
+)
 
-	"github.com/gorilla/websocket"
+var (
+	ErrClientIsClosed = errors.New("client is closed")
+	ErrClientIsBusy   = errors.New("client is busy")
 )
 
-var ErrClientIsClosed = errors.New("client is closed")
+// KeepAlive defines how a client detects a peer which went silent.
+// The client pings the peer every PingInterval and drops the connection
+// if nothing, including a pong, is received within PongTimeout.
+// A dropped connection may be resumed within ResumeTimeout before
+// the client is closed, zero disables resuming.
+type KeepAlive struct {
+	PingInterval  time.Duration
+	PongTimeout   time.Duration
+	WriteTimeout  time.Duration
+	ResumeTimeout time.Duration
+}
 
-// Client maintains a websocket connection and provides basic operations
-// for reading and writing data.
+var DefaultKeepAlive = KeepAlive{
+	PingInterval:  25 * time.Second,
+	PongTimeout:   60 * time.Second,
+	WriteTimeout:  10 * time.Second,
+	ResumeTimeout: 30 * time.Second,
+}
+
+// Client maintains a connection over a Transport and provides basic
+// operations for reading and writing data. If the connection drops, the client keeps
+// its data and waits for it to be resumed with a new connection.
 type Client struct {
 	id         int // Is used to identify client during debugging
-	connection *websocket.Conn
+	connection *connection
+	keepAlive  KeepAlive
 	out        chan []byte
 	in         chan []byte
+	resume     chan *connection
+	done       chan struct{} // Is closed when the client is closed
+	closing    chan struct{} // Is closed when the client is asked to close
+	closeOnce  sync.Once
 	isClosed   int32 // Use atomic for thread-safety
 	onClose    func()
-	wg         sync.WaitGroup
+	mutex      sync.Mutex // Guards onClose and connection
 }
 
-func NewClient(conn *websocket.Conn) *Client {
+// connection is a single connection of a client.
+type connection struct {
+	transport Transport
+	done      chan struct{} // Is closed when the connection is closed
+}
+
+func newConnection(transport Transport) *connection {
+	return &connection{transport: transport, done: make(chan struct{})}
+}
+
+func NewClient(transport Transport, keepAlive KeepAlive) *Client {
 	client := &Client{
 		id:         rand.Intn(1e5),
-		connection: conn,
+		connection: newConnection(transport),
+		keepAlive:  keepAlive,
 		out:        make(chan []byte, 100),
 		in:         make(chan []byte, 100),
+		resume:     make(chan *connection),
+		done:       make(chan struct{}),
+		closing:    make(chan struct{}),
 		onClose:    func() {},
 	}
-	client.start()
+	go client.run()
+	slog.Debug("Client started:", "client-id", client.id)
 	return client
 }
 
@@ -41,97 +84,274 @@ func (c *Client) Id() int {
 	return c.id
 }
 
-// Wait blocks until all read and write goroutines for the client
-// have finished, indicating that the conenction is closed.
+// Done returns a channel which is closed once the client is closed and
+// its queued messages are flushed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// IsClosed reports whether the client is closed.
+func (c *Client) IsClosed() bool {
+	return atomic.LoadInt32(&c.isClosed) == 1
+}
+
+// Wait blocks until the current connection of the client is closed,
+// either because the client is closed or the connection dropped.
 func (c *Client) Wait() {
-	c.wg.Wait()
+	c.mutex.Lock()
+	connection := c.connection
+	c.mutex.Unlock()
+	<-connection.done
+}
+
+// Resume replaces the connection of the client with the given one.
+// The current connection is dropped if it still seems to be alive.
+// The connection is handed off under the lock Wait takes, so Wait never
+// sees the dropped connection once the new one is served.
+func (c *Client) Resume(transport Transport) error {
+	connection := newConnection(transport)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_ = c.connection.transport.Close()
+	select {
+	case c.resume <- connection:
+	case <-c.done:
+		return ErrClientIsClosed
+	}
+
+	c.connection = connection
+	slog.Debug("Client resumed:", "client-id", c.id)
+	return nil
 }
 
 // Send pushes the given slice of bytes into the output channel.
 // Blocks if the output channel buffer is full.
 func (c *Client) Send(data []byte) error {
+	return c.SendContext(context.Background(), data)
+}
+
+// SendContext is like Send but gives up when the context is done.
+func (c *Client) SendContext(ctx context.Context, data []byte) error {
 	if atomic.LoadInt32(&c.isClosed) == 1 {
 		return ErrClientIsClosed
 	}
-	c.out <- data
-	return nil
+	select {
+	case c.out <- data:
+		return nil
+	case <-c.done:
+		return ErrClientIsClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySend is like Send but fails with ErrClientIsBusy instead of blocking
+// if the output channel buffer is full.
+func (c *Client) TrySend(data []byte) error {
+	if atomic.LoadInt32(&c.isClosed) == 1 {
+		return ErrClientIsClosed
+	}
+	select {
+	case c.out <- data:
+		return nil
+	case <-c.done:
+		return ErrClientIsClosed
+	default:
+		return ErrClientIsBusy
+	}
 }
 
 // Receive retrives the slive of bytes from the input channel.
 // Blocks if there is no data available.
 func (c *Client) Receive() ([]byte, error) {
+	return c.ReceiveContext(context.Background())
+}
+
+// ReceiveContext is like Receive but gives up when the context is done.
+func (c *Client) ReceiveContext(ctx context.Context) ([]byte, error) {
 	if atomic.LoadInt32(&c.isClosed) == 1 {
 		return nil, ErrClientIsClosed
 	}
-	return <-c.in, nil
+	select {
+	case data, ok := <-c.in:
+		if !ok {
+			return nil, ErrClientIsClosed
+		}
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close writes the queued messages and closes the connection gracefully,
+// which also triggers the onClose callback.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { close(c.closing) })
 }
 
 // SetOnClose assigns a callback function to be executed after
 // the connection is closed.
 func (c *Client) SetOnClose(onClose func()) {
 	if onClose != nil {
+		c.mutex.Lock()
 		c.onClose = onClose
+		c.mutex.Unlock()
 	}
 }
 
-func (c *Client) readMessages() {
+// run serves the connections of the client one after another until
+// the client is closed or a dropped connection is not resumed in time.
+func (c *Client) run() {
 	defer func() {
 		close(c.in)
 		c.close()
 	}()
 
+	connection := c.connection
 	for {
-		_, r, err := c.connection.NextReader()
-		if err != nil {
-			slog.Error("Client read:", "client-id", c.id, "error", err)
-			break
+		dropped := c.serve(connection)
+		if !dropped || c.keepAlive.ResumeTimeout <= 0 {
+			return
 		}
 
-		data, err := io.ReadAll(r)
+		slog.Debug("Client waits for resume:", "client-id", c.id)
+		timer := time.NewTimer(c.keepAlive.ResumeTimeout)
+		select {
+		case connection = <-c.resume:
+			timer.Stop()
+		case <-timer.C:
+			return
+		case <-c.closing:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// serve pumps messages over the given connection until it fails or
+// the client is closed. It reports whether the connection dropped
+// unexpectedly, so it is worth waiting for the client to resume.
+func (c *Client) serve(connection *connection) bool {
+	defer close(connection.done)
+
+	stop := make(chan struct{})
+	written := make(chan struct{})
+	var readErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readErr = c.readMessages(connection.transport, written)
+		close(stop)
+	}()
+
+	closed := c.writeMessages(connection.transport, stop)
+	close(written)
+	_ = connection.transport.Close()
+	wg.Wait()
+
+	if closed {
+		return false
+	}
+	return !errors.Is(readErr, io.EOF)
+}
+
+// readMessages reads data from the transport into the input channel.
+// Every received message or pong extends the read deadline, so a silent
+// peer makes the read fail. Terminates on read errors or once the writing
+// stops, so a message nobody receives does not keep the connection open.
+func (c *Client) readMessages(transport Transport, written <-chan struct{}) error {
+	extendReadDeadline := func() {
+		_ = transport.SetReadDeadline(time.Now().Add(c.keepAlive.PongTimeout))
+	}
+	extendReadDeadline()
+	transport.SetPongHandler(extendReadDeadline)
+
+	for {
+		data, err := transport.ReadMessage()
 		if err != nil {
 			slog.Error("Client read:", "client-id", c.id, "error", err)
-			break
+			return err
 		}
 
-		c.in <- data
+		extendReadDeadline()
+		select {
+		case c.in <- data:
+		case <-written:
+			return ErrTransportClosed
+		}
 	}
 }
 
 // writeMessages reads data from the output channel and writes it
-// to the WebSocket connection. Terminates on write errors or channel closure.
-func (c *Client) writeMessages() {
-	defer func() {
-		close(c.out)
-		c.close()
-	}()
+// to the transport, pinging the peer periodically.
+// Terminates on write errors, when the reading stops or the client
+// is closed. It reports whether the client is closed.
+func (c *Client) writeMessages(transport Transport, stop <-chan struct{}) bool {
+	ticker := time.NewTicker(c.keepAlive.PingInterval)
+	defer ticker.Stop()
 
-	for data := range c.out {
-		if err := c.connection.WriteMessage(websocket.TextMessage, data); err != nil {
-			slog.Error("Client write:", "client-id", c.id, "error", err)
-			break
+	for {
+		select {
+		case data := <-c.out:
+			deadline := time.Now().Add(c.keepAlive.WriteTimeout)
+			if err := transport.WriteMessage(data, deadline); err != nil {
+				slog.Error("Client write:", "client-id", c.id, "error", err)
+				return false
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(c.keepAlive.WriteTimeout)
+			if err := transport.Ping(deadline); err != nil {
+				slog.Error("Client ping:", "client-id", c.id, "error", err)
+				return false
+			}
+		case <-stop:
+			return false
+		case <-c.closing:
+			c.flush(transport)
+			return true
+		case <-c.done:
+			return true
 		}
 	}
 }
 
-func (c *Client) start() {
-	c.wg.Add(2)
-	go func() {
-		defer c.wg.Done()
-		c.readMessages()
-	}()
-	go func() {
-		defer c.wg.Done()
-		c.writeMessages()
-	}()
-	slog.Debug("Client started:", "client-id", c.id)
+// flush writes the queued messages and shuts the transport down.
+func (c *Client) flush(transport Transport) {
+	deadline := time.Now().Add(c.keepAlive.WriteTimeout)
+	for {
+		select {
+		case data := <-c.out:
+			if err := transport.WriteMessage(data, deadline); err != nil {
+				return
+			}
+		default:
+			_ = transport.Shutdown(deadline)
+			return
+		}
+	}
+}
+
+// closeConnection closes the current connection.
+func (c *Client) closeConnection() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_ = c.connection.transport.Close()
 }
 
-// close safely closes WebSocket connection, invokes the onClose callback,
-// and marks the client as closed using an atomic operation.
+// close invokes the onClose callback and marks the client as closed
+// using an atomic operation.
 func (c *Client) close() {
 	if atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
-		_ = c.connection.Close()
-		c.onClose()
+		close(c.done)
+		c.closeConnection()
+		c.mutex.Lock()
+		onClose := c.onClose
+		c.mutex.Unlock()
+		onClose()
 		slog.Debug("Client closed:", "client-id", c.id)
 	}
 }
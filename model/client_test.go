@@ -0,0 +1,374 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// How long a test waits for a message before it gives up.
+const testTimeout = 2 * time.Second
+
+// testKeepAlive never pings and lets a dropped connection be resumed for
+// a while, so the tests decide when a connection ends.
+var testKeepAlive = KeepAlive{
+	PingInterval:  time.Hour,
+	PongTimeout:   time.Hour,
+	WriteTimeout:  time.Second,
+	ResumeTimeout: time.Second,
+}
+
+// memoryTransport is a Transport within the process. The test plays
+// the browser on its remote side: it answers signaling on its own and
+// keeps every message to be expected by the test.
+type memoryTransport struct {
+	in           chan []byte // Messages of the remote side
+	out          chan []byte // Messages to the remote side
+	received     chan Message
+	silent       atomic.Bool   // Whether the remote side leaves signaling to the test
+	stalled      atomic.Bool   // Whether the remote side stopped reading
+	hungUp       chan struct{} // Is closed when the remote side hangs up
+	shutdown     chan struct{} // Is closed when the client hangs up
+	closed       chan struct{}
+	hangUpOnce   sync.Once
+	shutdownOnce sync.Once
+	closeOnce    sync.Once
+}
+
+func newMemoryTransport() *memoryTransport {
+	t := &memoryTransport{
+		in:       make(chan []byte, 100),
+		out:      make(chan []byte, 100),
+		received: make(chan Message, 100),
+		hungUp:   make(chan struct{}),
+		shutdown: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go t.answer()
+	return t
+}
+
+// answer plays the browser, which replies to the signaling of the server.
+func (t *memoryTransport) answer() {
+	for {
+		// The messages written before the connection closed still arrive.
+		var data []byte
+		select {
+		case data = <-t.out:
+		case <-t.closed:
+			select {
+			case data = <-t.out:
+			default:
+				return
+			}
+		}
+
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+		t.received <- message
+		if t.silent.Load() {
+			continue
+		}
+		switch message.MessageType {
+		case RequestOffer:
+			t.send(Message{MessageType: Offer, SDP: "offer", Peer: message.Peer})
+		case Offer:
+			t.send(Message{MessageType: Answer, SDP: "answer", Peer: message.Peer})
+		}
+	}
+}
+
+// send sends the message to the client as the remote side.
+func (t *memoryTransport) send(message Message) {
+	data, _ := json.Marshal(message)
+	select {
+	case t.in <- data:
+	case <-t.closed:
+	}
+}
+
+// expect waits for the next message of the given type to the remote side,
+// skipping the messages of other types.
+func (t *memoryTransport) expect(tb testing.TB, messageType MessageType) Message {
+	tb.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case message := <-t.received:
+			if message.MessageType == string(messageType) {
+				return message
+			}
+		case <-timeout:
+			tb.Fatalf("no %q message in %v", messageType, testTimeout)
+			return Message{}
+		}
+	}
+}
+
+// expectShutdown waits for the client to hang up on purpose.
+func (t *memoryTransport) expectShutdown(tb testing.TB) {
+	tb.Helper()
+	select {
+	case <-t.shutdown:
+	case <-time.After(testTimeout):
+		tb.Fatalf("transport not shut down in %v", testTimeout)
+	}
+}
+
+// hangUp closes the connection on purpose as the remote side.
+func (t *memoryTransport) hangUp() {
+	t.hangUpOnce.Do(func() { close(t.hungUp) })
+}
+
+func (t *memoryTransport) ReadMessage() ([]byte, error) {
+	select {
+	case data := <-t.in:
+		return data, nil
+	case <-t.hungUp:
+		return nil, io.EOF
+	case <-t.closed:
+		return nil, ErrTransportClosed
+	}
+}
+
+func (t *memoryTransport) WriteMessage(data []byte, deadline time.Time) error {
+	if t.stalled.Load() {
+		select {
+		case <-t.closed:
+			return ErrTransportClosed
+		case <-time.After(time.Until(deadline)):
+			return errors.New("write deadline exceeded")
+		}
+	}
+	select {
+	case t.out <- data:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	case <-time.After(time.Until(deadline)):
+		return errors.New("write deadline exceeded")
+	}
+}
+
+func (t *memoryTransport) Ping(deadline time.Time) error {
+	return nil
+}
+
+func (t *memoryTransport) SetPongHandler(handle func()) {}
+
+func (t *memoryTransport) SetReadDeadline(deadline time.Time) error {
+	return nil
+}
+
+func (t *memoryTransport) Shutdown(deadline time.Time) error {
+	t.shutdownOnce.Do(func() { close(t.shutdown) })
+	return nil
+}
+
+// Close drops the connection, as a network failure does.
+func (t *memoryTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+// newTestManager returns a manager of rooms kept in memory.
+func newTestManager() *RoomManager {
+	return NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), nil)
+}
+
+// newTestRoom creates a public room with the given capacity.
+func newTestRoom(tb testing.TB, m *RoomManager, capacity int) int {
+	tb.Helper()
+	roomId, _, err := m.CreateRoom(*NewRoomDTO("test room", public, capacity, ""))
+	if err != nil {
+		tb.Fatalf("create room: %v", err)
+	}
+	return roomId
+}
+
+// joinTestRoom joins the guest to the room over a new memory transport.
+func joinTestRoom(tb testing.TB, m *RoomManager, roomId int, guest Guest) *memoryTransport {
+	tb.Helper()
+	transport := newMemoryTransport()
+	if _, err := m.JoinRoom(roomId, guest, transport); err != nil {
+		tb.Fatalf("join room: %v", err)
+	}
+	tb.Cleanup(func() { _ = transport.Close() })
+	return transport
+}
+
+func TestClientResume(t *testing.T) {
+	first := newMemoryTransport()
+	client := NewClient(first, testKeepAlive)
+	defer client.Close()
+
+	_ = first.Close()
+	second := newMemoryTransport()
+	defer second.Close()
+	if err := client.Resume(second); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	if err := sendMessage(client, Message{MessageType: Chat, Data: "hello"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got := second.expect(t, Chat); got.Data != "hello" {
+		t.Errorf("resumed connection got %q, want %q", got.Data, "hello")
+	}
+
+	second.send(Message{MessageType: Chat, Data: "hi"})
+	data, err := client.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	var message Message
+	_ = json.Unmarshal(data, &message)
+	if message.Data != "hi" {
+		t.Errorf("client received %q, want %q", message.Data, "hi")
+	}
+}
+
+func TestClientWaitFollowsResumedConnection(t *testing.T) {
+	first := newMemoryTransport()
+	client := NewClient(first, testKeepAlive)
+	defer client.Close()
+
+	_ = first.Close()
+	second := newMemoryTransport()
+	if err := client.Resume(second); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while the resumed connection is alive")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = second.Close()
+	select {
+	case <-waited:
+	case <-time.After(testTimeout):
+		t.Fatal("Wait did not return once the resumed connection dropped")
+	}
+}
+
+func TestClientNotResumedInTime(t *testing.T) {
+	keepAlive := testKeepAlive
+	keepAlive.ResumeTimeout = 50 * time.Millisecond
+	transport := newMemoryTransport()
+	client := NewClient(transport, keepAlive)
+
+	_ = transport.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("client not closed after the resume timeout")
+	}
+	if err := client.Resume(newMemoryTransport()); !errors.Is(err, ErrClientIsClosed) {
+		t.Errorf("resume of a closed client: got %v, want %v", err, ErrClientIsClosed)
+	}
+}
+
+func TestClientHangUpIsNotResumed(t *testing.T) {
+	transport := newMemoryTransport()
+	client := NewClient(transport, testKeepAlive)
+
+	transport.hangUp()
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("client waits to be resumed after the remote side hung up")
+	}
+}
+
+func TestRoomResumeKeepsSlotAndRole(t *testing.T) {
+	m := newTestManager()
+	roomId := newTestRoom(t, m, 2)
+
+	a := joinTestRoom(t, m, roomId, Guest{})
+	a.expect(t, Session)
+	b := joinTestRoom(t, m, roomId, Guest{})
+	token := b.expect(t, Session).Data
+	role := b.expect(t, Role)
+	a.expect(t, Answer)
+
+	_ = b.Close()
+	resumed := joinTestRoom(t, m, roomId, Guest{Token: token})
+	resumed.expect(t, Resumed)
+
+	// The resumed client keeps its role and only restarts ICE, so it gets
+	// no new role, and the other side never sees it leave.
+	resumed.send(Message{MessageType: Offer, SDP: "restart", Peer: role.Peer})
+	if got := a.expect(t, Offer); got.SDP != "restart" {
+		t.Errorf("renegotiation offer: got %q, want %q", got.SDP, "restart")
+	}
+	info, err := m.GetRoom(roomId)
+	if err != nil {
+		t.Fatalf("get room: %v", err)
+	}
+	if info.Clients != 2 {
+		t.Errorf("clients after resume: got %d, want 2", info.Clients)
+	}
+	select {
+	case message := <-a.received:
+		if message.MessageType == PeerLeft {
+			t.Error("the other side was told the resumed peer left")
+		}
+	default:
+	}
+}
+
+func TestJoinRejectedWithError(t *testing.T) {
+	m := newTestManager()
+
+	transport := newMemoryTransport()
+	if _, err := m.JoinRoom(42, Guest{}, transport); !errors.Is(err, ErrRoomDoesNotExist) {
+		t.Fatalf("join: got %v, want %v", err, ErrRoomDoesNotExist)
+	}
+	if got := transport.expect(t, Error); got.Data != ErrRoomDoesNotExist.Error() {
+		t.Errorf("error message: got %q, want %q", got.Data, ErrRoomDoesNotExist.Error())
+	}
+	transport.expectShutdown(t)
+}
+
+func TestResumeRejectedWithError(t *testing.T) {
+	m := newTestManager()
+	roomId := newTestRoom(t, m, 2)
+
+	transport := newMemoryTransport()
+	_, err := m.ResumeRoom(roomId, Guest{Token: "unknown"}, transport)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("resume: got %v, want %v", err, ErrSessionNotFound)
+	}
+	transport.expect(t, Error)
+	transport.expectShutdown(t)
+}
+
+func TestClientClosesWithUnreadMessages(t *testing.T) {
+	transport := newMemoryTransport()
+	client := NewClient(transport, testKeepAlive)
+
+	// Nobody receives the messages, so the input of the client fills up.
+	for range 2 * cap(client.in) {
+		transport.send(Message{MessageType: Chat, Data: "unread"})
+	}
+	client.Close()
+	transport.expectShutdown(t)
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("client with unread messages not closed")
+	}
+}
@@ -6,18 +6,19 @@ import (
 )
 
 // ClientList maintains a thread-safe list of clients with unique counters
-// for tracking and ordering.
+// for tracking and ordering. Clients are kept as peers, so they can take
+// part in signaling.
 type ClientList struct {
-	clients map[*Client]uint8
+	clients map[*Peer]uint64
 	mutex   sync.RWMutex
-	// Counter uses uint8 to limit the maximum number of client to 255,
-	// assuming this is sufficient.
-	counter uint8
+	// Counter orders the clients by when they joined, which decides who
+	// gets the active slots, so it must never wrap around.
+	counter uint64
 }
 
 func NewClientList() *ClientList {
 	return &ClientList{
-		clients: map[*Client]uint8{},
+		clients: map[*Peer]uint64{},
 	}
 }
 
@@ -28,11 +29,11 @@ func (cl *ClientList) Size() int {
 	return len(cl.clients)
 }
 
-func (cl *ClientList) AddClient(client *Client) {
+func (cl *ClientList) AddClient(peer *Peer) {
 	cl.mutex.Lock()
 	defer cl.mutex.Unlock()
 
-	cl.clients[client] = cl.counter
+	cl.clients[peer] = cl.counter
 	cl.counter++
 }
 
@@ -40,21 +41,24 @@ func (cl *ClientList) RemoveClient(client *Client) {
 	cl.mutex.Lock()
 	defer cl.mutex.Unlock()
 
-	if _, ok := cl.clients[client]; ok {
-		// Ensure the connection is closed before removing the client,
-		// even if it is likely already closed.
-		_ = client.connection.Close()
-		delete(cl.clients, client)
+	for peer := range cl.clients {
+		if peer.Client == client {
+			// Ensure the connection is closed before removing the client,
+			// even if it is likely already closed.
+			client.closeConnection()
+			delete(cl.clients, peer)
+			return
+		}
 	}
 }
 
 // Retrives the first `n` clients based on their counter value (in ascending order).
 // If the number of available clients is less than `n`, it returns all of them.
-func (cl *ClientList) FindFirst(n int) []*Client {
+func (cl *ClientList) FindFirst(n int) []*Peer {
 	cl.mutex.RLock()
 	defer cl.mutex.RUnlock()
 
-	var sortedClients []*Client
+	var sortedClients []*Peer
 	for client := range cl.clients {
 		sortedClients = append(sortedClients, client)
 	}
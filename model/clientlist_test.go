@@ -0,0 +1,25 @@
+package model
+
+import "testing"
+
+func TestClientListKeepsJoinOrder(t *testing.T) {
+	cl := NewClientList()
+	joins := 300
+	for id := range joins {
+		cl.AddClient(NewPeer(nil, id))
+	}
+
+	// The order outlives more joins than a small counter could count.
+	peers := cl.FindFirst(joins)
+	if len(peers) != joins {
+		t.Fatalf("found %d clients, want %d", len(peers), joins)
+	}
+	for i, peer := range peers {
+		if peer.PeerId() != i {
+			t.Fatalf("client %d: got peer %d, want %d", i, peer.PeerId(), i)
+		}
+	}
+	if first := cl.FindFirst(2); first[0].PeerId() != 0 || first[1].PeerId() != 1 {
+		t.Errorf("first clients: got peers %d and %d, want 0 and 1", first[0].PeerId(), first[1].PeerId())
+	}
+}
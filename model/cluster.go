@@ -0,0 +1,243 @@
+package model
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// The instances share the state of their rooms on this topic.
+const roomsTopic = "rooms"
+
+// Types of the room events.
+const (
+	roomAnnounced = "announced" // A room was created, restored or changed
+	roomRemoved   = "removed"   // A room was removed
+	roomsSync     = "sync"      // A new instance asks the others for their rooms
+)
+
+type roomEvent struct {
+	Type     string        `json:"type"`
+	Instance string        `json:"instance"`
+	Room     RoomRecord    `json:"room"`
+	Clients  int           `json:"clients"`
+	Roster   []RosterEntry `json:"roster,omitempty"`
+}
+
+// remoteRoom is a room owned by another instance.
+type remoteRoom struct {
+	record   RoomRecord
+	clients  int
+	roster   []RosterEntry
+	instance string
+}
+
+func (r remoteRoom) info() RoomInfo {
+	return RoomInfo{
+		Id:           r.record.Id,
+		Name:         r.record.Name,
+		Clients:      r.clients,
+		Waiting:      max(0, r.clients-r.record.Capacity),
+		Roster:       r.roster,
+		Capacity:     r.record.Capacity,
+		CreationTime: r.record.CreationTime,
+		Private:      r.record.Access == private,
+		InviteGen:    r.record.InviteGen,
+		Protected:    r.record.PasswordHash != "",
+		Locked:       r.record.Locked,
+		Lobby:        r.record.Lobby,
+		StartTime:    r.record.StartTime,
+		ExpiryTime:   r.record.ExpiryTime,
+	}
+}
+
+// joinCluster follows the room events of the other instances and asks
+// them for their rooms.
+func (m *RoomManager) joinCluster() error {
+	m.backplane.SetOnConnection(m.handleConnection)
+	if _, err := m.backplane.Subscribe(roomsTopic, m.handleRoomEvent); err != nil {
+		return err
+	}
+	return m.publishRoomEvent(roomEvent{Type: roomsSync})
+}
+
+func (m *RoomManager) handleRoomEvent(data []byte) {
+	var event roomEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		slog.Error("Room event read:", "error", err)
+		return
+	}
+	if event.Instance == m.instance {
+		return
+	}
+
+	switch event.Type {
+	case roomAnnounced:
+		m.mutex.Lock()
+		if _, ok := m.rooms[event.Room.Id]; ok {
+			m.mutex.Unlock()
+			return
+		}
+		old, known := m.remoteRooms[event.Room.Id]
+		room := remoteRoom{
+			record:   event.Room,
+			clients:  event.Clients,
+			roster:   event.Roster,
+			instance: event.Instance,
+		}
+		m.remoteRooms[event.Room.Id] = room
+		m.mutex.Unlock()
+
+		if !known {
+			m.emitLifecycle(LifecycleCreated, room.info())
+		} else if old.clients != room.clients {
+			m.emitLifecycle(LifecycleOccupancy, room.info())
+		}
+	case roomRemoved:
+		m.mutex.Lock()
+		room, ok := m.remoteRooms[event.Room.Id]
+		removed := ok && room.instance == event.Instance
+		if removed {
+			delete(m.remoteRooms, event.Room.Id)
+		}
+		m.mutex.Unlock()
+
+		if removed {
+			m.emitLifecycle(LifecycleRemoved, room.info())
+		}
+	case roomsSync:
+		m.mutex.RLock()
+		rooms := make([]*room, 0, len(m.rooms))
+		for _, room := range m.rooms {
+			rooms = append(rooms, room)
+		}
+		m.mutex.RUnlock()
+
+		for _, room := range rooms {
+			m.announceRoom(room)
+		}
+	}
+}
+
+// handleConnection handles the loss and the return of the connection of
+// the backplane. While it is lost, the rooms of other instances cannot be
+// reached, so they are forgotten, and the clients relayed between
+// the instances are dropped to be resumed once it is back. Then the rooms
+// are shared again.
+func (m *RoomManager) handleConnection(connected bool) {
+	if connected {
+		m.mutex.RLock()
+		rooms := make([]*room, 0, len(m.rooms))
+		for _, room := range m.rooms {
+			rooms = append(rooms, room)
+		}
+		m.mutex.RUnlock()
+
+		for _, room := range rooms {
+			m.announceRoom(room)
+		}
+		if err := m.publishRoomEvent(roomEvent{Type: roomsSync}); err != nil {
+			slog.Error("Publish room event:", "error", err)
+		}
+		slog.Info("Rejoined cluster:", "rooms", len(rooms))
+		return
+	}
+
+	m.mutex.Lock()
+	remoteRooms := m.remoteRooms
+	m.remoteRooms = map[int]remoteRoom{}
+	relayed := make([]*relayedTransport, 0, len(m.relayed))
+	for _, transport := range m.relayed {
+		relayed = append(relayed, transport)
+	}
+	outbound := make([]*Client, 0, len(m.outbound))
+	for client := range m.outbound {
+		outbound = append(outbound, client)
+	}
+	m.mutex.Unlock()
+
+	for _, transport := range relayed {
+		transport.receive(relayEnvelope{Type: relayDrop})
+	}
+	for _, client := range outbound {
+		client.closeConnection()
+	}
+	for _, room := range remoteRooms {
+		m.emitLifecycle(LifecycleRemoved, room.info())
+	}
+	slog.Warn("Lost cluster:", "remote-rooms", len(remoteRooms),
+		"relayed", len(relayed), "outbound", len(outbound))
+}
+
+// shareRoom lets the other instances list the room and relay their
+// clients to it.
+func (m *RoomManager) shareRoom(room *room) {
+	if m.backplane == nil {
+		return
+	}
+
+	unsubscribe, err := m.backplane.Subscribe(roomTopic(room.Id()), func(data []byte) {
+		m.handleRelay(room.Id(), data)
+	})
+	if err != nil {
+		slog.Error("Share room:", "room-id", room.Id(), "error", err)
+		return
+	}
+	m.mutex.Lock()
+	room.unsubscribe = unsubscribe
+	m.mutex.Unlock()
+
+	m.announceRoom(room)
+}
+
+// unshareRoom stops relaying clients to the removed room and informs
+// the other instances.
+func (m *RoomManager) unshareRoom(room *room) {
+	if m.backplane == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	unsubscribe := room.unsubscribe
+	room.unsubscribe = nil
+	m.mutex.Unlock()
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+
+	event := roomEvent{Type: roomRemoved, Room: room.record()}
+	if err := m.publishRoomEvent(event); err != nil {
+		slog.Error("Publish room event:", "room-id", room.Id(), "error", err)
+	}
+}
+
+// announceRoom shares the current state of the room with the other instances.
+func (m *RoomManager) announceRoom(room *room) {
+	if m.backplane == nil {
+		return
+	}
+	m.mutex.RLock()
+	_, ok := m.rooms[room.Id()]
+	m.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	event := roomEvent{
+		Type:    roomAnnounced,
+		Room:    room.record(),
+		Clients: room.GetClients(),
+		Roster:  room.Roster(),
+	}
+	if err := m.publishRoomEvent(event); err != nil {
+		slog.Error("Publish room event:", "room-id", room.Id(), "error", err)
+	}
+}
+
+func (m *RoomManager) publishRoomEvent(event roomEvent) error {
+	event.Instance = m.instance
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return m.backplane.Publish(roomsTopic, data)
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/branow/peer-chat/backplane"
+	"github.com/branow/peer-chat/backplane/backplanetest"
+)
+
+// waitForRoom waits until the manager knows the room.
+func waitForRoom(t *testing.T, m *RoomManager, roomId int, check func(RoomInfo) bool) RoomInfo {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		info, err := m.GetRoom(roomId)
+		if err == nil && check(info) {
+			return info
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("room %d not as expected in %v: %+v, %v", roomId, testTimeout, info, err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// testCluster connects a client of each instance to a room owned by
+// the first one.
+func testCluster(t *testing.T, first, second backplane.Backplane) {
+	owner := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), first)
+	other := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), second)
+	roomId := newTestRoom(t, owner, 2)
+	waitForRoom(t, other, roomId, func(RoomInfo) bool { return true })
+
+	a := joinTestRoom(t, owner, roomId, Guest{})
+	a.expect(t, Session)
+	b := joinTestRoom(t, other, roomId, Guest{})
+	b.expect(t, Session)
+
+	// The relayed client signals with the owner's one like a local one.
+	a.expect(t, RequestOffer)
+	b.expect(t, Offer)
+	a.expect(t, Answer)
+	waitForRoom(t, other, roomId, func(info RoomInfo) bool { return info.Clients == 2 })
+
+	b.hangUp()
+	a.expect(t, PeerLeft)
+	waitForRoom(t, other, roomId, func(info RoomInfo) bool { return info.Clients == 1 })
+}
+
+func TestClusterOverMemory(t *testing.T) {
+	b := backplane.NewMemory()
+	testCluster(t, b, b)
+}
+
+func TestClusterDropsSlowRelayedClient(t *testing.T) {
+	b := backplane.NewMemory()
+	owner := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	keepAlive := testKeepAlive
+	keepAlive.WriteTimeout = time.Hour
+	other := NewRoomManager(keepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	roomId := newTestRoom(t, owner, 2)
+	waitForRoom(t, other, roomId, func(RoomInfo) bool { return true })
+
+	alice := joinTestRoom(t, owner, roomId, Guest{})
+	alice.expect(t, Session)
+	bob := joinTestRoom(t, other, roomId, Guest{})
+	bob.expect(t, Session)
+	alice.expect(t, Answer)
+
+	// Bob stops reading, so his connection is dropped once the messages
+	// to him pile up, rather than hold up the backplane.
+	bob.stalled.Store(true)
+	for i := range 300 {
+		alice.send(Message{MessageType: Chat, Data: fmt.Sprintf("message %d", i)})
+	}
+	select {
+	case <-bob.closed:
+	case <-time.After(testTimeout):
+		t.Fatalf("slow relayed client not dropped in %v", testTimeout)
+	}
+	waitForRoom(t, owner, roomId, func(info RoomInfo) bool { return info.Clients == 1 })
+}
+
+func TestClusterOverNats(t *testing.T) {
+	listener := backplanetest.Listen(t)
+	go backplane.NewBroker().Serve(listener)
+
+	dial := func() backplane.Backplane {
+		n, err := backplane.DialNats(listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { n.Close() })
+		return n
+	}
+	testCluster(t, dial(), dial())
+}
+
+func TestClusterLosesBackplane(t *testing.T) {
+	broker := backplanetest.Listen(t)
+	go backplane.NewBroker().Serve(broker)
+
+	dial := func() backplane.Backplane {
+		n, err := backplane.DialNats(broker.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { n.Close() })
+		return n
+	}
+	owner := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), dial())
+	other := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), dial())
+	roomId := newTestRoom(t, owner, 2)
+	waitForRoom(t, other, roomId, func(RoomInfo) bool { return true })
+	local := joinTestRoom(t, owner, roomId, Guest{})
+	local.expect(t, Session)
+	relayed := joinTestRoom(t, other, roomId, Guest{})
+	relayed.expect(t, Session)
+
+	// The room of the owner can no longer be reached, so it is gone and
+	// the relayed client is dropped to resume later.
+	broker.Drop()
+	timeout := time.After(testTimeout)
+	for _, err := other.GetRoom(roomId); !errors.Is(err, ErrRoomDoesNotExist); _, err = other.GetRoom(roomId) {
+		select {
+		case <-timeout:
+			t.Fatalf("room of the lost instance still known: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	select {
+	case <-relayed.closed:
+	case <-time.After(testTimeout):
+		t.Fatal("relayed client not dropped")
+	}
+
+	// Once the backplane is back, the room is shared again.
+	waitForRoom(t, other, roomId, func(RoomInfo) bool { return true })
+}
@@ -0,0 +1,276 @@
+package model
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrNotHost      = errors.New("not the host of the room")
+	ErrRoomLocked   = errors.New("room is locked")
+	ErrBanned       = errors.New("banned from the room")
+	ErrPeerNotFound = errors.New("peer not found")
+)
+
+// Guest describes a client which connects to a room.
+type Guest struct {
+	Token string // Resume token, empty for a new client
+	Addr  string // Remote address, bans are bound to it
+	Host  bool   // Whether the client has proven to be the host of the room
+	Name  string // Display name, shown to the host when the guest knocks
+}
+
+// newHostKey returns a random host key and its hash, which is stored
+// in place of the key.
+func newHostKey() (key, hash string) {
+	key = newToken(24)
+	return key, hashHostKey(key)
+}
+
+func hashHostKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// checkHostKey reports whether the key matches the hash.
+func checkHostKey(hash, key string) bool {
+	if hash == "" || key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashHostKey(key)), []byte(hash)) == 1
+}
+
+// moderation holds what the host of a room has decided about its clients.
+// It lasts as long as the room, the lock and the banned addresses are kept
+// in the record of the room. Sessions do not outlive the server, so neither
+// do the banned session tokens.
+type moderation struct {
+	locked       bool
+	guests       map[*Client]Guest
+	bannedAddrs  map[string]bool
+	bannedTokens map[string]bool
+	mutex        sync.RWMutex
+}
+
+func newModeration(locked bool, bannedAddrs []string) *moderation {
+	m := &moderation{
+		locked:       locked,
+		guests:       map[*Client]Guest{},
+		bannedAddrs:  map[string]bool{},
+		bannedTokens: map[string]bool{},
+	}
+	for _, addr := range bannedAddrs {
+		m.bannedAddrs[addr] = true
+	}
+	return m
+}
+
+// admit checks whether the guest may connect to the room and remembers
+// who the client is. The host is admitted to a locked room and is
+// never banned.
+func (m *moderation) admit(client *Client, guest Guest, resume bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !guest.Host {
+		if m.bannedAddrs[guest.Addr] || (resume && m.bannedTokens[guest.Token]) {
+			return ErrBanned
+		}
+		if m.locked && !resume {
+			return ErrRoomLocked
+		}
+	}
+	if client != nil {
+		m.guests[client] = guest
+	}
+	return nil
+}
+
+func (m *moderation) isHost(client *Client) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.guests[client].Host
+}
+
+func (m *moderation) isLocked() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.locked
+}
+
+func (m *moderation) setLocked(locked bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.locked = locked
+}
+
+// banned returns the banned addresses in order.
+func (m *moderation) banned() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	addrs := []string{}
+	for addr := range m.bannedAddrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// ban keeps the client from coming back by its address and session.
+func (m *moderation) ban(peer *Peer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if addr := m.guests[peer.Client].Addr; addr != "" {
+		m.bannedAddrs[addr] = true
+	}
+	m.bannedTokens[peer.Token()] = true
+}
+
+// prune forgets the clients which have been closed.
+func (m *moderation) prune() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for client := range m.guests {
+		if client.IsClosed() {
+			delete(m.guests, client)
+		}
+	}
+}
+
+// CheckHost checks that the key is the host key of the room.
+func (m *RoomManager) CheckHost(roomId int, hostKey string) error {
+	m.mutex.RLock()
+	var hash string
+	if room, ok := m.rooms[roomId]; ok {
+		hash = room.hostKeyHash
+	} else if room, ok := m.remoteRooms[roomId]; ok {
+		hash = room.record.HostKeyHash
+	} else {
+		m.mutex.RUnlock()
+		return ErrRoomDoesNotExist
+	}
+	m.mutex.RUnlock()
+
+	if !checkHostKey(hash, hostKey) {
+		return ErrNotHost
+	}
+	return nil
+}
+
+// KickClient closes the client with the given peer id. A banned client
+// may not come back while the room lives. If another instance owns
+// the room, the request is passed to it without waiting for the result,
+// so an unknown peer id is not reported.
+func (m *RoomManager) KickClient(roomId int, peerId int, ban bool) error {
+	control := Message{MessageType: Kick, Peer: peerId}
+	if ban {
+		control.MessageType = Ban
+	}
+	return m.control(roomId, control)
+}
+
+// LockRoom stops or resumes admitting new clients to the room. Clients
+// of the room may still resume their connections. If another instance
+// owns the room, the request is passed to it.
+func (m *RoomManager) LockRoom(roomId int, locked bool) error {
+	return m.control(roomId, Message{MessageType: Lock, Data: strconv.FormatBool(locked)})
+}
+
+// AnswerKnock admits the client which knocked on the room to it or denies
+// it. If another instance owns the room, the request is passed to it
+// without waiting for the result, so an unknown knock id is not reported.
+func (m *RoomManager) AnswerKnock(roomId int, knockId int, admit bool) error {
+	control := Message{MessageType: Deny, Peer: knockId}
+	if admit {
+		control.MessageType = Admit
+	}
+	return m.control(roomId, control)
+}
+
+// control applies the control message to the room. A room of another
+// instance is sent the message over the backplane, the owner only logs
+// whether it could apply it, so the caller learns of local errors only.
+func (m *RoomManager) control(roomId int, control Message) error {
+	m.mutex.RLock()
+	room, local := m.rooms[roomId]
+	_, remote := m.remoteRooms[roomId]
+	m.mutex.RUnlock()
+
+	switch {
+	case local:
+		return m.applyControl(room, control)
+	case remote:
+		data, err := json.Marshal(control)
+		if err != nil {
+			return err
+		}
+		return publishEnvelope(m.backplane, roomTopic(roomId), relayEnvelope{Type: relayControl, Data: data})
+	default:
+		return ErrRoomDoesNotExist
+	}
+}
+
+// handleControl handles a control message which a client of the room
+// has sent, only the host may control the room.
+func (m *RoomManager) handleControl(room *room, from *Peer, control Message) {
+	if !room.moderation.isHost(from.Client) {
+		slog.Info("Control by a guest:", "room-id", room.Id(), "client", from.Id(),
+			"type", control.MessageType)
+		errorMessage := Message{MessageType: Error, Data: ErrNotHost.Error()}
+		_ = from.SendMessage(errorMessage)
+		return
+	}
+
+	if err := m.applyControl(room, control); err != nil {
+		errorMessage := Message{MessageType: Error, Data: err.Error()}
+		_ = from.SendMessage(errorMessage)
+	}
+}
+
+// applyControl carries out a control message in the room owned by this instance.
+func (m *RoomManager) applyControl(room *room, control Message) error {
+	switch control.MessageType {
+	case Kick, Ban:
+		peer, ok := room.FindPeer(control.Peer)
+		if !ok {
+			return ErrPeerNotFound
+		}
+		kicked := Message{MessageType: Kicked}
+		if control.MessageType == Ban {
+			room.moderation.ban(peer)
+			m.saveRoom(room)
+			kicked.Data = "ban"
+		}
+		_ = peer.SendMessage(kicked)
+		peer.Close()
+		slog.Info("Kicked client:", "room-id", room.Id(), "client", peer.Id(),
+			"ban", control.MessageType == Ban)
+	case Lock:
+		locked := control.Data == "true"
+		room.moderation.setLocked(locked)
+		m.saveRoom(room)
+		room.Broadcast(Message{MessageType: Locked, Data: strconv.FormatBool(locked)})
+		m.announceRoom(room)
+		slog.Info("Locked room:", "room-id", room.Id(), "locked", locked)
+	case Admit:
+		return m.admitKnock(room, control.Peer)
+	case Deny:
+		return m.denyKnock(room, control.Peer)
+	case inviteAdd, inviteRevoke, inviteRotate, inviteRedeem:
+		return m.applyInvites(room, control)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/branow/peer-chat/validation"
+)
+
+var (
+	ErrInvalidInvite  = errors.New("invalid invite code")
+	ErrInviteNotFound = errors.New("invite not found")
+)
+
+// Control messages which change the invites of a room. Clients never send
+// them, they come from the HTTP handlers of the host.
+const (
+	inviteAdd    = "invite-add"
+	inviteRevoke = "invite-revoke"
+	inviteRotate = "invite-rotate"
+	inviteRedeem = "invite-redeem"
+)
+
+// Limits of an invite.
+const (
+	MaxInviteUses = 1000
+	MinInviteTTL  = 5 * time.Minute
+	MaxInviteTTL  = 30 * 24 * time.Hour
+)
+
+// Invite lets guests into a private room, which is not entered by its id
+// alone. An invite may be limited to a number of uses and expire.
+type Invite struct {
+	Code       string    `json:"code"`
+	MaxUses    int       `json:"maxUses,omitempty"` // Zero if the uses are not limited
+	Uses       int       `json:"uses,omitempty"`
+	ExpiryTime time.Time `json:"expiryTime"` // Zero if the invite never expires
+}
+
+// valid reports whether the invite may be used at the given time.
+func (i Invite) valid(t time.Time) bool {
+	if i.MaxUses > 0 && i.Uses >= i.MaxUses {
+		return false
+	}
+	return i.ExpiryTime.IsZero() || t.Before(i.ExpiryTime)
+}
+
+// InviteDTO represents data required to create an invite. Zero values
+// leave the invite unlimited.
+type InviteDTO struct {
+	maxUses int
+	ttl     time.Duration
+}
+
+func NewInviteDTO(maxUses int, ttl time.Duration) *InviteDTO {
+	return &InviteDTO{maxUses: maxUses, ttl: ttl}
+}
+
+func (i InviteDTO) Validate() error {
+	err := validation.Validate(i.maxUses, "invite uses", validation.InRange(0, MaxInviteUses))
+	if err != nil {
+		return err
+	}
+	if i.ttl != 0 {
+		err = validation.Validate(i.ttl, "invite duration", validation.InRange(MinInviteTTL, MaxInviteTTL))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newInvite(dto InviteDTO) Invite {
+	invite := Invite{Code: newToken(16), MaxUses: dto.maxUses}
+	if dto.ttl > 0 {
+		invite.ExpiryTime = time.Now().Add(dto.ttl)
+	}
+	return invite
+}
+
+// invites holds the invites of a room, used ones are dropped. Revoking
+// invites starts their next generation, which ends the passes given for
+// the invites of the ones before.
+type invites struct {
+	list       []Invite
+	generation int
+	mutex      sync.Mutex
+}
+
+func newInvites(list []Invite, generation int) *invites {
+	return &invites{list: append([]Invite{}, list...), generation: generation}
+}
+
+// all returns the invites which may still be used.
+func (i *invites) all() []Invite {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.prune()
+	return append([]Invite{}, i.list...)
+}
+
+func (i *invites) currentGeneration() int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.generation
+}
+
+func (i *invites) add(invite Invite) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.list = append(i.list, invite)
+}
+
+func (i *invites) revoke(code string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for n, invite := range i.list {
+		if invite.Code == code {
+			i.list = append(i.list[:n], i.list[n+1:]...)
+			i.generation++
+			return nil
+		}
+	}
+	return ErrInviteNotFound
+}
+
+// replace revokes every invite in favour of the given one.
+func (i *invites) replace(invite Invite) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.list = []Invite{invite}
+	i.generation++
+}
+
+// redeem counts a use of the invite with the code, if it is valid.
+func (i *invites) redeem(code string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.prune()
+	for n := range i.list {
+		if i.list[n].Code == code {
+			i.list[n].Uses++
+			return nil
+		}
+	}
+	return ErrInvalidInvite
+}
+
+// apply carries out the invite control message.
+func (i *invites) apply(messageType string, invite Invite) error {
+	switch messageType {
+	case inviteAdd:
+		i.add(invite)
+	case inviteRevoke:
+		return i.revoke(invite.Code)
+	case inviteRotate:
+		i.replace(invite)
+	case inviteRedeem:
+		return i.redeem(invite.Code)
+	}
+	return nil
+}
+
+func (i *invites) prune() {
+	now := time.Now()
+	list := i.list[:0]
+	for _, invite := range i.list {
+		if invite.valid(now) {
+			list = append(list, invite)
+		}
+	}
+	i.list = list
+}
+
+// Invites returns the invites of the room which may still be used.
+func (m *RoomManager) Invites(roomId int) ([]Invite, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if room, ok := m.rooms[roomId]; ok {
+		return room.invites.all(), nil
+	}
+	if room, ok := m.remoteRooms[roomId]; ok {
+		return newInvites(room.record.Invites, room.record.InviteGen).all(), nil
+	}
+	return nil, ErrRoomDoesNotExist
+}
+
+// CreateInvite adds a new invite to the room and returns it.
+func (m *RoomManager) CreateInvite(roomId int, dto InviteDTO) (Invite, error) {
+	invite := newInvite(dto)
+	return invite, m.controlInvites(roomId, inviteAdd, invite)
+}
+
+// RevokeInvite revokes the invite of the room with the given code. It
+// ends the passes given for every invite of the room, the guests whose
+// invites are still valid redeem them again.
+func (m *RoomManager) RevokeInvite(roomId int, code string) error {
+	return m.controlInvites(roomId, inviteRevoke, Invite{Code: code})
+}
+
+// RotateInvites revokes every invite of the room and returns a new one,
+// which is not limited. It ends the passes given for the revoked invites.
+func (m *RoomManager) RotateInvites(roomId int) (Invite, error) {
+	invite := newInvite(InviteDTO{})
+	return invite, m.controlInvites(roomId, inviteRotate, invite)
+}
+
+// RedeemInvite uses the invite of the room with the given code. The owner
+// of the room counts the uses, so another instance checks the invite
+// against the last state of the room it knows and passes the use on.
+func (m *RoomManager) RedeemInvite(roomId int, code string) error {
+	m.mutex.RLock()
+	_, local := m.rooms[roomId]
+	remote, isRemote := m.remoteRooms[roomId]
+	m.mutex.RUnlock()
+
+	if !local && isRemote {
+		if err := newInvites(remote.record.Invites, remote.record.InviteGen).redeem(code); err != nil {
+			return err
+		}
+	}
+	return m.controlInvites(roomId, inviteRedeem, Invite{Code: code})
+}
+
+// controlInvites changes the invites of the room, in the owner of
+// the room directly or through the backplane.
+func (m *RoomManager) controlInvites(roomId int, messageType string, invite Invite) error {
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return err
+	}
+	if err := m.control(roomId, Message{MessageType: messageType, Data: string(data)}); err != nil {
+		return err
+	}
+
+	// Until the owner announces the changed room, the invites known of it
+	// are changed in place, so the host sees the change right away.
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if remote, ok := m.remoteRooms[roomId]; ok {
+		invites := newInvites(remote.record.Invites, remote.record.InviteGen)
+		_ = invites.apply(messageType, invite)
+		remote.record.Invites = invites.all()
+		remote.record.InviteGen = invites.currentGeneration()
+		m.remoteRooms[roomId] = remote
+	}
+	return nil
+}
+
+// applyInvites carries out an invite control message in the room owned
+// by this instance and stores the room.
+func (m *RoomManager) applyInvites(room *room, control Message) error {
+	var invite Invite
+	if err := json.Unmarshal([]byte(control.Data), &invite); err != nil {
+		return err
+	}
+
+	if err := room.invites.apply(control.MessageType, invite); err != nil {
+		return err
+	}
+
+	m.saveRoom(room)
+	m.announceRoom(room)
+	slog.Debug("Changed invites:", "room-id", room.Id(), "type", control.MessageType)
+	return nil
+}
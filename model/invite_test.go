@@ -0,0 +1,117 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/branow/peer-chat/backplane"
+)
+
+// waitForInvites waits until the manager knows the invites of the room
+// as expected.
+func waitForInvites(t *testing.T, m *RoomManager, roomId int, check func([]Invite) bool) {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		invites, err := m.Invites(roomId)
+		if err == nil && check(invites) {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("invites of room %d not as expected in %v: %+v, %v", roomId, testTimeout, invites, err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestInvites(t *testing.T) {
+	b := backplane.NewMemory()
+	owner := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	other := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	roomId, _, err := owner.CreateRoom(*NewRoomDTO("private room", private, 2, ""))
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	if info := waitForRoom(t, other, roomId, func(RoomInfo) bool { return true }); !info.Private {
+		t.Error("room not known as private by the other instance")
+	}
+	first, err := owner.Invites(roomId)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("invites of a new private room: got %+v, %v, want one", first, err)
+	}
+	if err := owner.RedeemInvite(roomId, "unknown"); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("redeem unknown code: got %v, want %v", err, ErrInvalidInvite)
+	}
+
+	// The uses are counted by the owner, wherever the invite is redeemed.
+	invite, err := owner.CreateInvite(roomId, *NewInviteDTO(2, 0))
+	if err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	waitForInvites(t, other, roomId, func(invites []Invite) bool { return len(invites) == 2 })
+	if err := other.RedeemInvite(roomId, invite.Code); err != nil {
+		t.Fatalf("redeem on the other instance: %v", err)
+	}
+	waitForInvites(t, owner, roomId, func(invites []Invite) bool { return invites[1].Uses == 1 })
+	if err := owner.RedeemInvite(roomId, invite.Code); err != nil {
+		t.Fatalf("redeem on the owner: %v", err)
+	}
+	if err := owner.RedeemInvite(roomId, invite.Code); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("redeem used up invite: got %v, want %v", err, ErrInvalidInvite)
+	}
+
+	// Revoking the invites starts their next generation everywhere.
+	if err := other.RevokeInvite(roomId, first[0].Code); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	waitForRoom(t, owner, roomId, func(info RoomInfo) bool { return info.InviteGen == 1 })
+	if err := owner.RevokeInvite(roomId, first[0].Code); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("revoke twice: got %v, want %v", err, ErrInviteNotFound)
+	}
+	rotated, err := owner.RotateInvites(roomId)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	waitForRoom(t, other, roomId, func(info RoomInfo) bool { return info.InviteGen == 2 })
+	waitForInvites(t, other, roomId, func(invites []Invite) bool {
+		return len(invites) == 1 && invites[0].Code == rotated.Code
+	})
+}
+
+func TestInviteValidate(t *testing.T) {
+	for _, dto := range []*InviteDTO{
+		NewInviteDTO(-1, 0),
+		NewInviteDTO(MaxInviteUses+1, 0),
+		NewInviteDTO(0, MinInviteTTL-time.Second),
+		NewInviteDTO(0, MaxInviteTTL+time.Second),
+	} {
+		if err := dto.Validate(); err == nil {
+			t.Errorf("invite %+v passed validation", *dto)
+		}
+	}
+	if err := NewInviteDTO(10, time.Hour).Validate(); err != nil {
+		t.Errorf("valid invite: %v", err)
+	}
+}
+
+func TestInvitePass(t *testing.T) {
+	passes := NewJoinTickets([]byte("key"), time.Hour)
+	pass := passes.IssueFor(7, 1)
+
+	if err := passes.VerifyFor(pass, 7, 1); err != nil {
+		t.Errorf("verify pass: %v", err)
+	}
+	for _, check := range []struct {
+		roomId, generation int
+	}{{7, 2}, {8, 1}, {71, 1}} {
+		if err := passes.VerifyFor(pass, check.roomId, check.generation); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("verify pass for room %d in generation %d: got %v, want %v",
+				check.roomId, check.generation, err, ErrInvalidTicket)
+		}
+	}
+	if err := passes.Verify(pass, 7); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("verify pass as a ticket: got %v, want %v", err, ErrInvalidTicket)
+	}
+}
@@ -0,0 +1,64 @@
+package model
+
+import "sync"
+
+// Lifecycle events of rooms, which the manager emits to its subscribers
+// for the rooms of every instance.
+const (
+	LifecycleCreated   = "created"   // A room was created or has become known
+	LifecycleRemoved   = "removed"   // A room was removed
+	LifecycleOccupancy = "occupancy" // The number of clients of a room has changed
+)
+
+// LifecycleEvent tells about a change of a room.
+type LifecycleEvent struct {
+	Type string
+	Room RoomInfo
+}
+
+// lifecycle holds the subscribers to the lifecycle events.
+type lifecycle struct {
+	handlers map[int]func(LifecycleEvent)
+	lastId   int
+	mutex    sync.RWMutex
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{handlers: map[int]func(LifecycleEvent){}}
+}
+
+func (l *lifecycle) subscribe(handle func(LifecycleEvent)) func() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.lastId++
+	id := l.lastId
+	l.handlers[id] = handle
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		delete(l.handlers, id)
+	}
+}
+
+func (l *lifecycle) emit(event LifecycleEvent) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	for _, handle := range l.handlers {
+		handle(event)
+	}
+}
+
+// SubscribeLifecycle calls the handler on every lifecycle event of
+// the rooms until the returned function unsubscribes it. The handler
+// is called on the path which changes the room, so it must not block.
+func (m *RoomManager) SubscribeLifecycle(handle func(LifecycleEvent)) (unsubscribe func()) {
+	return m.lifecycle.subscribe(handle)
+}
+
+// emitLifecycle tells the subscribers about the change of the room.
+// The manager must not be locked.
+func (m *RoomManager) emitLifecycle(eventType string, room RoomInfo) {
+	m.lifecycle.emit(LifecycleEvent{Type: eventType, Room: room})
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/branow/peer-chat/backplane"
+)
+
+// watchLifecycle returns the channel of the lifecycle events of the manager.
+func watchLifecycle(t *testing.T, m *RoomManager) <-chan LifecycleEvent {
+	events := make(chan LifecycleEvent, 100)
+	t.Cleanup(m.SubscribeLifecycle(func(event LifecycleEvent) { events <- event }))
+	return events
+}
+
+// expectLifecycle waits for the next event of the given type about the room,
+// skipping the other events.
+func expectLifecycle(t *testing.T, events <-chan LifecycleEvent, eventType string, roomId int) LifecycleEvent {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == eventType && event.Room.Id == roomId {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %q event of room %d in %v", eventType, roomId, testTimeout)
+			return LifecycleEvent{}
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	b := backplane.NewMemory()
+	owner := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	other := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	ownerEvents := watchLifecycle(t, owner)
+	otherEvents := watchLifecycle(t, other)
+
+	// Both instances tell about the room, whichever of them owns it.
+	roomId := newTestRoom(t, owner, 2)
+	for _, events := range []<-chan LifecycleEvent{ownerEvents, otherEvents} {
+		if got := expectLifecycle(t, events, LifecycleCreated, roomId); got.Room.Name != "test room" {
+			t.Errorf("created room: got %q, want %q", got.Room.Name, "test room")
+		}
+	}
+
+	guest := joinTestRoom(t, other, roomId, Guest{})
+	guest.expect(t, Session)
+	for _, events := range []<-chan LifecycleEvent{ownerEvents, otherEvents} {
+		if got := expectLifecycle(t, events, LifecycleOccupancy, roomId); got.Room.Clients != 1 {
+			t.Errorf("clients of the joined room: got %d, want 1", got.Room.Clients)
+		}
+	}
+
+	// The room empties and is removed.
+	guest.hangUp()
+	expectLifecycle(t, ownerEvents, LifecycleRemoved, roomId)
+	expectLifecycle(t, otherEvents, LifecycleRemoved, roomId)
+}
+
+func TestLifecycleUnsubscribe(t *testing.T) {
+	m := newTestManager()
+	events := make(chan LifecycleEvent, 100)
+	unsubscribe := m.SubscribeLifecycle(func(event LifecycleEvent) { events <- event })
+
+	roomId := newTestRoom(t, m, 2)
+	expectLifecycle(t, events, LifecycleCreated, roomId)
+	unsubscribe()
+	if _, _, err := m.CreateRoom(*NewRoomDTO("other room", public, 2, "")); err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("got %q event after unsubscribing", event.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
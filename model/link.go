@@ -0,0 +1,256 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Roles of the sides of a link in "perfect negotiation". When both sides
+// send offers at the same moment, the impolite peer ignores the incoming
+// offer while the polite one rolls its own back and answers.
+const (
+	Polite   = "polite"
+	Impolite = "impolite"
+)
+
+// link is a peer-to-peer connection between two active peers of a room.
+// The offerer is always the peer which became active earlier, it is
+// impolite during renegotiation while the answerer is polite.
+type link struct {
+	offerer  *Peer
+	answerer *Peer
+	timeouts SignalTimeouts
+	ctx      context.Context // Is done when the link is closed
+	cancel   context.CancelFunc
+	signaled chan struct{} // Is closed when the offer/answer exchange ends
+
+	// ICE candidates cannot be applied before the remote description,
+	// so they are held back until the addressee receives one.
+	mutex      sync.Mutex
+	described  map[*Peer]bool
+	candidates map[*Peer][]Message
+}
+
+func newLink(offerer, answerer *Peer, timeouts SignalTimeouts) *link {
+	offerer.openMailbox(answerer.PeerId())
+	answerer.openMailbox(offerer.PeerId())
+	ctx, cancel := context.WithCancel(context.Background())
+	return &link{
+		offerer:    offerer,
+		answerer:   answerer,
+		timeouts:   timeouts,
+		ctx:        ctx,
+		cancel:     cancel,
+		signaled:   make(chan struct{}),
+		described:  map[*Peer]bool{},
+		candidates: map[*Peer][]Message{},
+	}
+}
+
+// has reports whether the peer is one of the sides of the link.
+func (l *link) has(peer *Peer) bool {
+	return l.offerer == peer || l.answerer == peer
+}
+
+// other returns the opposite side of the link to the given peer.
+func (l *link) other(peer *Peer) *Peer {
+	if l.offerer == peer {
+		return l.answerer
+	}
+	return l.offerer
+}
+
+// signal runs the offer/answer exchange between both sides of the link.
+// Every step has its own deadline, a side which misses it is reported
+// with DeadlineError.
+func (l *link) signal() error {
+	defer close(l.signaled)
+
+	offerer, answerer := l.offerer, l.answerer
+	slog.Debug("Starting signaling:", "offerer", offerer.Id(), "answerer", answerer.Id())
+
+	if err := offerer.SendMessageContext(l.ctx, l.roleMessage(offerer)); err != nil {
+		return err
+	}
+	if err := answerer.SendMessageContext(l.ctx, l.roleMessage(answerer)); err != nil {
+		return err
+	}
+
+	offer, err := l.step(offerer, Offer, l.timeouts.Offer, func(ctx context.Context) error {
+		requestOffer := RequestOfferMessage
+		requestOffer.Peer = answerer.PeerId()
+		return offerer.SendMessageContext(ctx, requestOffer)
+	})
+	if err != nil {
+		return err
+	}
+
+	answer, err := l.step(answerer, Answer, l.timeouts.Answer, func(ctx context.Context) error {
+		return l.sendDescription(ctx, offerer, offer)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(l.ctx, l.timeouts.Answer)
+	defer cancel()
+	if err := l.sendDescription(ctx, answerer, answer); err != nil {
+		return err
+	}
+
+	slog.Debug("Finished signaling:", "offerer", offerer.Id(), "answerer", answerer.Id())
+	return nil
+}
+
+// step runs a single signaling step: it sends a message by the given
+// function and waits for the given peer to reply with the expected type.
+func (l *link) step(
+	peer *Peer, expectedType MessageType, timeout time.Duration,
+	send func(context.Context) error,
+) (Message, error) {
+	ctx, cancel := context.WithTimeout(l.ctx, timeout)
+	defer cancel()
+
+	if err := send(ctx); err != nil {
+		return Message{}, err
+	}
+	message, err := peer.ReceiveExpectedContext(ctx, l.other(peer).PeerId(), expectedType)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return message, DeadlineError{Peer: peer, ExpectedType: expectedType}
+	}
+	return message, err
+}
+
+// negotiate relays offer/answer rounds which either side starts after
+// the initial signaling, e.g. to add a screen-share track. It returns
+// when the link is closed.
+func (l *link) negotiate() error {
+	offererMailbox, ok := l.offerer.mailbox(l.answerer.PeerId())
+	if !ok {
+		return ErrClientIsClosed
+	}
+	answererMailbox, ok := l.answerer.mailbox(l.offerer.PeerId())
+	if !ok {
+		return ErrClientIsClosed
+	}
+
+	// The side whose offer waits for an answer, nil while the link is stable.
+	var pending *Peer
+	var deadline <-chan time.Time
+	for {
+		var from *Peer
+		var message Message
+		select {
+		case message, ok = <-offererMailbox:
+			from = l.offerer
+		case message, ok = <-answererMailbox:
+			from = l.answerer
+		case <-deadline:
+			return DeadlineError{Peer: l.other(pending), ExpectedType: Answer}
+		case <-l.ctx.Done():
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+
+		switch message.MessageType {
+		case Offer:
+			// On glare the offer of the impolite side wins: the polite side
+			// rolls back its own offer, so it is not waiting for an answer.
+			if pending != nil && pending != from && from == l.politePeer() {
+				slog.Debug("Ignoring colliding offer:", "client", from.Id())
+				continue
+			}
+			pending = from
+			deadline = time.After(l.timeouts.Answer)
+		case Answer:
+			if pending == nil || pending == from {
+				slog.Debug("Ignoring unexpected answer:", "client", from.Id())
+				continue
+			}
+			pending = nil
+			deadline = nil
+		default:
+			slog.Debug("Ignoring unexpected message:", "client", from.Id(),
+				"type", message.MessageType)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(l.ctx, l.timeouts.Answer)
+		err := l.sendDescription(ctx, from, message)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// roleMessage informs the given side of the link about its negotiation role.
+func (l *link) roleMessage(peer *Peer) Message {
+	role := Impolite
+	if peer == l.politePeer() {
+		role = Polite
+	}
+	return Message{MessageType: Role, Data: role, Peer: l.other(peer).PeerId()}
+}
+
+func (l *link) politePeer() *Peer {
+	return l.answerer
+}
+
+// sendDescription sends an offer or an answer of the given peer to the other
+// side of the link, followed by the ICE candidates which were waiting for it.
+func (l *link) sendDescription(ctx context.Context, from *Peer, description Message) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	to := l.other(from)
+	description.Peer = from.PeerId()
+	if err := to.SendMessageContext(ctx, description); err != nil {
+		return err
+	}
+	for _, candidate := range l.candidates[to] {
+		if err := to.SendMessageContext(ctx, candidate); err != nil {
+			return err
+		}
+	}
+	l.described[to] = true
+	delete(l.candidates, to)
+	return nil
+}
+
+// sendCandidate sends an ICE candidate of the given peer to the other side
+// of the link, or holds it back until the addressee receives the remote
+// description. It is called on the path which reads the messages of
+// the sender, so a slow addressee does not hold it up.
+func (l *link) sendCandidate(from *Peer, candidate Message) error {
+	to := l.other(from)
+	candidate.Peer = from.PeerId()
+
+	l.mutex.Lock()
+	if !l.described[to] {
+		l.candidates[to] = append(l.candidates[to], candidate)
+		l.mutex.Unlock()
+		return nil
+	}
+	l.mutex.Unlock()
+	return to.notify(candidate)
+}
+
+// close stops the signaling of the link and informs the remaining side
+// that the given peer has left.
+func (l *link) close(left *Peer) {
+	l.cancel()
+	l.offerer.closeMailbox(l.answerer.PeerId())
+	l.answerer.closeMailbox(l.offerer.PeerId())
+
+	remaining := l.other(left)
+	message := Message{MessageType: PeerLeft, Peer: left.PeerId()}
+	if err := remaining.SendMessage(message); err != nil && !errors.Is(err, ErrClientIsClosed) {
+		slog.Error("Sending peer left message:", "client", remaining.Id(), "error", err)
+	}
+}
@@ -0,0 +1,170 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// newTestPair links two clients over memory transports, the first one
+// is the offerer.
+func newTestPair(tb testing.TB, timeouts SignalTimeouts) (offerer, answerer *memoryTransport) {
+	offerer, answerer = newMemoryTransport(), newMemoryTransport()
+	linkTestPair(tb, timeouts, offerer, answerer)
+	return offerer, answerer
+}
+
+// linkTestPair links the clients of the given transports, the first one
+// is the offerer.
+func linkTestPair(tb testing.TB, timeouts SignalTimeouts, offerer, answerer *memoryTransport) {
+	tb.Helper()
+	pc := NewPeerConnection(2, timeouts)
+	tb.Cleanup(pc.Close)
+	tb.Cleanup(func() { _ = offerer.Close() })
+	tb.Cleanup(func() { _ = answerer.Close() })
+	pc.AddClient(NewClient(offerer, testKeepAlive), "")
+	pc.AddClient(NewClient(answerer, testKeepAlive), "")
+}
+
+// expectDescription waits for the next offer or answer and checks it.
+func expectDescription(tb testing.TB, transport *memoryTransport, messageType MessageType, sdp string) Message {
+	tb.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case message := <-transport.received:
+			if message.MessageType != Offer && message.MessageType != Answer {
+				continue
+			}
+			if message.MessageType != string(messageType) || message.SDP != sdp {
+				tb.Fatalf("description: got %s %q, want %s %q",
+					message.MessageType, message.SDP, messageType, sdp)
+			}
+			return message
+		case <-timeout:
+			tb.Fatalf("no %s %q in %v", messageType, sdp, testTimeout)
+			return Message{}
+		}
+	}
+}
+
+func TestLinkHoldsCandidatesUntilDescription(t *testing.T) {
+	a, b := newTestPair(t, DefaultSignalTimeouts)
+
+	// The candidate may come before the answerer has the offer, then it
+	// is held back until the offer is sent.
+	role := a.expect(t, Role)
+	candidate := json.RawMessage(`{"candidate":"early"}`)
+	a.send(Message{MessageType: IceCandidate, Peer: role.Peer, Candidate: candidate})
+
+	described := false
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case message := <-b.received:
+			switch message.MessageType {
+			case Offer:
+				described = true
+			case IceCandidate:
+				if !described {
+					t.Fatal("candidate sent before the offer")
+				}
+				if string(message.Candidate) != string(candidate) {
+					t.Errorf("candidate: got %s, want %s", message.Candidate, candidate)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no candidate in %v", testTimeout)
+		}
+	}
+}
+
+func TestLinkRelaysEndOfCandidates(t *testing.T) {
+	a, b := newTestPair(t, DefaultSignalTimeouts)
+	request := a.expect(t, RequestOffer)
+	author := b.expect(t, Role).Peer
+	b.expect(t, Offer)
+	a.expect(t, Answer)
+
+	// Candidates for a peer which is not linked are dropped.
+	a.send(Message{MessageType: IceCandidate, Peer: 42, Candidate: json.RawMessage(`{"candidate":"lost"}`)})
+	a.send(Message{MessageType: IceCandidate, Peer: request.Peer, Candidate: json.RawMessage(`null`)})
+	got := b.expect(t, IceCandidate)
+	if string(got.Candidate) != "null" {
+		t.Errorf("end of candidates: got %s, want null", got.Candidate)
+	}
+	if got.Peer != author {
+		t.Errorf("peer of the candidate: got %d, want the author %d", got.Peer, author)
+	}
+}
+
+func TestLinkRenegotiation(t *testing.T) {
+	a, b := newTestPair(t, DefaultSignalTimeouts)
+	if got := a.expect(t, Role); got.Data != Impolite {
+		t.Errorf("role of the offerer: got %q, want %q", got.Data, Impolite)
+	}
+	role := b.expect(t, Role)
+	if role.Data != Polite {
+		t.Errorf("role of the answerer: got %q, want %q", role.Data, Polite)
+	}
+	expectDescription(t, b, Offer, "offer")
+	expectDescription(t, a, Answer, "answer")
+
+	// Either side may start a new round, e.g. to share its screen.
+	b.send(Message{MessageType: Offer, SDP: "screen", Peer: role.Peer})
+	expectDescription(t, a, Offer, "screen")
+	expectDescription(t, b, Answer, "answer")
+}
+
+func TestLinkGlare(t *testing.T) {
+	a, b := newTestPair(t, DefaultSignalTimeouts)
+	toB := a.expect(t, RequestOffer).Peer
+	toA := b.expect(t, Role).Peer
+	expectDescription(t, b, Offer, "offer")
+	expectDescription(t, a, Answer, "answer")
+	a.silent.Store(true)
+	b.silent.Store(true)
+
+	// The offers collide, the one of the impolite side wins and the polite
+	// side rolls its own back and answers.
+	a.send(Message{MessageType: Offer, SDP: "impolite", Peer: toB})
+	expectDescription(t, b, Offer, "impolite")
+	b.send(Message{MessageType: Offer, SDP: "polite", Peer: toA})
+	b.send(Message{MessageType: Answer, SDP: "rollback", Peer: toA})
+	expectDescription(t, a, Answer, "rollback")
+}
+
+// testSignalTimeouts lets the tests see a deadline missed quickly.
+var testSignalTimeouts = SignalTimeouts{Offer: 100 * time.Millisecond, Answer: 100 * time.Millisecond}
+
+func TestSignalingEvictsSilentPeer(t *testing.T) {
+	a, b := newMemoryTransport(), newMemoryTransport()
+	a.silent.Store(true)
+	linkTestPair(t, testSignalTimeouts, a, b)
+
+	// The offerer never offers, so it is evicted and the answerer is told.
+	a.expect(t, RequestOffer)
+	if got := a.expect(t, Error); got.Data != (DeadlineError{ExpectedType: Offer}).Error() {
+		t.Errorf("error: got %q", got.Data)
+	}
+	a.expectShutdown(t)
+	b.expect(t, PeerLeft)
+}
+
+func TestNegotiationEvictsSilentPeer(t *testing.T) {
+	a, b := newTestPair(t, testSignalTimeouts)
+	toB := a.expect(t, RequestOffer).Peer
+	expectDescription(t, b, Offer, "offer")
+	expectDescription(t, a, Answer, "answer")
+
+	// A new round which is never answered ends the link.
+	b.silent.Store(true)
+	a.send(Message{MessageType: Offer, SDP: "restart", Peer: toB})
+	expectDescription(t, b, Offer, "restart")
+	if got := b.expect(t, Error); got.Data != (DeadlineError{ExpectedType: Answer}).Error() {
+		t.Errorf("error: got %q", got.Data)
+	}
+	b.expectShutdown(t)
+	a.expect(t, PeerLeft)
+}
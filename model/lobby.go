@@ -0,0 +1,184 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+var (
+	WaitForHostMessage = Message{
+		MessageType: Wait, Data: "Wait for host",
+	}
+	DeniedMessage = Message{MessageType: Denied}
+)
+
+// lobby keeps the clients which knocked on a room in lobby mode until
+// the host admits or denies them. Knocks are identified by their own
+// ids, which are not peer ids.
+type lobby struct {
+	knocks map[int]knock
+	lastId int
+	mutex  sync.Mutex
+}
+
+type knock struct {
+	id      int
+	client  *Client
+	guest   Guest
+	cancel  context.CancelFunc
+	drained chan struct{}
+}
+
+func newLobby() *lobby {
+	return &lobby{knocks: map[int]knock{}}
+}
+
+func (l *lobby) add(client *Client, guest Guest) knock {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.lastId++
+	ctx, cancel := context.WithCancel(context.Background())
+	k := knock{id: l.lastId, client: client, guest: guest, cancel: cancel, drained: make(chan struct{})}
+	l.knocks[k.id] = k
+	go k.discard(ctx)
+	return k
+}
+
+// take removes the knock with the given id and reports whether it was there.
+func (l *lobby) take(id int) (knock, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	k, ok := l.knocks[id]
+	delete(l.knocks, id)
+	return k, ok
+}
+
+// all returns the knocks in the order they came.
+func (l *lobby) all() []knock {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	knocks := make([]knock, 0, len(l.knocks))
+	for _, k := range l.knocks {
+		knocks = append(knocks, k)
+	}
+	sort.Slice(knocks, func(i, j int) bool { return knocks[i].id < knocks[j].id })
+	return knocks
+}
+
+func (l *lobby) size() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.knocks)
+}
+
+// discard reads and drops the messages of the client until it is closed
+// or admitted, so a client sending while it waits cannot fill its input
+// and stall its own connection.
+func (k knock) discard(ctx context.Context) {
+	defer close(k.drained)
+	for {
+		if _, err := k.client.ReceiveContext(ctx); err != nil {
+			return
+		}
+	}
+}
+
+// stopDiscarding returns once the messages of the client are no longer
+// dropped, so the room gets every message sent after the admission.
+func (k knock) stopDiscarding() {
+	k.cancel()
+	<-k.drained
+}
+
+func (k knock) message() Message {
+	return Message{MessageType: Knock, Peer: k.id, Data: k.guest.Name}
+}
+
+// knock puts the client into the lobby of the room and lets the hosts
+// know about it. The client leaves the lobby if it is closed.
+func (m *RoomManager) knock(room *room, client *Client, guest Guest) {
+	k := room.lobby.add(client, guest)
+	client.SetOnClose(func() {
+		if _, ok := room.lobby.take(k.id); ok {
+			m.sendToHosts(room, Message{MessageType: KnockLeft, Peer: k.id})
+		}
+	})
+
+	if err := sendMessage(client, WaitForHostMessage); err != nil {
+		slog.Debug("Sending client message:", "room-id", room.Id(), "client", client.Id(), "error", err)
+	}
+	m.sendToHosts(room, k.message())
+	slog.Debug("Client knocked:", "room-id", room.Id(), "client", client.Id(), "knock", k.id)
+}
+
+// sendKnocks tells the host about the clients which are waiting in the lobby.
+func (m *RoomManager) sendKnocks(room *room, host *Client) {
+	for _, k := range room.lobby.all() {
+		if err := sendMessage(host, k.message()); err != nil {
+			slog.Debug("Sending client message:", "room-id", room.Id(), "client", host.Id(), "error", err)
+		}
+	}
+}
+
+// admitKnock moves the client of the knock from the lobby into the room,
+// where it takes a free slot or waits for one.
+func (m *RoomManager) admitKnock(room *room, id int) error {
+	k, ok := room.lobby.take(id)
+	if !ok {
+		return ErrPeerNotFound
+	}
+	m.sendToHosts(room, Message{MessageType: KnockLeft, Peer: id})
+	k.stopDiscarding()
+	room.AddClient(k.client, k.guest.Name)
+	slog.Info("Admitted client:", "room-id", room.Id(), "client", k.client.Id())
+	return nil
+}
+
+// denyKnock closes the client of the knock.
+func (m *RoomManager) denyKnock(room *room, id int) error {
+	k, ok := room.lobby.take(id)
+	if !ok {
+		return ErrPeerNotFound
+	}
+	m.sendToHosts(room, Message{MessageType: KnockLeft, Peer: id})
+	_ = sendMessage(k.client, DeniedMessage)
+	k.client.Close()
+	slog.Info("Denied client:", "room-id", room.Id(), "client", k.client.Id())
+	return nil
+}
+
+// closeLobby denies every client in the lobby of the removed room.
+func (m *RoomManager) closeLobby(room *room) {
+	for _, k := range room.lobby.all() {
+		if _, ok := room.lobby.take(k.id); ok {
+			_ = sendMessage(k.client, DeniedMessage)
+			k.client.Close()
+		}
+	}
+}
+
+// sendToHosts sends the message to every host connected to the room
+// without blocking, as it runs on behalf of the knocking clients.
+func (m *RoomManager) sendToHosts(room *room, message Message) {
+	for _, peer := range room.clients.FindFirst(room.clients.Size()) {
+		if !room.moderation.isHost(peer.Client) {
+			continue
+		}
+		if err := peer.notify(message); err != nil {
+			slog.Debug("Sending client message:", "room-id", room.Id(), "client", peer.Id(), "error", err)
+		}
+	}
+}
+
+// sendMessage sends the message to a client which is not a peer yet.
+func sendMessage(client *Client, message Message) error {
+	bytes, _ := json.Marshal(message)
+	return client.Send(bytes)
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/branow/peer-chat/backplane"
+)
+
+// newLobbyRoom creates a public room in lobby mode.
+func newLobbyRoom(tb testing.TB, m *RoomManager) int {
+	tb.Helper()
+	dto := NewRoomDTO("lobby room", public, 3, "")
+	dto.SetLobby(true)
+	roomId, _, err := m.CreateRoom(*dto)
+	if err != nil {
+		tb.Fatalf("create room: %v", err)
+	}
+	return roomId
+}
+
+func TestLobbyAdmit(t *testing.T) {
+	m := newTestManager()
+	roomId := newLobbyRoom(t, m)
+
+	// A guest knocking before the host comes is shown to the host on join.
+	guest := joinTestRoom(t, m, roomId, Guest{Name: "Alice"})
+	if got := guest.expect(t, Wait); got.Data != WaitForHostMessage.Data {
+		t.Errorf("wait message: got %q, want %q", got.Data, WaitForHostMessage.Data)
+	}
+	host := joinTestRoom(t, m, roomId, Guest{Host: true})
+	host.expect(t, Session)
+	knock := host.expect(t, Knock)
+	if knock.Data != "Alice" {
+		t.Errorf("name of the knock: got %q, want %q", knock.Data, "Alice")
+	}
+	info, err := m.GetRoom(roomId)
+	if err != nil {
+		t.Fatalf("get room: %v", err)
+	}
+	if info.Clients != 1 {
+		t.Errorf("clients before the admission: got %d, want 1", info.Clients)
+	}
+
+	host.send(Message{MessageType: Admit, Peer: knock.Peer})
+	if got := host.expect(t, KnockLeft); got.Peer != knock.Peer {
+		t.Errorf("knock left: got %d, want %d", got.Peer, knock.Peer)
+	}
+	guest.expect(t, Session)
+	host.expect(t, Answer)
+	waitForRoom(t, m, roomId, func(info RoomInfo) bool { return info.Clients == 2 })
+
+	if err := m.AnswerKnock(roomId, knock.Peer, true); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("admit twice: got %v, want %v", err, ErrPeerNotFound)
+	}
+}
+
+func TestLobbyDenyRelayed(t *testing.T) {
+	b := backplane.NewMemory()
+	owner := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	other := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, NewMemoryRoomStore(), b)
+	roomId := newLobbyRoom(t, owner)
+	waitForRoom(t, other, roomId, func(info RoomInfo) bool { return info.Lobby })
+
+	host := joinTestRoom(t, owner, roomId, Guest{Host: true})
+	host.expect(t, Session)
+	guest := joinTestRoom(t, other, roomId, Guest{Name: "Bob"})
+	guest.expect(t, Wait)
+	knock := host.expect(t, Knock)
+
+	// The answer reaches the room through the instance of the guest.
+	if err := other.AnswerKnock(roomId, knock.Peer, false); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	host.expect(t, KnockLeft)
+	guest.expect(t, Denied)
+	guest.expectShutdown(t)
+	waitForRoom(t, owner, roomId, func(info RoomInfo) bool { return info.Clients == 1 })
+}
+
+// flood sends the client more messages than its input holds, they are
+// candidates for a peer which is not linked, so the room ignores them.
+func flood(tb testing.TB, transport *memoryTransport) {
+	tb.Helper()
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for range 5 * cap(transport.in) {
+			transport.send(Message{MessageType: IceCandidate, Peer: 42})
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(testTimeout):
+		tb.Fatalf("messages not read in %v", testTimeout)
+	}
+}
+
+func TestLobbyKnockerFlooding(t *testing.T) {
+	m := newTestManager()
+	roomId := newLobbyRoom(t, m)
+	host := joinTestRoom(t, m, roomId, Guest{Host: true})
+	host.expect(t, Session)
+
+	// The messages sent while knocking do not stall the client, the ones
+	// sent after the admission reach the room.
+	admitted := joinTestRoom(t, m, roomId, Guest{})
+	knock := host.expect(t, Knock)
+	flood(t, admitted)
+	host.send(Message{MessageType: Admit, Peer: knock.Peer})
+	admitted.expect(t, Session)
+	admitted.send(Message{MessageType: Chat, Data: "thanks"})
+	expectChat(t, host, "thanks")
+
+	// A client leaving the lobby after the flood closes cleanly.
+	leaving := joinTestRoom(t, m, roomId, Guest{})
+	knock = host.expect(t, Knock)
+	flood(t, leaving)
+	leaving.hangUp()
+	if got := host.expect(t, KnockLeft); got.Peer != knock.Peer {
+		t.Errorf("knock left: got %d, want %d", got.Peer, knock.Peer)
+	}
+	if err := m.AnswerKnock(roomId, knock.Peer, true); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("admit after leaving: got %v, want %v", err, ErrPeerNotFound)
+	}
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Room passwords are hashed with PBKDF2-HMAC-SHA256 and stored as
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 100_000
+	passwordSaltSize   = 16
+)
+
+// hashPassword returns the hash of the password with a random salt.
+func hashPassword(password string) string {
+	salt := make([]byte, passwordSaltSize)
+	// crypto/rand.Read never returns an error and always fills the slice.
+	_, _ = rand.Read(salt)
+	hash := pbkdf2(sha256.New, []byte(password), salt, passwordIterations, sha256.Size)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+// checkPassword reports whether the password matches the hash.
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	actual := pbkdf2(sha256.New, []byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
+
+// pbkdf2 derives a key of the given size from the password as defined
+// in RFC 8018 with HMAC of the given hash as the pseudorandom function.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(h, password)
+	key := make([]byte, 0, size)
+	block := make([]byte, 4)
+	for i := uint32(1); len(key) < size; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(block, i)
+		prf.Write(block)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			subtle.XORBytes(t, t, u)
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
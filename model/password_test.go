@@ -0,0 +1,64 @@
+package model
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// The vectors of RFC 6070 for HMAC-SHA1, but the one of 16777216
+	// iterations, and of RFC 7914 for HMAC-SHA256.
+	for _, vector := range []struct {
+		hash       func() hash.Hash
+		password   string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{sha1.New, "password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{sha1.New, "password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{sha1.New, "password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{sha1.New, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096,
+			"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{sha1.New, "pass\x00word", "sa\x00lt", 4096, "56fa6aa75548099dcc37d7f03425e0c3"},
+		{sha256.New, "passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{sha256.New, "Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		size := len(vector.key) / 2
+		key := hex.EncodeToString(pbkdf2(vector.hash, []byte(vector.password), []byte(vector.salt),
+			vector.iterations, size))
+		if key != vector.key {
+			t.Errorf("key of %q with %d iterations: got %s, want %s",
+				vector.password, vector.iterations, key, vector.key)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash := hashPassword("secret")
+	if !strings.HasPrefix(hash, passwordScheme+"$") {
+		t.Errorf("hash: got %q, want the scheme %q", hash, passwordScheme)
+	}
+	if !checkPassword(hash, "secret") {
+		t.Error("right password not accepted")
+	}
+	for _, check := range []struct {
+		hash, password string
+	}{
+		{hash, "Secret"},
+		{hash, ""},
+		{strings.Replace(hash, passwordScheme, "md5", 1), "secret"},
+		{passwordScheme + "$0$c2FsdA$aGFzaA", "secret"},
+		{"secret", "secret"},
+	} {
+		if checkPassword(check.hash, check.password) {
+			t.Errorf("password %q accepted for hash %q", check.password, check.hash)
+		}
+	}
+}
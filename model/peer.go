@@ -1,57 +1,171 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 )
 
 type MessageType string
 
 const (
-	RequestOffer = "request-offer"
-	Offer        = "offer"
-	Answer       = "answer"
-	Wait         = "wait"
-	Hold         = "hold"
-	Error        = "error"
+	RequestOffer   = "request-offer"
+	Offer          = "offer"
+	Answer         = "answer"
+	IceCandidate   = "ice-candidate"
+	Role           = "role"
+	PeerLeft       = "peer-left"
+	Session        = "session"
+	Resumed        = "resumed"
+	RoomExpiring   = "room-expiring"
+	RoomExpired    = "room-expired"
+	Kick           = "kick"       // The host closes a client
+	Ban            = "ban"        // The host closes a client for good
+	Lock           = "lock"       // The host locks or unlocks the room
+	Kicked         = "kicked"     // The client was closed by the host
+	Locked         = "locked"     // The room was locked or unlocked
+	Knock          = "knock"      // A client waits in the lobby for the host
+	KnockLeft      = "knock-left" // A client no longer waits in the lobby
+	Admit          = "admit"      // The host lets a client in from the lobby
+	Deny           = "deny"       // The host turns a client away from the lobby
+	Denied         = "denied"     // The client was turned away by the host
+	Chat           = "chat"       // A text message to everyone in the room
+	Roster         = "roster"     // A change of who takes part in the room
+	Wait           = "wait"
+	QueuePosition  = "queue-position"  // Position of a waiting client in the queue
+	ServerShutdown = "server-shutdown" // The server shuts down and closes the connection
+	Error          = "error"
 )
 
 type Message struct {
 	MessageType string `json:"type"`
 	Data        string `json:"data"`
 	SDP         string `json:"sdp"`
+	// Peer identifies the remote peer of the link the message belongs to.
+	// Clients set it to the addressee, the server replaces it with the author.
+	Peer int `json:"peer,omitempty"`
+	// Name is the display name of the peer of the message, if it has one.
+	Name string `json:"name,omitempty"`
+	// Candidate holds an RTCIceCandidate in its JSON form,
+	// JSON null signals the end of candidates.
+	Candidate json.RawMessage `json:"candidate,omitempty"`
 }
 
+// Peer is a client taking part in signaling. It reads messages from
+// the client in the background: messages which have a registered handler
+// are dispatched immediately, the rest are put into the mailbox of
+// the remote peer they are addressed to and wait for ReceiveExpected.
 type Peer struct {
 	*Client
+	id        int    // Identifies the peer within its peer connection
+	token     string // Lets the client resume the peer after a connection drop
+	name      string // Display name, empty if the client has not given one
+	handlers  map[MessageType]func(Message)
+	mailboxes map[int]chan Message
+	isClosed  bool
+	mutex     sync.Mutex // Guards mailboxes and isClosed
 }
 
-func NewPeer(c *Client) *Peer {
-	return &Peer{Client: c}
+func NewPeer(c *Client, id int) *Peer {
+	return &Peer{
+		Client:    c,
+		id:        id,
+		token:     newToken(32),
+		handlers:  map[MessageType]func(Message){},
+		mailboxes: map[int]chan Message{},
+	}
+}
+
+// PeerId returns the identifier of the peer within its peer connection.
+func (p *Peer) PeerId() int {
+	return p.id
+}
+
+// Name returns the display name of the peer.
+func (p *Peer) Name() string {
+	return p.name
+}
+
+// Token returns the resume token of the peer.
+func (p *Peer) Token() string {
+	return p.token
+}
+
+// Handle registers a handler for the given message type. Handlers must be
+// registered before Listen is called.
+func (p *Peer) Handle(messageType MessageType, handle func(Message)) {
+	p.handlers[messageType] = handle
 }
 
-func (p *Peer) ReceiveExpected(messageType MessageType) (Message, error) {
+// Listen reads messages from the client until it is closed and
+// dispatches them. It is meant to be run in its own goroutine.
+func (p *Peer) Listen() {
+	defer p.closeMailboxes()
+
 	for {
 		message, err := p.ReceiveMessage()
 		if err != nil {
-			return message, err
+			if _, ok := err.(IllegalMessageError); ok {
+				slog.Error("Peer read:", "client-id", p.Id(), "error", err)
+				continue
+			}
+			return
 		}
-		if message.MessageType == Hold {
+
+		if handle, ok := p.handlers[MessageType(message.MessageType)]; ok {
+			handle(message)
 			continue
 		}
-		if message.MessageType != string(messageType) {
-			return Message{}, UnexpectedMessageTypeError{
-				ExpectedType:    messageType,
-				ReceivedMessage: message,
-			}
+		p.deliver(message)
+	}
+}
+
+// ReceiveExpected returns the next message addressed to the given remote
+// peer and fails if it is not of the given type. Blocks until a message
+// is available.
+func (p *Peer) ReceiveExpected(from int, messageType MessageType) (Message, error) {
+	return p.ReceiveExpectedContext(context.Background(), from, messageType)
+}
+
+// ReceiveExpectedContext is like ReceiveExpected but gives up when
+// the context is done.
+func (p *Peer) ReceiveExpectedContext(ctx context.Context, from int, messageType MessageType) (Message, error) {
+	mailbox, ok := p.mailbox(from)
+	if !ok {
+		return Message{}, ErrClientIsClosed
+	}
+
+	var message Message
+	select {
+	case message, ok = <-mailbox:
+		if !ok {
+			return Message{}, ErrClientIsClosed
+		}
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+
+	if message.MessageType != string(messageType) {
+		return Message{}, UnexpectedMessageTypeError{
+			ExpectedType:    messageType,
+			ReceivedMessage: message,
 		}
-		return message, nil
 	}
+	return message, nil
 }
 
 func (p *Peer) ReceiveMessage() (Message, error) {
+	return p.ReceiveMessageContext(context.Background())
+}
+
+// ReceiveMessageContext is like ReceiveMessage but gives up when
+// the context is done.
+func (p *Peer) ReceiveMessageContext(ctx context.Context) (Message, error) {
 	var message Message
-	bytes, err := p.Receive()
+	bytes, err := p.ReceiveContext(ctx)
 	if err != nil {
 		return message, err
 	}
@@ -62,8 +176,97 @@ func (p *Peer) ReceiveMessage() (Message, error) {
 }
 
 func (p *Peer) SendMessage(message Message) error {
+	return p.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext is like SendMessage but gives up when
+// the context is done.
+func (p *Peer) SendMessageContext(ctx context.Context, message Message) error {
 	bytes, _ := json.Marshal(message)
-	return p.Send(bytes)
+	return p.SendContext(ctx, bytes)
+}
+
+// notify sends the message to the peer on behalf of another client, so it
+// never blocks the sender. A peer which cannot take the message is behind,
+// its connection is dropped and the client may resume it.
+func (p *Peer) notify(message Message) error {
+	bytes, _ := json.Marshal(message)
+	err := p.TrySend(bytes)
+	if errors.Is(err, ErrClientIsBusy) {
+		slog.Warn("Dropping slow client:", "client-id", p.Id(), "type", message.MessageType)
+		p.closeConnection()
+	}
+	return err
+}
+
+// mailbox returns the channel of messages addressed to the given remote peer.
+func (p *Peer) mailbox(remote int) (<-chan Message, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	mailbox, ok := p.mailboxes[remote]
+	return mailbox, ok
+}
+
+// openMailbox starts accepting messages addressed to the given remote peer.
+func (p *Peer) openMailbox(remote int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.mailboxes[remote]; ok {
+		return
+	}
+	mailbox := make(chan Message, 10)
+	if p.isClosed {
+		close(mailbox)
+	}
+	p.mailboxes[remote] = mailbox
+}
+
+// closeMailbox stops accepting messages addressed to the given remote peer
+// and releases everyone waiting for them.
+func (p *Peer) closeMailbox(remote int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if mailbox, ok := p.mailboxes[remote]; ok {
+		if !p.isClosed {
+			close(mailbox)
+		}
+		delete(p.mailboxes, remote)
+	}
+}
+
+func (p *Peer) closeMailboxes() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.isClosed {
+		return
+	}
+	p.isClosed = true
+	for _, mailbox := range p.mailboxes {
+		close(mailbox)
+	}
+}
+
+func (p *Peer) deliver(message Message) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	mailbox, ok := p.mailboxes[message.Peer]
+	if !ok || p.isClosed {
+		slog.Debug("Peer dropped message for unknown peer:", "client-id", p.Id(),
+			"type", message.MessageType, "peer", message.Peer)
+		return
+	}
+
+	select {
+	case mailbox <- message:
+	default:
+		slog.Warn("Peer dropped message:", "client-id", p.Id(),
+			"type", message.MessageType, "peer", message.Peer)
+	}
 }
 
 type UnexpectedMessageTypeError struct {
@@ -76,6 +279,17 @@ func (e UnexpectedMessageTypeError) Error() string {
 		e.ExpectedType, e.ReceivedMessage.MessageType)
 }
 
+// DeadlineError reports a peer which did not send the expected message
+// in time during signaling.
+type DeadlineError struct {
+	Peer         *Peer
+	ExpectedType MessageType
+}
+
+func (e DeadlineError) Error() string {
+	return fmt.Sprintf("peer missed the deadline for '%v'", e.ExpectedType)
+}
+
 type IllegalMessageError struct {
 	ReceivedMessage []byte
 	Cause           error
@@ -1,10 +1,17 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"math/rand"
+	"strconv"
+	"sync"
+	"time"
 )
 
+var ErrSessionNotFound = errors.New("session not found")
+
 // Messages are used to inform clients about the state of Peer Connection.
 var (
 	RequestOfferMessage = Message{MessageType: RequestOffer}
@@ -17,22 +24,54 @@ var (
 	ErrorMessage = Message{
 		MessageType: Error,
 	}
+	ResumedMessage = Message{MessageType: Resumed}
 )
 
-// PeerConnection establishes and manages peer-to-peer connection between two clients.
+// SignalTimeouts limits how long a peer may take to reply during signaling.
+type SignalTimeouts struct {
+	Offer  time.Duration // From request-offer to the offer
+	Answer time.Duration // From the offer to the answer
+}
+
+var DefaultSignalTimeouts = SignalTimeouts{
+	Offer:  15 * time.Second,
+	Answer: 15 * time.Second,
+}
+
+// PeerConnection establishes and manages peer-to-peer connections between
+// clients. The first clients up to the capacity are active and every pair
+// of them is linked in a mesh, the rest are waiting for a free slot.
 type PeerConnection struct {
 	id                int // Is used to identify PeerConnection during debugging.
+	capacity          int
+	timeouts          SignalTimeouts
 	clients           *ClientList
-	sender            *Peer
-	receiver          *Peer
+	active            map[*Peer]bool
+	links             []*link
+	sessions          map[string]*Peer // Peers by their resume tokens
+	lastPeerId        int
+	mutex             sync.Mutex    // Guards active, links, sessions and lastPeerId
+	positions         map[*Peer]int // Queue positions the waiting clients were told
+	queueMutex        sync.Mutex    // Guards positions and orders queue notifications
+	chat              []Message     // Latest chat messages
+	chatMutex         sync.Mutex    // Guards chat and orders it with the client list
 	onEmptyConnection func()
+	onClientsChange   func()
+	onControl         func(from *Peer, message Message)
 }
 
-func NewPeerConnection() *PeerConnection {
+func NewPeerConnection(capacity int, timeouts SignalTimeouts) *PeerConnection {
 	return &PeerConnection{
 		id:                rand.Intn(1e6),
+		capacity:          capacity,
+		timeouts:          timeouts,
 		clients:           NewClientList(),
+		active:            map[*Peer]bool{},
+		sessions:          map[string]*Peer{},
+		positions:         map[*Peer]int{},
 		onEmptyConnection: func() {},
+		onClientsChange:   func() {},
+		onControl:         func(from *Peer, message Message) {},
 	}
 }
 
@@ -40,109 +79,313 @@ func (c *PeerConnection) Id() int {
 	return c.id
 }
 
+// Capacity returns the maximum number of active clients.
+func (c *PeerConnection) Capacity() int {
+	return c.capacity
+}
+
 func (c *PeerConnection) SetOnEmptyConnection(onEmptyConnection func()) {
 	if onEmptyConnection != nil {
 		c.onEmptyConnection = onEmptyConnection
 	}
 }
 
+// SetOnClientsChange assigns a callback function to be executed after
+// a client is added or removed.
+func (c *PeerConnection) SetOnClientsChange(onClientsChange func()) {
+	if onClientsChange != nil {
+		c.onClientsChange = onClientsChange
+	}
+}
+
+// SetOnControl assigns a callback function to be executed when a client
+// sends a control message, which is not a part of signaling.
+func (c *PeerConnection) SetOnControl(onControl func(from *Peer, message Message)) {
+	if onControl != nil {
+		c.onControl = onControl
+	}
+}
+
 // GetClients returns the number of clients connected to this
 // peer connection including all which are waiting.
 func (c *PeerConnection) GetClients() int {
 	return c.clients.Size()
 }
 
-// AddClient adds a new client to the peer connection.
-// If two clients are available, it starts the signaling process.
-func (c *PeerConnection) AddClient(client *Client) {
-	client.SetOnClose(func() { c.removeClient(client) })
-	c.clients.AddClient(client)
+// GetWaiting returns the number of clients waiting for a free slot.
+func (c *PeerConnection) GetWaiting() int {
+	return max(0, c.clients.Size()-c.capacity)
+}
+
+// AddClient adds a new client with the given display name to the peer
+// connection. If there is a free slot, it starts signaling with every
+// active client.
+func (c *PeerConnection) AddClient(client *Client, name string) {
+	c.mutex.Lock()
+	c.lastPeerId++
+	peer := NewPeer(client, c.lastPeerId)
+	peer.name = name
+	c.sessions[peer.Token()] = peer
+	c.mutex.Unlock()
+
+	peer.Handle(IceCandidate, func(m Message) { c.relayCandidate(peer, m) })
+	peer.Handle(Chat, func(m Message) { c.relayChat(peer, m) })
+	for _, messageType := range []MessageType{Kick, Ban, Lock, Admit, Deny} {
+		peer.Handle(messageType, func(m Message) { c.onControl(peer, m) })
+	}
+	client.SetOnClose(func() { c.removeClient(peer) })
+	history := c.addPeer(peer)
+	go peer.Listen()
 	slog.Debug("PeerConnection added client:", "peer-coonnection", c.Id(),
 		"client", client.Id())
 
-	if c.sender != nil && c.receiver != nil {
-		if err := NewPeer(client).SendMessage(WaitForRoomMessage); err != nil {
+	// The session also tells the client its own peer id.
+	session := Message{MessageType: Session, Data: peer.Token(), Peer: peer.PeerId()}
+	if err := peer.SendMessage(session); err != nil {
+		slog.Error("Sending client message:", "peer-connection", c.Id(),
+			"client", client.Id(), "error", err)
+	}
+	c.replayChat(peer, history)
+	c.sendRoster(peer)
+	c.broadcastRoster(RosterJoined, peer, peer)
+
+	if !c.update() {
+		if err := peer.SendMessage(WaitForRoomMessage); err != nil {
 			slog.Error("Sending client message:", "peer-connection", c.Id(),
 				"client", client.Id(), "error", err)
 		}
-		return
+		c.broadcastRoster(RosterWaiting, peer, nil)
+		c.notifyQueue()
 	}
+	c.onClientsChange()
+}
 
-	if err := c.signal(); err != nil {
-		slog.Error("Signaling on client add:", "peer-connection", c.Id(),
-			"client", client.Id(), "error", err)
+// Broadcast sends the message to every client including the waiting ones.
+// A client which cannot keep up is dropped rather than hold up the others.
+func (c *PeerConnection) Broadcast(message Message) {
+	for _, peer := range c.clients.FindFirst(c.clients.Size()) {
+		if err := peer.notify(message); err != nil {
+			slog.Debug("Broadcasting message:", "peer-connection", c.Id(),
+				"client", peer.Id(), "error", err)
+		}
+	}
+}
 
-		errorMessage := Message{MessageType: Error, Data: err.Error()}
-		_ = NewPeer(client).SendMessage(errorMessage)
+// FindPeer returns the client with the given peer id.
+func (c *PeerConnection) FindPeer(peerId int) (*Peer, bool) {
+	for _, peer := range c.clients.FindFirst(c.clients.Size()) {
+		if peer.PeerId() == peerId {
+			return peer, true
+		}
 	}
+	return nil, false
 }
 
-// signal manages the signaling process to establish a peer connection.
-func (c *PeerConnection) signal() error {
-	clients := c.clients.FindFirst(2)
-	if len(clients) < 2 {
-		for _, client := range clients {
-			_ = NewPeer(client).SendMessage(WaitForPeerMessage)
+// Close closes every client gracefully.
+func (c *PeerConnection) Close() {
+	for _, peer := range c.clients.FindFirst(c.clients.Size()) {
+		peer.Close()
+	}
+}
+
+// WaitSignaling waits until the offer/answer exchanges under way end,
+// or the context is done.
+func (c *PeerConnection) WaitSignaling(ctx context.Context) error {
+	c.mutex.Lock()
+	links := append([]*link{}, c.links...)
+	c.mutex.Unlock()
+
+	for _, l := range links {
+		select {
+		case <-l.signaled:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		return nil
 	}
+	return nil
+}
 
-	c.sender, c.receiver = NewPeer(clients[0]), NewPeer(clients[1])
-	slog.Debug("Starting signaling:", "peer-connection", c.Id(),
-		"sender", c.sender.Id(), "receiver", c.receiver.Id())
+// Resume gives the peer with the given resume token a new transport.
+// The peer keeps its slot, role and links, so the client only has to
+// restart ICE. It returns the client of the resumed peer.
+func (c *PeerConnection) Resume(token string, transport Transport) (*Client, error) {
+	c.mutex.Lock()
+	peer, ok := c.sessions[token]
+	c.mutex.Unlock()
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
 
-	if err := c.sender.SendMessage(RequestOfferMessage); err != nil {
-		return err
+	if err := peer.Resume(transport); err != nil {
+		return nil, err
 	}
+	if err := peer.SendMessage(ResumedMessage); err != nil {
+		return nil, err
+	}
+	slog.Debug("PeerConnection resumed client:", "peer-connection", c.Id(),
+		"client", peer.Id())
+	return peer.Client, nil
+}
 
-	offer, err := c.sender.ReceiveExpected(Offer)
-	if err != nil {
-		return err
+// update brings the active clients in line with the client list and links
+// newly activated clients with the rest. It reports whether the last client
+// of the list is active.
+func (c *PeerConnection) update() bool {
+	c.mutex.Lock()
+	clients := c.clients.FindFirst(c.capacity)
+	newLinks := []*link{}
+	promoted := []*Peer{}
+	for _, peer := range clients {
+		if c.active[peer] {
+			continue
+		}
+		for _, other := range clients {
+			if c.active[other] {
+				newLinks = append(newLinks, newLink(other, peer, c.timeouts))
+			}
+		}
+		c.active[peer] = true
+		promoted = append(promoted, peer)
 	}
+	c.links = append(c.links, newLinks...)
+	lonely := len(c.active) == 1
+	c.mutex.Unlock()
 
-	if err := c.receiver.SendMessage(offer); err != nil {
-		return err
+	for _, peer := range promoted {
+		c.broadcastRoster(RosterActive, peer, nil)
 	}
 
-	answer, err := c.receiver.ReceiveExpected(Answer)
-	if err != nil {
-		return err
+	if lonely {
+		for _, peer := range clients {
+			_ = peer.SendMessage(WaitForPeerMessage)
+		}
 	}
+	for _, l := range newLinks {
+		go c.signal(l)
+	}
+	return len(clients) < c.capacity || c.clients.Size() == len(clients)
+}
 
-	if err := c.sender.SendMessage(answer); err != nil {
-		return err
+// signal runs the signaling of the given link followed by renegotiation
+// rounds until the link is closed, and reports failures to both its sides.
+// A peer which misses a signaling deadline is evicted, so the next waiting
+// client can take its slot.
+func (c *PeerConnection) signal(l *link) {
+	err := l.signal()
+	if err == nil {
+		err = l.negotiate()
+	}
+	if err == nil {
+		return
 	}
 
-	slog.Debug("Finished signaling:", "peer-connection", c.Id(),
-		"sender", c.sender.Id(), "receiver", c.receiver.Id())
-	return nil
+	var deadlineErr DeadlineError
+	if errors.As(err, &deadlineErr) {
+		slog.Info("Evicting peer:", "peer-connection", c.Id(),
+			"client", deadlineErr.Peer.Id(), "error", err)
+		errorMessage := Message{MessageType: Error, Data: err.Error()}
+		_ = deadlineErr.Peer.SendMessage(errorMessage)
+		deadlineErr.Peer.Close()
+		return
+	}
+	if errors.Is(err, ErrClientIsClosed) || errors.Is(err, context.Canceled) {
+		slog.Debug("Signaling interrupted by closed client:", "peer-connection", c.Id(),
+			"offerer", l.offerer.Id(), "answerer", l.answerer.Id())
+		return
+	}
+
+	slog.Error("Signaling:", "peer-connection", c.Id(),
+		"offerer", l.offerer.Id(), "answerer", l.answerer.Id(), "error", err)
+	errorMessage := Message{MessageType: Error, Data: err.Error()}
+	_ = l.offerer.SendMessage(errorMessage)
+	_ = l.answerer.SendMessage(errorMessage)
 }
 
-func (c *PeerConnection) removeClient(client *Client) {
-	c.clients.RemoveClient(client)
-	if c.isSender(client) || c.isReceiver(client) {
-		if c.isSender(client) {
-			c.sender = nil
-		}
-		if c.isReceiver(client) {
-			c.receiver = nil
+// relayCandidate forwards an ICE candidate to the addressed side of
+// a link. Candidates which do not belong to any link are dropped.
+func (c *PeerConnection) relayCandidate(from *Peer, candidate Message) {
+	l := c.findLink(from, candidate.Peer)
+	if l == nil {
+		return
+	}
+
+	if err := l.sendCandidate(from, candidate); err != nil {
+		slog.Error("Relaying ICE candidate:", "peer-connection", c.Id(),
+			"from", from.Id(), "to", l.other(from).Id(), "error", err)
+	}
+}
+
+func (c *PeerConnection) findLink(peer *Peer, remote int) *link {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, l := range c.links {
+		if l.has(peer) && l.other(peer).PeerId() == remote {
+			return l
 		}
-		if err := c.signal(); err != nil {
-			slog.Error("Signaling on client close", "peer-connection", c.Id(),
-				"client", client.Id(), "error", err)
-			c.onEmptyConnection()
-			return
+	}
+	return nil
+}
+
+// removeClient removes the client with its links and promotes waiting
+// clients to the freed slots.
+func (c *PeerConnection) removeClient(peer *Peer) {
+	c.clients.RemoveClient(peer.Client)
+
+	c.mutex.Lock()
+	delete(c.sessions, peer.Token())
+	removedLinks := []*link{}
+	if c.active[peer] {
+		delete(c.active, peer)
+		links := []*link{}
+		for _, l := range c.links {
+			if l.has(peer) {
+				removedLinks = append(removedLinks, l)
+			} else {
+				links = append(links, l)
+			}
 		}
+		c.links = links
+	}
+	c.mutex.Unlock()
+
+	for _, l := range removedLinks {
+		l.close(peer)
 	}
-	if c.sender == nil && c.receiver == nil {
+	c.broadcastRoster(RosterLeft, peer, nil)
+
+	if c.clients.Size() == 0 {
+		c.onClientsChange()
 		c.onEmptyConnection()
+		return
 	}
+	c.update()
+	c.notifyQueue()
+	c.onClientsChange()
 }
 
-func (c *PeerConnection) isSender(client *Client) bool {
-	return c.sender != nil && c.sender.Client == client
-}
+// notifyQueue tells every waiting client its position in the queue, starting
+// from 1, whenever it has changed since the client was last told. The
+// positions are sent without blocking, so they may be sent in order under
+// the lock while a slow client is dropped.
+func (c *PeerConnection) notifyQueue() {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
 
-func (c *PeerConnection) isReceiver(client *Client) bool {
-	return c.receiver != nil && c.receiver.Client == client
+	clients := c.clients.FindFirst(c.clients.Size())
+	positions := map[*Peer]int{}
+	for i := c.capacity; i < len(clients); i++ {
+		peer := clients[i]
+		position := i - c.capacity + 1
+		positions[peer] = position
+		if c.positions[peer] == position {
+			continue
+		}
+		message := Message{MessageType: QueuePosition, Data: strconv.Itoa(position)}
+		if err := peer.notify(message); err != nil {
+			slog.Debug("Sending client message:", "peer-connection", c.Id(),
+				"client", peer.Id(), "error", err)
+		}
+	}
+	c.positions = positions
 }
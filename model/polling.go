@@ -0,0 +1,206 @@
+package model
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// PollingTransport is a Transport over plain HTTP requests for clients
+// whose network does not let WebSocket upgrades through. The client posts
+// its messages, which are passed in with Push, and long-polls for the
+// queued ones with Poll. Every request counts as a sign of life.
+type PollingTransport struct {
+	id           string // Identifies the polling session of the client
+	in           chan []byte
+	out          chan []byte
+	hangUp       chan struct{} // Is closed when the client leaves
+	shutdown     chan struct{} // Is closed when the server closes the connection
+	done         chan struct{} // Is closed when the transport is closed
+	hangUpOnce   sync.Once
+	shutdownOnce sync.Once
+	closeOnce    sync.Once
+	onPong       func()
+	deadline     time.Time
+	mutex        sync.Mutex // Guards onPong and deadline
+}
+
+func NewPollingTransport() *PollingTransport {
+	return &PollingTransport{
+		id:       newToken(16),
+		in:       make(chan []byte, 100),
+		out:      make(chan []byte, 100),
+		hangUp:   make(chan struct{}),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+		onPong:   func() {},
+	}
+}
+
+func (t *PollingTransport) Id() string {
+	return t.id
+}
+
+// Done returns a channel which is closed when the transport is closed.
+func (t *PollingTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Push passes a message posted by the client to the reader of the transport.
+func (t *PollingTransport) Push(ctx context.Context, data []byte) error {
+	t.pong()
+	select {
+	case t.in <- data:
+		return nil
+	case <-t.hangUp:
+		return ErrTransportClosed
+	case <-t.done:
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Poll waits up to the given time for messages to the client and returns
+// all the queued ones. Once the connection is shut down and every message
+// is polled, it returns io.EOF.
+func (t *PollingTransport) Poll(ctx context.Context, wait time.Duration) ([][]byte, error) {
+	t.pong()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	messages := [][]byte{}
+	select {
+	case data := <-t.out:
+		messages = append(messages, data)
+	case <-t.shutdown:
+	case <-t.done:
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+drain:
+	for {
+		select {
+		case data := <-t.out:
+			messages = append(messages, data)
+		default:
+			break drain
+		}
+	}
+	if len(messages) > 0 {
+		return messages, nil
+	}
+
+	// The transport is closed right after it is shut down, checking
+	// the shutdown first tells the client to stop rather than resume.
+	select {
+	case <-t.shutdown:
+		return nil, io.EOF
+	default:
+	}
+	select {
+	case <-t.done:
+		return nil, ErrTransportClosed
+	default:
+		return messages, nil
+	}
+}
+
+// HangUp closes the connection on behalf of the client.
+func (t *PollingTransport) HangUp() {
+	t.hangUpOnce.Do(func() { close(t.hangUp) })
+}
+
+func (t *PollingTransport) ReadMessage() ([]byte, error) {
+	for {
+		t.mutex.Lock()
+		deadline := t.deadline
+		t.mutex.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timeout = time.After(time.Until(deadline))
+		}
+
+		select {
+		case data := <-t.in:
+			return data, nil
+		case <-t.hangUp:
+			return nil, io.EOF
+		case <-t.done:
+			return nil, ErrTransportClosed
+		case <-timeout:
+			// The deadline may have been extended in the meantime.
+			t.mutex.Lock()
+			exceeded := !time.Now().Before(t.deadline)
+			t.mutex.Unlock()
+			if exceeded {
+				return nil, os.ErrDeadlineExceeded
+			}
+		}
+	}
+}
+
+func (t *PollingTransport) WriteMessage(data []byte, deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case t.out <- data:
+		return nil
+	case <-t.shutdown:
+		return ErrTransportClosed
+	case <-t.done:
+		return ErrTransportClosed
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// Ping does not send anything, the client polls all the time anyway.
+func (t *PollingTransport) Ping(deadline time.Time) error {
+	select {
+	case <-t.done:
+		return ErrTransportClosed
+	default:
+		return nil
+	}
+}
+
+func (t *PollingTransport) SetPongHandler(handle func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.onPong = handle
+}
+
+func (t *PollingTransport) SetReadDeadline(deadline time.Time) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.deadline = deadline
+	return nil
+}
+
+// Shutdown lets the client poll the remaining messages, after which
+// Poll reports the end of the connection.
+func (t *PollingTransport) Shutdown(deadline time.Time) error {
+	t.shutdownOnce.Do(func() { close(t.shutdown) })
+	return nil
+}
+
+func (t *PollingTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	return nil
+}
+
+func (t *PollingTransport) pong() {
+	t.mutex.Lock()
+	onPong := t.onPong
+	t.mutex.Unlock()
+	onPong()
+}
@@ -0,0 +1,199 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/branow/peer-chat/validation"
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Sort orders of the public rooms.
+const (
+	SortNewest    = "newest"
+	SortOldest    = "oldest"
+	SortName      = "name"
+	SortOccupancy = "occupancy" // The most clients first
+)
+
+// Number of rooms on a page.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// RoomQuery represents a search of the public rooms, which are returned
+// in pages. The zero values of its filters match every room.
+type RoomQuery struct {
+	name       string
+	minClients int
+	maxClients int // Zero if the number of clients is not limited
+	free       bool
+	sort       string
+	cursor     string
+	limit      int
+}
+
+// NewRoomQuery creates a query of the rooms whose names contain the given
+// string, case-insensitively. An empty sort order sorts the rooms newest
+// first.
+func NewRoomQuery(name string, sortOrder string) *RoomQuery {
+	if sortOrder == "" {
+		sortOrder = SortNewest
+	}
+	return &RoomQuery{name: strings.TrimSpace(name), sort: sortOrder, limit: DefaultPageSize}
+}
+
+// SetOccupancy limits the number of clients of the rooms, a zero maximum
+// leaves it unlimited.
+func (q *RoomQuery) SetOccupancy(minClients, maxClients int) {
+	q.minClients = minClients
+	q.maxClients = maxClients
+}
+
+// SetFree keeps only the rooms with a free slot, which a new client
+// joins without waiting.
+func (q *RoomQuery) SetFree(free bool) {
+	q.free = free
+}
+
+// SetPage makes the query return the page after the cursor, which is
+// empty for the first page. A zero limit returns a page of the default size.
+func (q *RoomQuery) SetPage(cursor string, limit int) {
+	q.cursor = cursor
+	if limit != 0 {
+		q.limit = limit
+	}
+}
+
+func (q RoomQuery) Validate() error {
+	err := validation.Validate(q.name, "room name", validation.NotLongerThan(50))
+	if err != nil {
+		return err
+	}
+	err = validation.Validate(q.sort, "room sort",
+		validation.Equal([]string{SortNewest, SortOldest, SortName, SortOccupancy}))
+	if err != nil {
+		return err
+	}
+	err = validation.Validate(q.minClients, "room occupancy", validation.InRange(0, math.MaxInt))
+	if err != nil {
+		return err
+	}
+	if q.maxClients != 0 {
+		err = validation.Validate(q.maxClients, "room occupancy", validation.InRange(q.minClients, math.MaxInt))
+		if err != nil {
+			return err
+		}
+	}
+	return validation.Validate(q.limit, "room page size", validation.InRange(1, MaxPageSize))
+}
+
+func (q RoomQuery) matches(room RoomInfo) bool {
+	if !strings.Contains(strings.ToLower(room.Name), strings.ToLower(q.name)) {
+		return false
+	}
+	if room.Clients < q.minClients || q.maxClients != 0 && room.Clients > q.maxClients {
+		return false
+	}
+	return !q.free || room.Clients < room.Capacity
+}
+
+// less reports whether the room comes before the other one in the sort
+// order of the query. Ties are broken by ids, so the order is total.
+func (q RoomQuery) less(a, b RoomInfo) bool {
+	switch q.sort {
+	case SortOldest:
+		if !a.CreationTime.Equal(b.CreationTime) {
+			return a.CreationTime.Before(b.CreationTime)
+		}
+	case SortName:
+		if an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name); an != bn {
+			return an < bn
+		}
+	case SortOccupancy:
+		if a.Clients != b.Clients {
+			return a.Clients > b.Clients
+		}
+	default:
+		if !a.CreationTime.Equal(b.CreationTime) {
+			return a.CreationTime.After(b.CreationTime)
+		}
+	}
+	return a.Id < b.Id
+}
+
+// RoomPage is a page of the rooms found by a query.
+type RoomPage struct {
+	Rooms []RoomInfo
+	Total int    // Number of the rooms on all the pages
+	Next  string // Cursor of the next page, empty on the last page
+}
+
+// roomCursor keeps the sort keys of the last room of a page, so the next
+// page starts after it even if rooms are created or removed meanwhile.
+type roomCursor struct {
+	Id           int       `json:"i"`
+	Name         string    `json:"n,omitempty"`
+	Clients      int       `json:"c,omitempty"`
+	CreationTime time.Time `json:"t"`
+}
+
+func encodeCursor(room RoomInfo) string {
+	cursor := roomCursor{Id: room.Id, Name: room.Name, Clients: room.Clients, CreationTime: room.CreationTime}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (RoomInfo, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return RoomInfo{}, ErrInvalidCursor
+	}
+	var cursor roomCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return RoomInfo{}, ErrInvalidCursor
+	}
+	return RoomInfo{
+		Id: cursor.Id, Name: cursor.Name, Clients: cursor.Clients, CreationTime: cursor.CreationTime,
+	}, nil
+}
+
+// QueryPublicRooms returns the page of the public rooms which the query
+// asks for.
+func (m *RoomManager) QueryPublicRooms(query RoomQuery) (RoomPage, error) {
+	var after *RoomInfo
+	if query.cursor != "" {
+		cursor, err := decodeCursor(query.cursor)
+		if err != nil {
+			return RoomPage{}, err
+		}
+		after = &cursor
+	}
+
+	rooms := []RoomInfo{}
+	for _, room := range m.GetPublicRooms() {
+		if query.matches(room) {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return query.less(rooms[i], rooms[j]) })
+
+	page := RoomPage{Total: len(rooms)}
+	if after != nil {
+		start := sort.Search(len(rooms), func(i int) bool { return query.less(*after, rooms[i]) })
+		rooms = rooms[start:]
+	}
+	if len(rooms) > query.limit {
+		rooms = rooms[:query.limit]
+		page.Next = encodeCursor(rooms[len(rooms)-1])
+	}
+	page.Rooms = rooms
+	return page, nil
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// caseVariant returns the name with the letters set in the mask upper-cased,
+// so the variants are different names which sort equally.
+func caseVariant(name string, mask int) string {
+	letters := []rune(name)
+	for i := range letters {
+		if mask&(1<<i) != 0 {
+			letters[i] = []rune(strings.ToUpper(string(letters[i])))[0]
+		}
+	}
+	return string(letters)
+}
+
+func TestQueryPagesThroughEqualSortKeys(t *testing.T) {
+	for _, sortOrder := range []string{SortNewest, SortOldest, SortName, SortOccupancy} {
+		t.Run(sortOrder, func(t *testing.T) {
+			m := newTestManager()
+			rooms := map[int]bool{}
+			for mask := range 9 {
+				roomId, _, err := m.CreateRoom(*NewRoomDTO(caseVariant("same", mask), public, 2, ""))
+				if err != nil {
+					t.Fatalf("create room: %v", err)
+				}
+				rooms[roomId] = true
+			}
+
+			// The room the cursor points to is removed between the pages,
+			// the next page still starts right after it.
+			seen := map[int]bool{}
+			cursor := ""
+			for page := 0; page == 0 || cursor != ""; page++ {
+				query := NewRoomQuery("same", sortOrder)
+				query.SetPage(cursor, 2)
+				result, err := m.QueryPublicRooms(*query)
+				if err != nil {
+					t.Fatalf("query page %d: %v", page, err)
+				}
+				for _, room := range result.Rooms {
+					if seen[room.Id] {
+						t.Errorf("room %d repeated on page %d", room.Id, page)
+					}
+					seen[room.Id] = true
+				}
+				if page == 0 {
+					last := result.Rooms[len(result.Rooms)-1].Id
+					m.removeRoom(last)
+					delete(rooms, last)
+				}
+				cursor = result.Next
+			}
+
+			for roomId := range rooms {
+				if !seen[roomId] {
+					t.Errorf("room %d skipped", roomId)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryRejectsInvalidCursor(t *testing.T) {
+	m := newTestManager()
+	for _, cursor := range []string{"!", "bm90IGpzb24"} {
+		query := NewRoomQuery("", "")
+		query.SetPage(cursor, 0)
+		if _, err := m.QueryPublicRooms(*query); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("query after %q: got %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
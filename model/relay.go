@@ -0,0 +1,325 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/branow/peer-chat/backplane"
+)
+
+var ErrRelayDropped = errors.New("relayed connection dropped")
+
+// A client connected to an instance which does not own its room is relayed
+// to the owner over the backplane. The relaying instance sends envelopes
+// to the topic of the room, the owner replies to the topic of the session.
+const (
+	relayJoin     = "join"     // A client joins or resumes with a token
+	relayMessage  = "message"  // A message of the client or to the client
+	relayHangUp   = "hangup"   // The client closed the connection on purpose
+	relayDrop     = "drop"     // The connection of the client dropped
+	relayShutdown = "shutdown" // The owner closes the connection on purpose
+	relayClose    = "close"    // The owner has closed the connection
+	relayControl  = "control"  // The host controls the room over HTTP
+)
+
+type relayEnvelope struct {
+	Type       string `json:"type"`
+	Session    string `json:"session,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Addr       string `json:"addr,omitempty"`
+	Host       bool   `json:"host,omitempty"`
+	Name       string `json:"name,omitempty"`
+	ResumeOnly bool   `json:"resumeOnly,omitempty"` // The join may only resume a client
+	Data       []byte `json:"data,omitempty"`
+}
+
+func roomTopic(roomId int) string {
+	return "room." + strconv.Itoa(roomId)
+}
+
+func sessionTopic(session string) string {
+	return "session." + session
+}
+
+func publishEnvelope(b backplane.Backplane, topic string, envelope relayEnvelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return b.Publish(topic, data)
+}
+
+// relayedTransport is a Transport of the owner of a room to a client which
+// is connected to another instance. The relaying instance watches the
+// connection, so the transport has no keep-alive of its own.
+type relayedTransport struct {
+	backplane  backplane.Backplane
+	topic      string
+	in         chan []byte
+	hangUp     chan struct{}
+	dropped    chan struct{}
+	done       chan struct{}
+	hangUpOnce sync.Once
+	dropOnce   sync.Once
+	closeOnce  sync.Once
+}
+
+func newRelayedTransport(b backplane.Backplane, session string) *relayedTransport {
+	return &relayedTransport{
+		backplane: b,
+		topic:     sessionTopic(session),
+		in:        make(chan []byte, 100),
+		hangUp:    make(chan struct{}),
+		dropped:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// receive handles an envelope of the relaying instance. It never blocks,
+// as it runs on the subscription of the whole room, so a client which
+// does not read its messages fast enough is dropped and may resume.
+func (t *relayedTransport) receive(envelope relayEnvelope) {
+	switch envelope.Type {
+	case relayMessage:
+		select {
+		case t.in <- envelope.Data:
+		case <-t.done:
+		default:
+			slog.Warn("Dropping slow relayed client:", "topic", t.topic)
+			t.dropOnce.Do(func() { close(t.dropped) })
+		}
+	case relayHangUp:
+		t.hangUpOnce.Do(func() { close(t.hangUp) })
+	case relayDrop:
+		t.dropOnce.Do(func() { close(t.dropped) })
+	}
+}
+
+func (t *relayedTransport) ReadMessage() ([]byte, error) {
+	// Messages relayed before the end of the connection come first.
+	select {
+	case data := <-t.in:
+		return data, nil
+	default:
+	}
+
+	select {
+	case data := <-t.in:
+		return data, nil
+	case <-t.hangUp:
+		return nil, io.EOF
+	case <-t.dropped:
+		return nil, ErrRelayDropped
+	case <-t.done:
+		return nil, ErrTransportClosed
+	}
+}
+
+func (t *relayedTransport) WriteMessage(data []byte, deadline time.Time) error {
+	return t.publish(relayEnvelope{Type: relayMessage, Data: data})
+}
+
+func (t *relayedTransport) Ping(deadline time.Time) error {
+	return nil
+}
+
+func (t *relayedTransport) SetPongHandler(handle func()) {}
+
+func (t *relayedTransport) SetReadDeadline(deadline time.Time) error {
+	return nil
+}
+
+func (t *relayedTransport) Shutdown(deadline time.Time) error {
+	return t.publish(relayEnvelope{Type: relayShutdown})
+}
+
+func (t *relayedTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.done)
+		err = t.publish(relayEnvelope{Type: relayClose})
+	})
+	return err
+}
+
+func (t *relayedTransport) publish(envelope relayEnvelope) error {
+	select {
+	case <-t.done:
+		if envelope.Type != relayClose {
+			return ErrTransportClosed
+		}
+	default:
+	}
+	return publishEnvelope(t.backplane, t.topic, envelope)
+}
+
+// watchedTransport records whether the remote side has closed
+// the connection on purpose.
+type watchedTransport struct {
+	Transport
+	hungUp atomic.Bool
+}
+
+func (t *watchedTransport) ReadMessage() ([]byte, error) {
+	data, err := t.Transport.ReadMessage()
+	if errors.Is(err, io.EOF) {
+		t.hungUp.Store(true)
+	}
+	return data, err
+}
+
+// relayClient connects a client over the given transport to the room owned
+// by another instance. The owner keeps the client's slot when the connection
+// drops, so the client here does not wait to be resumed.
+func (m *RoomManager) relayClient(
+	roomId int, guest Guest, resumeOnly bool, transport Transport,
+) (*Client, error) {
+	session := newToken(16)
+	watched := &watchedTransport{Transport: transport}
+	keepAlive := m.keepAlive
+	keepAlive.ResumeTimeout = 0
+	client := NewClient(watched, keepAlive)
+
+	unsubscribe, err := m.backplane.Subscribe(sessionTopic(session), func(data []byte) {
+		var envelope relayEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			slog.Error("Relay read:", "client-id", client.Id(), "error", err)
+			return
+		}
+		switch envelope.Type {
+		case relayMessage:
+			// The connection of a client which cannot keep up is dropped,
+			// so the owner keeps its slot for it to resume.
+			err := client.TrySend(envelope.Data)
+			if errors.Is(err, ErrClientIsBusy) {
+				slog.Warn("Dropping slow client:", "client-id", client.Id())
+				client.closeConnection()
+			} else if err != nil {
+				slog.Debug("Relay write:", "client-id", client.Id(), "error", err)
+			}
+		case relayShutdown, relayClose:
+			client.Close()
+		}
+	})
+	if err != nil {
+		rejectClient(client, err)
+		return nil, err
+	}
+
+	topic := roomTopic(roomId)
+	join := relayEnvelope{
+		Type:       relayJoin,
+		Session:    session,
+		Token:      guest.Token,
+		Addr:       guest.Addr,
+		Host:       guest.Host,
+		Name:       guest.Name,
+		ResumeOnly: resumeOnly,
+	}
+	if err := publishEnvelope(m.backplane, topic, join); err != nil {
+		unsubscribe()
+		rejectClient(client, err)
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	m.outbound[client] = true
+	m.mutex.Unlock()
+
+	// Relay the messages of the client until it is closed, then tell
+	// the owner how the connection ended.
+	go func() {
+		defer unsubscribe()
+		defer func() {
+			m.mutex.Lock()
+			delete(m.outbound, client)
+			m.mutex.Unlock()
+		}()
+		for {
+			data, err := client.Receive()
+			if err != nil {
+				break
+			}
+			message := relayEnvelope{Type: relayMessage, Session: session, Data: data}
+			if err := publishEnvelope(m.backplane, topic, message); err != nil {
+				slog.Error("Relay message:", "client-id", client.Id(), "error", err)
+			}
+		}
+
+		end := relayEnvelope{Type: relayDrop, Session: session}
+		if watched.hungUp.Load() {
+			end.Type = relayHangUp
+		}
+		if err := publishEnvelope(m.backplane, topic, end); err != nil {
+			slog.Error("Relay end:", "client-id", client.Id(), "error", err)
+		}
+	}()
+
+	slog.Debug("Relaying client:", "room-id", roomId, "client", client.Id())
+	return client, nil
+}
+
+// handleRelay handles an envelope which another instance has sent
+// to the topic of a room owned by this instance.
+func (m *RoomManager) handleRelay(roomId int, data []byte) {
+	var envelope relayEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		slog.Error("Relay read:", "room-id", roomId, "error", err)
+		return
+	}
+
+	if envelope.Type == relayJoin {
+		transport := newRelayedTransport(m.backplane, envelope.Session)
+		m.mutex.Lock()
+		m.relayed[envelope.Session] = transport
+		m.mutex.Unlock()
+		go func() {
+			<-transport.done
+			m.mutex.Lock()
+			delete(m.relayed, envelope.Session)
+			m.mutex.Unlock()
+		}()
+
+		join := m.joinLocal
+		if envelope.ResumeOnly {
+			join = m.resumeLocal
+		}
+		guest := Guest{
+			Token: envelope.Token, Addr: envelope.Addr, Host: envelope.Host, Name: envelope.Name,
+		}
+		if _, err := join(roomId, guest, transport); err != nil {
+			slog.Debug("Join relayed client:", "room-id", roomId, "error", err)
+		}
+		return
+	}
+
+	if envelope.Type == relayControl {
+		var control Message
+		if err := json.Unmarshal(envelope.Data, &control); err != nil {
+			slog.Error("Relay read:", "room-id", roomId, "error", err)
+			return
+		}
+		m.mutex.RLock()
+		room, ok := m.rooms[roomId]
+		m.mutex.RUnlock()
+		if ok {
+			if err := m.applyControl(room, control); err != nil {
+				slog.Debug("Relayed control:", "room-id", roomId, "error", err)
+			}
+		}
+		return
+	}
+
+	m.mutex.RLock()
+	transport, ok := m.relayed[envelope.Session]
+	m.mutex.RUnlock()
+	if ok {
+		transport.receive(envelope)
+	}
+}
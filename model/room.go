@@ -1,30 +1,98 @@
 package model
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"log/slog"
+	"math/big"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/branow/peer-chat/backplane"
 	"github.com/branow/peer-chat/validation"
 )
 
 var (
 	ErrRoomAlreadyExists = errors.New("room already exists")
 	ErrRoomDoesNotExist  = errors.New("room does not exist")
+	ErrWrongPassword     = errors.New("wrong room password")
+	ErrRoomNotStarted    = errors.New("room has not started yet")
+	ErrRoomExpired       = errors.New("room has expired")
 )
 
-// RoomManager holdes and manages peer-to-peer connections.
+// Empty rooms are removed when they are listed, unless they have been
+// created or restored recently and their clients are yet to come.
+const emptyRoomTimeout = 10 * time.Minute
+
+// Room ids stay below 2^53, so they are exact numbers in JavaScript.
+const maxRoomId = 1<<53 - 1
+
+// Clients of a room which expires are warned this long before its end.
+var expiryWarnings = []time.Duration{5 * time.Minute, time.Minute}
+
+// RoomManager holdes and manages peer-to-peer connections. The rooms are
+// kept in the store, which restores them when the manager is created.
+//
+// With a backplane, the manager shares its rooms with the managers of other
+// instances. A room lives in the instance which created it, clients of
+// the room connected to other instances are relayed to it.
 type RoomManager struct {
-	rooms map[int]*room
-	mutex sync.RWMutex
+	rooms       map[int]*room
+	remoteRooms map[int]remoteRoom           // Rooms owned by other instances
+	relayed     map[string]*relayedTransport // Relayed clients by their sessions
+	outbound    map[*Client]bool             // Clients relayed to rooms of other instances
+	store       RoomStore
+	backplane   backplane.Backplane
+	instance    string // Identifies the instance on the backplane
+	keepAlive   KeepAlive
+	timeouts    SignalTimeouts
+	lifecycle   *lifecycle
+	closing     bool // Whether the manager shuts down
+	mutex       sync.RWMutex
 }
 
-func NewRoomManager() *RoomManager {
-	return &RoomManager{
-		rooms: map[int]*room{},
+// NewRoomManager creates a manager which restores the rooms of the store.
+// The backplane may be nil, then the rooms are kept to this instance.
+func NewRoomManager(
+	keepAlive KeepAlive, timeouts SignalTimeouts, store RoomStore, b backplane.Backplane,
+) *RoomManager {
+	m := &RoomManager{
+		rooms:       map[int]*room{},
+		remoteRooms: map[int]remoteRoom{},
+		relayed:     map[string]*relayedTransport{},
+		outbound:    map[*Client]bool{},
+		store:       store,
+		backplane:   b,
+		instance:    newToken(8),
+		keepAlive:   keepAlive,
+		timeouts:    timeouts,
+		lifecycle:   newLifecycle(),
+	}
+
+	if b != nil {
+		if err := m.joinCluster(); err != nil {
+			slog.Error("Join cluster:", "error", err)
+		}
 	}
+
+	records, err := store.Load()
+	if err != nil {
+		slog.Error("Load rooms:", "error", err)
+	}
+	for _, record := range records {
+		if !record.ExpiryTime.IsZero() && time.Now().After(record.ExpiryTime) {
+			m.deleteRecord(record.Id)
+			slog.Info("Removed room as expired:", "room-id", record.Id)
+			continue
+		}
+		room := newRoom(record, timeouts)
+		m.addRoom(room)
+		m.shareRoom(room)
+	}
+	slog.Info("Restored rooms:", "count", len(records))
+	return m
 }
 
 func (m *RoomManager) GetRoom(roomId int) (RoomInfo, error) {
@@ -32,7 +100,10 @@ func (m *RoomManager) GetRoom(roomId int) (RoomInfo, error) {
 	defer m.mutex.RUnlock()
 
 	if room, ok := m.rooms[roomId]; ok {
-		return *newRoomInfo(*room), nil
+		return *newRoomInfo(room), nil
+	}
+	if room, ok := m.remoteRooms[roomId]; ok {
+		return room.info(), nil
 	}
 	return RoomInfo{}, ErrRoomDoesNotExist
 }
@@ -46,7 +117,12 @@ func (m *RoomManager) GetPublicRooms() []RoomInfo {
 	rooms := []RoomInfo{}
 	for _, room := range m.rooms {
 		if room.access == public {
-			rooms = append(rooms, *newRoomInfo(*room))
+			rooms = append(rooms, *newRoomInfo(room))
+		}
+	}
+	for _, room := range m.remoteRooms {
+		if room.record.Access == public {
+			rooms = append(rooms, room.info())
 		}
 	}
 
@@ -57,53 +133,347 @@ func (m *RoomManager) GetPublicRooms() []RoomInfo {
 	return rooms
 }
 
-func (m *RoomManager) CreateRoom(dto RoomDTO) (int, error) {
+// CreateRoom creates a room and returns its id along with the host key,
+// which lets the creator control the room. Only a hash of the key is kept.
+func (m *RoomManager) CreateRoom(dto RoomDTO) (int, string, error) {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
+	if m.closing {
+		m.mutex.Unlock()
+		return 0, "", ErrShuttingDown
+	}
 	for _, room := range m.rooms {
 		if room.name == dto.name {
-			return 0, ErrRoomAlreadyExists
+			m.mutex.Unlock()
+			return 0, "", ErrRoomAlreadyExists
+		}
+	}
+	for _, room := range m.remoteRooms {
+		if room.record.Name == dto.name {
+			m.mutex.Unlock()
+			return 0, "", ErrRoomAlreadyExists
 		}
 	}
 
-	room := newRoom(dto.name, dto.access)
-	room.SetOnEmptyConnection(func() { m.removeRoom(room.id) })
-	m.rooms[room.Id()] = room
+	hostKey, hostKeyHash := newHostKey()
+
+	record := RoomRecord{
+		Id:           m.newRoomId(),
+		Name:         dto.name,
+		Access:       dto.access,
+		Capacity:     dto.capacity,
+		CreationTime: time.Now(),
+		StartTime:    dto.startTime,
+		HostKeyHash:  hostKeyHash,
+		Lobby:        dto.lobby,
+	}
+	if dto.ttl > 0 {
+		record.ExpiryTime = record.CreationTime.Add(dto.ttl)
+		if !dto.startTime.IsZero() {
+			record.ExpiryTime = dto.startTime.Add(dto.ttl)
+		}
+	}
+	if dto.password != "" {
+		record.PasswordHash = hashPassword(dto.password)
+	}
+	if dto.access == private {
+		record.Invites = []Invite{newInvite(InviteDTO{})}
+	}
+	if err := m.store.Save(record); err != nil {
+		m.mutex.Unlock()
+		return 0, "", err
+	}
+
+	room := newRoom(record, m.timeouts)
+	m.addRoom(room)
+	m.mutex.Unlock()
+
+	m.shareRoom(room)
+	m.emitLifecycle(LifecycleCreated, *newRoomInfo(room))
 	slog.Info("Created room:", "room-id", room.Id())
-	return room.Id(), nil
+	return room.Id(), hostKey, nil
+}
+
+// CheckPassword checks the password of the room, a room without
+// a password accepts any.
+func (m *RoomManager) CheckPassword(roomId int, password string) error {
+	m.mutex.RLock()
+	var passwordHash string
+	if room, ok := m.rooms[roomId]; ok {
+		passwordHash = room.passwordHash
+	} else if room, ok := m.remoteRooms[roomId]; ok {
+		passwordHash = room.record.PasswordHash
+	} else {
+		m.mutex.RUnlock()
+		return ErrRoomDoesNotExist
+	}
+	m.mutex.RUnlock()
+
+	if passwordHash != "" && !checkPassword(passwordHash, password) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// newRoomId returns a random id which no room has yet. Ids are drawn
+// from a cryptographic source, so they cannot be guessed.
+func (m *RoomManager) newRoomId() int {
+	for {
+		n, _ := rand.Int(rand.Reader, big.NewInt(maxRoomId))
+		id := int(n.Int64()) + 1
+		_, local := m.rooms[id]
+		_, remote := m.remoteRooms[id]
+		if !local && !remote {
+			return id
+		}
+	}
+}
+
+func (m *RoomManager) addRoom(room *room) {
+	// A room which expires is kept until its end, even if it empties.
+	// The rooms emptied by a shutdown are kept to be restored.
+	room.SetOnEmptyConnection(func() {
+		if room.expiryTime.IsZero() && !m.isClosing() {
+			m.removeRoom(room.Id())
+		}
+	})
+	room.SetOnClientsChange(func() {
+		room.moderation.prune()
+		m.announceRoom(room)
+		m.emitLifecycle(LifecycleOccupancy, *newRoomInfo(room))
+	})
+	room.SetOnControl(func(from *Peer, control Message) { m.handleControl(room, from, control) })
+	m.rooms[room.Id()] = room
+	m.scheduleExpiry(room)
+}
+
+// scheduleExpiry warns the clients of the room before it expires and
+// removes the room at its end.
+func (m *RoomManager) scheduleExpiry(room *room) {
+	if room.expiryTime.IsZero() {
+		return
+	}
+
+	for _, before := range expiryWarnings {
+		if wait := time.Until(room.expiryTime.Add(-before)); wait > 0 {
+			warning := Message{MessageType: RoomExpiring, Data: room.expiryTime.Format(time.RFC3339)}
+			room.timers.add(time.AfterFunc(wait, func() { room.Broadcast(warning) }))
+		}
+	}
+	room.timers.add(time.AfterFunc(time.Until(room.expiryTime), func() {
+		slog.Info("Room expired:", "room-id", room.Id())
+		m.removeRoom(room.Id())
+		room.Broadcast(Message{MessageType: RoomExpired})
+		room.Close()
+	}))
 }
 
 func (m *RoomManager) removeEmptyRooms() {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
+	if m.closing {
+		m.mutex.Unlock()
+		return
+	}
+	removed := []*room{}
 	for _, room := range m.rooms {
-		// Check wheather the room is empty and remove it if so
-		if room.GetClients() == 0 {
+		// Check wheather the room is empty and remove it if so, rooms which
+		// expire are removed at their end.
+		idle := room.GetClients() == 0 && room.lobby.size() == 0 && room.expiryTime.IsZero()
+		if idle && time.Since(room.idleSince()) > emptyRoomTimeout {
 			delete(m.rooms, room.Id())
+			room.timers.stop()
+			m.deleteRecord(room.Id())
+			removed = append(removed, room)
 			slog.Info("Removed room as empty:", "room-id", room.Id())
 		}
 	}
+	m.mutex.Unlock()
 
+	for _, room := range removed {
+		m.closeLobby(room)
+		m.unshareRoom(room)
+		m.emitLifecycle(LifecycleRemoved, *newRoomInfo(room))
+	}
 }
 
 func (m *RoomManager) removeRoom(roomId int) {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
+	room, ok := m.rooms[roomId]
+	if !ok {
+		m.mutex.Unlock()
+		return
+	}
 	delete(m.rooms, roomId)
+	room.timers.stop()
+	m.deleteRecord(roomId)
+	m.mutex.Unlock()
+
+	m.closeLobby(room)
+	m.unshareRoom(room)
+	m.emitLifecycle(LifecycleRemoved, *newRoomInfo(room))
 	slog.Info("Removed room:", "room-id", roomId)
 }
 
-func (m *RoomManager) AddClient(roomId int, client *Client) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// saveRoom stores the current record of the room, unless the room has
+// been removed in the meantime.
+func (m *RoomManager) saveRoom(room *room) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 
-	if room, ok := m.rooms[roomId]; ok {
-		room.AddClient(client)
+	if _, ok := m.rooms[room.Id()]; !ok {
+		return
+	}
+	if err := m.store.Save(room.record()); err != nil {
+		slog.Error("Save room record:", "room-id", room.Id(), "error", err)
+	}
+}
+
+func (m *RoomManager) deleteRecord(roomId int) {
+	if err := m.store.Delete(roomId); err != nil {
+		slog.Error("Delete room record:", "room-id", roomId, "error", err)
+	}
+}
+
+// JoinRoom connects the guest over the given transport to the room. The
+// client with the resume token of the guest gets the transport if it still
+// waits for one, otherwise a new client is added. If the room is owned by
+// another instance, the client is relayed to it. The transport is closed
+// on failure.
+func (m *RoomManager) JoinRoom(roomId int, guest Guest, transport Transport) (*Client, error) {
+	return m.connect(roomId, guest, false, transport)
+}
+
+// ResumeRoom gives the transport to the client with the resume token of
+// the guest, but never adds a new client. It suits clients which may no
+// longer join the room, yet may get their slot back. The transport is
+// closed on failure.
+func (m *RoomManager) ResumeRoom(roomId int, guest Guest, transport Transport) (*Client, error) {
+	return m.connect(roomId, guest, true, transport)
+}
+
+func (m *RoomManager) connect(roomId int, guest Guest, resumeOnly bool, transport Transport) (*Client, error) {
+	m.mutex.RLock()
+	_, local := m.rooms[roomId]
+	_, remote := m.remoteRooms[roomId]
+	closing := m.closing
+	m.mutex.RUnlock()
+
+	switch {
+	case closing:
+		m.rejectTransport(transport, ErrShuttingDown)
+		return nil, ErrShuttingDown
+	case local && resumeOnly:
+		return m.resumeLocal(roomId, guest, transport)
+	case local:
+		return m.joinLocal(roomId, guest, transport)
+	case remote:
+		return m.relayClient(roomId, guest, resumeOnly, transport)
+	default:
+		m.rejectTransport(transport, ErrRoomDoesNotExist)
+		return nil, ErrRoomDoesNotExist
+	}
+}
+
+// rejectTransport tells the remote side of the transport why it may not
+// join and closes the connection on purpose, so the remote side does not
+// take it for a dropped connection and try to resume it.
+func (m *RoomManager) rejectTransport(transport Transport, err error) {
+	deadline := time.Now().Add(m.keepAlive.WriteTimeout)
+	data, _ := json.Marshal(newErrorMessage(err))
+	_ = transport.WriteMessage(data, deadline)
+	_ = transport.Shutdown(deadline)
+	_ = transport.Close()
+}
+
+// rejectClient is like rejectTransport for a client which has been
+// started, the client flushes the message before it is closed.
+func rejectClient(client *Client, err error) {
+	_ = sendMessage(client, newErrorMessage(err))
+	client.Close()
+}
+
+func newErrorMessage(err error) Message {
+	return Message{MessageType: Error, Data: err.Error()}
+}
+
+// joinLocal connects a client to the room owned by this instance.
+func (m *RoomManager) joinLocal(roomId int, guest Guest, transport Transport) (*Client, error) {
+	if guest.Token != "" {
+		client, err := m.ResumeClient(roomId, guest, transport)
+		if err == nil {
+			return client, nil
+		}
+		slog.Debug("Resume client:", "room-id", roomId, "error", err)
+	}
+
+	client := NewClient(transport, m.keepAlive)
+	if err := m.AddClient(roomId, client, guest); err != nil {
+		rejectClient(client, err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// resumeLocal resumes a client of the room owned by this instance.
+func (m *RoomManager) resumeLocal(roomId int, guest Guest, transport Transport) (*Client, error) {
+	client, err := m.ResumeClient(roomId, guest, transport)
+	if err != nil {
+		m.rejectTransport(transport, err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// AddClient adds the client of the guest to the room, if the room is open
+// and admits the guest. In a room with a lobby, the client of a guest waits
+// in the lobby until the host lets it in. The manager is not locked while the room starts
+// signaling, so a slow client cannot block other rooms.
+func (m *RoomManager) AddClient(roomId int, client *Client, guest Guest) error {
+	m.mutex.RLock()
+	room, ok := m.rooms[roomId]
+	closing := m.closing
+	m.mutex.RUnlock()
+
+	if closing {
+		return ErrShuttingDown
+	}
+	if !ok {
+		return ErrRoomDoesNotExist
+	}
+	if err := newRoomInfo(room).CheckOpen(time.Now()); err != nil {
+		return err
+	}
+	if err := room.moderation.admit(client, guest, false); err != nil {
+		return err
+	}
+	if room.lobbyMode && !guest.Host {
+		m.knock(room, client, guest)
+		return nil
+	}
+	room.AddClient(client, guest.Name)
+	if guest.Host {
+		m.sendKnocks(room, client)
 	}
-	return ErrRoomDoesNotExist
+	return nil
+}
+
+// ResumeClient gives the client with the resume token of the guest
+// a new transport and returns the client, unless the guest is banned.
+func (m *RoomManager) ResumeClient(roomId int, guest Guest, transport Transport) (*Client, error) {
+	m.mutex.RLock()
+	room, ok := m.rooms[roomId]
+	closing := m.closing
+	m.mutex.RUnlock()
+
+	if closing {
+		return nil, ErrShuttingDown
+	}
+	if !ok {
+		return nil, ErrRoomDoesNotExist
+	}
+	if err := room.moderation.admit(nil, guest, true); err != nil {
+		return nil, err
+	}
+	return room.Resume(guest.Token, transport)
 }
 
 const (
@@ -111,19 +481,56 @@ const (
 	public
 )
 
-// RoomDTO represents data required to create a room.
+// Room capacity limits the number of active participants. Two participants
+// share a single link, more of them are connected in a mesh.
+const (
+	DefaultRoomCapacity = 2
+	MinRoomCapacity     = 2
+	MaxRoomCapacity     = 6
+)
+
+// Limits of the time window of a scheduled room.
+const (
+	MinRoomTTL      = 5 * time.Minute
+	MaxRoomTTL      = 24 * time.Hour
+	MaxRoomLeadTime = 90 * 24 * time.Hour // How far ahead a room may start
+)
+
+// RoomDTO represents data required to create a room. The password
+// is optional, an empty one leaves the room open to anyone.
 type RoomDTO struct {
-	name   string
-	access int
+	name      string
+	access    int
+	capacity  int
+	password  string
+	startTime time.Time
+	ttl       time.Duration
+	lobby     bool
 }
 
-func NewRoomDTO(name string, access int) *RoomDTO {
+func NewRoomDTO(name string, access int, capacity int, password string) *RoomDTO {
 	return &RoomDTO{
-		name:   name,
-		access: access,
+		name:     name,
+		access:   access,
+		capacity: capacity,
+		password: password,
 	}
 }
 
+// SetSchedule limits the room to the window which opens at the start time
+// and lasts for the TTL. A zero start time opens the room right away,
+// a zero TTL keeps it open until it empties.
+func (r *RoomDTO) SetSchedule(startTime time.Time, ttl time.Duration) {
+	r.startTime = startTime
+	r.ttl = ttl
+}
+
+// SetLobby makes new clients of the room wait in a lobby until the host
+// admits them.
+func (r *RoomDTO) SetLobby(lobby bool) {
+	r.lobby = lobby
+}
+
 func (r RoomDTO) Validate() error {
 	err := validation.Validate(r.name, "room name",
 		validation.NotBlank(),
@@ -136,23 +543,128 @@ func (r RoomDTO) Validate() error {
 	if err != nil {
 		return err
 	}
+	err = validation.Validate(r.capacity, "room capacity",
+		validation.InRange(MinRoomCapacity, MaxRoomCapacity))
+	if err != nil {
+		return err
+	}
+	if r.password != "" {
+		err = validation.Validate(r.password, "room password",
+			validation.NotShorterThan(4),
+			validation.NotLongerThan(64))
+		if err != nil {
+			return err
+		}
+	}
+	if !r.startTime.IsZero() {
+		// A minute of slack lets a room be scheduled for right now.
+		err = validation.Validate(time.Until(r.startTime), "room start time",
+			validation.InRange(-time.Minute, MaxRoomLeadTime))
+		if err != nil {
+			return err
+		}
+	}
+	if r.ttl != 0 {
+		err = validation.Validate(r.ttl, "room duration", validation.InRange(MinRoomTTL, MaxRoomTTL))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type room struct {
 	*PeerConnection
+	id           int
 	name         string
 	access       int
 	creationTime time.Time
+	passwordHash string    // Empty if the room has no password
+	hostKeyHash  string    // Empty if the room has no host
+	startTime    time.Time // Zero if the room opened on creation
+	expiryTime   time.Time // Zero if the room never expires
+	openedAt     time.Time // When the room was created or restored
+	lobbyMode    bool      // Whether new clients wait for the host
+	timers       *roomTimers
+	moderation   *moderation
+	lobby        *lobby
+	invites      *invites
+	unsubscribe  func() // Stops relaying clients of other instances
 }
 
-func newRoom(name string, access int) *room {
+func newRoom(record RoomRecord, timeouts SignalTimeouts) *room {
 	return &room{
-		PeerConnection: NewPeerConnection(),
-		name:           name,
-		access:         access,
-		creationTime:   time.Now(),
+		PeerConnection: NewPeerConnection(record.Capacity, timeouts),
+		id:             record.Id,
+		name:           record.Name,
+		access:         record.Access,
+		creationTime:   record.CreationTime,
+		passwordHash:   record.PasswordHash,
+		hostKeyHash:    record.HostKeyHash,
+		startTime:      record.StartTime,
+		expiryTime:     record.ExpiryTime,
+		openedAt:       time.Now(),
+		lobbyMode:      record.Lobby,
+		timers:         &roomTimers{},
+		moderation:     newModeration(record.Locked, record.BannedAddrs),
+		lobby:          newLobby(),
+		invites:        newInvites(record.Invites, record.InviteGen),
+	}
+}
+
+func (r *room) Id() int {
+	return r.id
+}
+
+func (r *room) record() RoomRecord {
+	return RoomRecord{
+		Id:           r.id,
+		Name:         r.name,
+		Access:       r.access,
+		Capacity:     r.Capacity(),
+		CreationTime: r.creationTime,
+		PasswordHash: r.passwordHash,
+		HostKeyHash:  r.hostKeyHash,
+		Lobby:        r.lobbyMode,
+		Locked:       r.moderation.isLocked(),
+		BannedAddrs:  r.moderation.banned(),
+		Invites:      r.invites.all(),
+		InviteGen:    r.invites.currentGeneration(),
+		StartTime:    r.startTime,
+		ExpiryTime:   r.expiryTime,
+	}
+}
+
+// idleSince returns when the room could have got its first client.
+func (r *room) idleSince() time.Time {
+	if r.startTime.After(r.openedAt) {
+		return r.startTime
 	}
+	return r.openedAt
+}
+
+// roomTimers holds the timers which warn the clients of a room before
+// it expires and remove it at its end.
+type roomTimers struct {
+	timers []*time.Timer
+	mutex  sync.Mutex
+}
+
+func (t *roomTimers) add(timer *time.Timer) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.timers = append(t.timers, timer)
+}
+
+func (t *roomTimers) stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, timer := range t.timers {
+		timer.Stop()
+	}
+	t.timers = nil
 }
 
 // RoomInfo represents public information about a room.
@@ -160,14 +672,45 @@ type RoomInfo struct {
 	Id           int
 	Name         string
 	Clients      int
+	Waiting      int // Clients waiting for a free slot
+	Roster       []RosterEntry
+	Capacity     int
 	CreationTime time.Time
+	Private      bool      // Whether the room is entered by invites only
+	InviteGen    int       // Generation of the invites, passes are bound to it
+	Protected    bool      // Whether the room asks for a password
+	Locked       bool      // Whether the host has locked the room
+	Lobby        bool      // Whether new clients wait for the host to admit them
+	StartTime    time.Time // Zero if the room opened on creation
+	ExpiryTime   time.Time // Zero if the room never expires
+}
+
+// CheckOpen checks whether the room accepts new clients at the given time.
+func (r RoomInfo) CheckOpen(t time.Time) error {
+	if t.Before(r.StartTime) {
+		return ErrRoomNotStarted
+	}
+	if !r.ExpiryTime.IsZero() && !t.Before(r.ExpiryTime) {
+		return ErrRoomExpired
+	}
+	return nil
 }
 
-func newRoomInfo(room room) *RoomInfo {
+func newRoomInfo(room *room) *RoomInfo {
 	return &RoomInfo{
 		Id:           room.Id(),
 		Name:         room.name,
 		Clients:      room.GetClients(),
+		Waiting:      room.GetWaiting(),
+		Roster:       room.Roster(),
+		Capacity:     room.Capacity(),
 		CreationTime: room.creationTime,
+		Private:      room.access == private,
+		InviteGen:    room.invites.currentGeneration(),
+		Protected:    room.passwordHash != "",
+		Locked:       room.moderation.isLocked(),
+		Lobby:        room.lobbyMode,
+		StartTime:    room.startTime,
+		ExpiryTime:   room.expiryTime,
 	}
 }
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newScheduledRoom creates a public room open in the given window.
+func newScheduledRoom(tb testing.TB, m *RoomManager, startTime time.Time, ttl time.Duration) int {
+	tb.Helper()
+	dto := NewRoomDTO("scheduled room", public, 2, "")
+	dto.SetSchedule(startTime, ttl)
+	roomId, _, err := m.CreateRoom(*dto)
+	if err != nil {
+		tb.Fatalf("create room: %v", err)
+	}
+	return roomId
+}
+
+func TestRoomNotStarted(t *testing.T) {
+	m := newTestManager()
+	start := time.Now().Add(time.Hour)
+	roomId := newScheduledRoom(t, m, start, time.Hour)
+
+	info, err := m.GetRoom(roomId)
+	if err != nil {
+		t.Fatalf("get room: %v", err)
+	}
+	if !info.StartTime.Equal(start) || !info.ExpiryTime.Equal(start.Add(time.Hour)) {
+		t.Errorf("schedule: got %v to %v, want %v to %v",
+			info.StartTime, info.ExpiryTime, start, start.Add(time.Hour))
+	}
+
+	transport := newMemoryTransport()
+	if _, err := m.JoinRoom(roomId, Guest{}, transport); !errors.Is(err, ErrRoomNotStarted) {
+		t.Errorf("join before the start: got %v, want %v", err, ErrRoomNotStarted)
+	}
+}
+
+func TestRoomExpiry(t *testing.T) {
+	defer func(warnings []time.Duration) { expiryWarnings = warnings }(expiryWarnings)
+	expiryWarnings = []time.Duration{200 * time.Millisecond}
+
+	m := newTestManager()
+	roomId := newScheduledRoom(t, m, time.Time{}, 400*time.Millisecond)
+	info, err := m.GetRoom(roomId)
+	if err != nil {
+		t.Fatalf("get room: %v", err)
+	}
+
+	// A room which expires is kept until its end even if it empties.
+	left := joinTestRoom(t, m, roomId, Guest{})
+	left.expect(t, Session)
+	left.hangUp()
+	guest := joinTestRoom(t, m, roomId, Guest{})
+	guest.expect(t, Session)
+
+	warning := guest.expect(t, RoomExpiring)
+	if want := info.ExpiryTime.Format(time.RFC3339); warning.Data != want {
+		t.Errorf("expiry time of the warning: got %q, want %q", warning.Data, want)
+	}
+	guest.expect(t, RoomExpired)
+	guest.expectShutdown(t)
+	if _, err := m.GetRoom(roomId); !errors.Is(err, ErrRoomDoesNotExist) {
+		t.Errorf("get expired room: got %v, want %v", err, ErrRoomDoesNotExist)
+	}
+}
+
+func TestExpiredRoomNotRestored(t *testing.T) {
+	store := NewMemoryRoomStore()
+	now := time.Now()
+	for _, record := range []RoomRecord{
+		{Id: 1, Name: "expired", Access: public, Capacity: 2, CreationTime: now, ExpiryTime: now.Add(-time.Second)},
+		{Id: 2, Name: "open", Access: public, Capacity: 2, CreationTime: now, ExpiryTime: now.Add(time.Hour)},
+	} {
+		if err := store.Save(record); err != nil {
+			t.Fatalf("save room %d: %v", record.Id, err)
+		}
+	}
+
+	m := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, store, nil)
+	if _, err := m.GetRoom(1); !errors.Is(err, ErrRoomDoesNotExist) {
+		t.Errorf("get expired room: got %v, want %v", err, ErrRoomDoesNotExist)
+	}
+	if _, err := m.GetRoom(2); err != nil {
+		t.Errorf("get open room: %v", err)
+	}
+	records, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(records) != 1 || records[0].Id != 2 {
+		t.Errorf("stored %+v, want only the open room", records)
+	}
+}
+
+func TestRoomQueuePositions(t *testing.T) {
+	m := newTestManager()
+	roomId := newTestRoom(t, m, 1)
+	first := joinTestRoom(t, m, roomId, Guest{})
+	first.expect(t, Session)
+	second := joinTestRoom(t, m, roomId, Guest{})
+	if got := second.expect(t, QueuePosition); got.Data != "1" {
+		t.Errorf("position of the second client: got %q, want 1", got.Data)
+	}
+	third := joinTestRoom(t, m, roomId, Guest{})
+	if got := third.expect(t, QueuePosition); got.Data != "2" {
+		t.Errorf("position of the third client: got %q, want 2", got.Data)
+	}
+
+	// The queue moves up once the slot is free.
+	first.hangUp()
+	if got := second.expect(t, Wait); got.Data != WaitForPeerMessage.Data {
+		t.Errorf("wait message of the second client: got %q, want %q", got.Data, WaitForPeerMessage.Data)
+	}
+	if got := third.expect(t, QueuePosition); got.Data != "1" {
+		t.Errorf("position of the third client: got %q, want 1", got.Data)
+	}
+}
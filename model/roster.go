@@ -0,0 +1,70 @@
+package model
+
+import "log/slog"
+
+// Roster events tell the clients of a room who takes part in it. They are
+// sent as roster messages with the event as the data.
+const (
+	RosterJoined  = "joined"  // A client has joined the room
+	RosterLeft    = "left"    // A client has left the room
+	RosterActive  = "active"  // A client has got a slot and is linked with the others
+	RosterWaiting = "waiting" // A client waits for a free slot
+)
+
+// RosterEntry describes a participant of a room.
+type RosterEntry struct {
+	Peer   int    `json:"peer"`
+	Name   string `json:"name,omitempty"` // Empty if the participant has not given one
+	Active bool   `json:"active,omitempty"`
+}
+
+// Roster returns the participants of the peer connection in the order
+// they have joined it, the active ones come first.
+func (c *PeerConnection) Roster() []RosterEntry {
+	peers := c.clients.FindFirst(c.clients.Size())
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	roster := make([]RosterEntry, 0, len(peers))
+	for _, peer := range peers {
+		roster = append(roster, RosterEntry{
+			Peer: peer.PeerId(), Name: peer.Name(), Active: c.active[peer],
+		})
+	}
+	return roster
+}
+
+// sendRoster tells the client which has just joined who is in the room.
+func (c *PeerConnection) sendRoster(to *Peer) {
+	for _, entry := range c.Roster() {
+		state := RosterWaiting
+		if entry.Active {
+			state = RosterActive
+		}
+		for _, event := range []string{RosterJoined, state} {
+			message := Message{MessageType: Roster, Data: event, Peer: entry.Peer, Name: entry.Name}
+			if err := to.SendMessage(message); err != nil {
+				slog.Debug("Sending roster:", "peer-connection", c.Id(),
+					"client", to.Id(), "error", err)
+				return
+			}
+		}
+	}
+}
+
+// broadcastRoster tells every client of the room but the excluded one
+// about the roster event of the peer. It runs on behalf of the peer, so
+// a client which cannot keep up is dropped rather than hold it up.
+func (c *PeerConnection) broadcastRoster(event string, peer *Peer, except *Peer) {
+	message := Message{MessageType: Roster, Data: event, Peer: peer.PeerId(), Name: peer.Name()}
+	for _, other := range c.clients.FindFirst(c.clients.Size()) {
+		if other == except {
+			continue
+		}
+		if err := other.notify(message); err != nil {
+			slog.Debug("Broadcasting roster:", "peer-connection", c.Id(),
+				"client", other.Id(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import "testing"
+
+// expectRoster waits for the next roster message and checks its event
+// and the name of its peer.
+func expectRoster(tb testing.TB, transport *memoryTransport, event string, name string) Message {
+	tb.Helper()
+	message := transport.expect(tb, Roster)
+	if message.Data != event || message.Name != name {
+		tb.Fatalf("roster: got %q of %q, want %q of %q", message.Data, message.Name, event, name)
+	}
+	return message
+}
+
+func TestRoster(t *testing.T) {
+	m := newTestManager()
+	roomId := newTestRoom(t, m, 1)
+	alice := joinTestRoom(t, m, roomId, Guest{Name: "Alice"})
+	alice.expect(t, Session)
+	expectRoster(t, alice, RosterJoined, "Alice")
+	expectRoster(t, alice, RosterWaiting, "Alice")
+	expectRoster(t, alice, RosterActive, "Alice")
+
+	// A client joining later learns who is in the room first.
+	bob := joinTestRoom(t, m, roomId, Guest{Name: "Bob"})
+	expectRoster(t, bob, RosterJoined, "Alice")
+	expectRoster(t, bob, RosterActive, "Alice")
+	expectRoster(t, bob, RosterJoined, "Bob")
+	expectRoster(t, bob, RosterWaiting, "Bob")
+	expectRoster(t, bob, RosterWaiting, "Bob") // As told to everyone
+	expectRoster(t, alice, RosterJoined, "Bob")
+	expectRoster(t, alice, RosterWaiting, "Bob")
+
+	alice.hangUp()
+	left := expectRoster(t, bob, RosterLeft, "Alice")
+	if active := expectRoster(t, bob, RosterActive, "Bob"); active.Peer == left.Peer {
+		t.Errorf("peer of the roster events: got %d for both Alice and Bob", left.Peer)
+	}
+	info, err := m.GetRoom(roomId)
+	if err != nil {
+		t.Fatalf("get room: %v", err)
+	}
+	if info.Clients != 1 {
+		t.Errorf("clients: got %d, want 1", info.Clients)
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// ServerShutdownMessage tells a client that the server shuts down and is
+// about to close its connection. Peers which are already connected keep
+// their call.
+var ServerShutdownMessage = Message{MessageType: ServerShutdown}
+
+// Shutdown stops the manager from taking new rooms and clients, tells every
+// client that the server shuts down and closes them. Signaling under way
+// may end until the context is done, so peers halfway through it still get
+// connected. The rooms are kept in the store to be restored on the next
+// start.
+func (m *RoomManager) Shutdown(ctx context.Context) {
+	m.mutex.Lock()
+	if m.closing {
+		m.mutex.Unlock()
+		return
+	}
+	m.closing = true
+	rooms := []*room{}
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	outbound := []*Client{}
+	for client := range m.outbound {
+		outbound = append(outbound, client)
+	}
+	m.mutex.Unlock()
+	slog.Info("Shutting down rooms:", "count", len(rooms), "relayed", len(outbound))
+
+	for _, room := range rooms {
+		room.timers.stop()
+		room.Broadcast(ServerShutdownMessage)
+		for _, k := range room.lobby.all() {
+			_ = sendMessage(k.client, ServerShutdownMessage)
+		}
+	}
+	for _, client := range outbound {
+		_ = sendMessage(client, ServerShutdownMessage)
+	}
+
+	for _, room := range rooms {
+		if err := room.WaitSignaling(ctx); err != nil {
+			slog.Warn("Signaling cut short by shutdown:", "room-id", room.Id(), "error", err)
+		}
+	}
+
+	clients := outbound
+	for _, room := range rooms {
+		for _, peer := range room.clients.FindFirst(room.clients.Size()) {
+			clients = append(clients, peer.Client)
+		}
+		for _, k := range room.lobby.all() {
+			if _, ok := room.lobby.take(k.id); ok {
+				clients = append(clients, k.client)
+			}
+		}
+	}
+	for _, client := range clients {
+		client.Close()
+	}
+	m.waitClosed(clients)
+
+	// Relayed clients are flushed over the backplane, so it is released
+	// only after them.
+	for _, room := range rooms {
+		m.unshareRoom(room)
+	}
+	if m.backplane != nil {
+		if err := m.backplane.Close(); err != nil {
+			slog.Error("Close backplane:", "error", err)
+		}
+	}
+	slog.Info("Rooms shut down:", "clients", len(clients))
+}
+
+// waitClosed waits until the clients are closed. A closed client flushes
+// its messages within the write timeout, so it does not wait longer.
+func (m *RoomManager) waitClosed(clients []*Client) {
+	timeout := time.NewTimer(m.keepAlive.WriteTimeout)
+	defer timeout.Stop()
+
+	for _, client := range clients {
+		select {
+		case <-client.Done():
+		case <-timeout.C:
+			slog.Warn("Clients not closed in time:", "timeout", m.keepAlive.WriteTimeout)
+			return
+		}
+	}
+}
+
+func (m *RoomManager) isClosing() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.closing
+}
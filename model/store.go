@@ -0,0 +1,174 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RoomRecord is the part of a room which outlives the server process.
+// Live client connections are never stored, a restored room starts empty.
+type RoomRecord struct {
+	Id           int       `json:"id"`
+	Name         string    `json:"name"`
+	Access       int       `json:"access"`
+	Capacity     int       `json:"capacity"`
+	CreationTime time.Time `json:"creationTime"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	HostKeyHash  string    `json:"hostKeyHash,omitempty"`
+	Lobby        bool      `json:"lobby,omitempty"`
+	Locked       bool      `json:"locked,omitempty"`
+	BannedAddrs  []string  `json:"bannedAddrs,omitempty"`
+	Invites      []Invite  `json:"invites,omitempty"`
+	InviteGen    int       `json:"inviteGen,omitempty"`
+	StartTime    time.Time `json:"startTime"`  // Zero if the room opened on creation
+	ExpiryTime   time.Time `json:"expiryTime"` // Zero if the room never expires
+}
+
+// RoomStore keeps the records of the rooms of a RoomManager.
+type RoomStore interface {
+	// Load returns all the stored rooms.
+	Load() ([]RoomRecord, error)
+	// Save stores the room, replacing the one with the same id.
+	Save(room RoomRecord) error
+	// Delete removes the room with the given id.
+	Delete(roomId int) error
+}
+
+// MemoryRoomStore is a RoomStore which keeps the rooms in memory only,
+// they are lost when the server stops.
+type MemoryRoomStore struct {
+	rooms map[int]RoomRecord
+	mutex sync.Mutex
+}
+
+func NewMemoryRoomStore() *MemoryRoomStore {
+	return &MemoryRoomStore{rooms: map[int]RoomRecord{}}
+}
+
+func (s *MemoryRoomStore) Load() ([]RoomRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return sortedRecords(s.rooms), nil
+}
+
+func (s *MemoryRoomStore) Save(room RoomRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rooms[room.Id] = room
+	return nil
+}
+
+func (s *MemoryRoomStore) Delete(roomId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.rooms, roomId)
+	return nil
+}
+
+// FileRoomStore is a RoomStore which keeps the rooms in a JSON file on
+// the local disk, so they survive restarts. The whole file is rewritten
+// on every change, which is cheap for the number of rooms of one server.
+type FileRoomStore struct {
+	path  string
+	rooms map[int]RoomRecord
+	mutex sync.Mutex
+}
+
+// NewFileRoomStore opens the store in the given file. The file is created
+// on the first change if it does not exist.
+func NewFileRoomStore(path string) (*FileRoomStore, error) {
+	store := &FileRoomStore{path: path, rooms: map[int]RoomRecord{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := []RoomRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		store.rooms[record.Id] = record
+	}
+	return store, nil
+}
+
+func (s *FileRoomStore) Load() ([]RoomRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return sortedRecords(s.rooms), nil
+}
+
+func (s *FileRoomStore) Save(room RoomRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, ok := s.rooms[room.Id]
+	s.rooms[room.Id] = room
+	if err := s.write(); err != nil {
+		if ok {
+			s.rooms[room.Id] = previous
+		} else {
+			delete(s.rooms, room.Id)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileRoomStore) Delete(roomId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, ok := s.rooms[roomId]
+	if !ok {
+		return nil
+	}
+	delete(s.rooms, roomId)
+	if err := s.write(); err != nil {
+		s.rooms[roomId] = previous
+		return err
+	}
+	return nil
+}
+
+// write replaces the file with the current rooms. The rooms are written
+// to a temporary file first, so a crash never leaves a truncated file.
+// The callers undo their change if it fails, so the rooms kept in memory
+// are always the ones in the file.
+func (s *FileRoomStore) write() error {
+	data, err := json.MarshalIndent(sortedRecords(s.rooms), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func sortedRecords(rooms map[int]RoomRecord) []RoomRecord {
+	records := make([]RoomRecord, 0, len(rooms))
+	for _, record := range rooms {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Id < records[j].Id
+	})
+	return records
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRoomStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.json")
+	store, err := NewFileRoomStore(path)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	created := time.Now().UTC().Truncate(time.Second)
+	for _, record := range []RoomRecord{
+		{Id: 2, Name: "second", Capacity: 3, CreationTime: created},
+		{Id: 1, Name: "first", Capacity: 2, CreationTime: created, Locked: true},
+		{Id: 3, Name: "third", Capacity: 2, CreationTime: created},
+	} {
+		if err := store.Save(record); err != nil {
+			t.Fatalf("save room %d: %v", record.Id, err)
+		}
+	}
+	if err := store.Delete(3); err != nil {
+		t.Fatalf("delete room: %v", err)
+	}
+
+	reopened, err := NewFileRoomStore(path)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	records, err := reopened.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(records) != 2 || records[0].Name != "first" || records[1].Name != "second" {
+		t.Fatalf("loaded %+v, want rooms first and second", records)
+	}
+	if !records[0].Locked || !records[0].CreationTime.Equal(created) {
+		t.Errorf("loaded %+v, want it locked and created at %v", records[0], created)
+	}
+}
+
+func TestRoomSettingsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.json")
+	openStore := func() RoomStore {
+		store, err := NewFileRoomStore(path)
+		if err != nil {
+			t.Fatalf("open store: %v", err)
+		}
+		return store
+	}
+
+	m := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, openStore(), nil)
+	roomId := newTestRoom(t, m, 2)
+	guest := joinTestRoom(t, m, roomId, Guest{Addr: "192.0.2.1"})
+	peer := guest.expect(t, Session).Peer
+	if err := m.KickClient(roomId, peer, true); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	if err := m.LockRoom(roomId, true); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	restarted := NewRoomManager(testKeepAlive, DefaultSignalTimeouts, openStore(), nil)
+	info, err := restarted.GetRoom(roomId)
+	if err != nil {
+		t.Fatalf("get restored room: %v", err)
+	}
+	if !info.Locked {
+		t.Error("restored room is not locked")
+	}
+
+	_, err = restarted.JoinRoom(roomId, Guest{Addr: "192.0.2.1"}, newMemoryTransport())
+	if !errors.Is(err, ErrBanned) {
+		t.Errorf("join of a banned address: got %v, want %v", err, ErrBanned)
+	}
+	if err := restarted.LockRoom(roomId, false); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if _, err := restarted.JoinRoom(roomId, Guest{Addr: "192.0.2.2"}, newMemoryTransport()); err != nil {
+		t.Errorf("join of the unlocked room: %v", err)
+	}
+}
+
+func TestFileRoomStoreKeepsRoomsOnFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.json")
+	store, err := NewFileRoomStore(path)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	if err := store.Save(RoomRecord{Id: 1, Name: "first", Capacity: 2}); err != nil {
+		t.Fatalf("save room: %v", err)
+	}
+
+	// A directory in place of the temporary file fails every write, even
+	// of a user who may write anywhere.
+	if err := os.Mkdir(path+".tmp", 0o700); err != nil {
+		t.Fatalf("block the writes: %v", err)
+	}
+	if err := store.Save(RoomRecord{Id: 1, Name: "renamed", Capacity: 2}); err == nil {
+		t.Error("update with a failed write: got no error")
+	}
+	if err := store.Save(RoomRecord{Id: 2, Name: "second", Capacity: 2}); err == nil {
+		t.Error("save with a failed write: got no error")
+	}
+	if err := store.Delete(1); err == nil {
+		t.Error("delete with a failed write: got no error")
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(records) != 1 || records[0].Name != "first" {
+		t.Errorf("loaded %+v, want the room first only", records)
+	}
+}
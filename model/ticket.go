@@ -0,0 +1,97 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTicket = errors.New("invalid join ticket")
+
+// JoinTickets issues and verifies join tickets. A ticket lets a client
+// join a password-protected room for a short time after it has entered
+// the password, so the password is not sent with every connection.
+// Tickets are signed with the key, every instance sharing the key
+// accepts them.
+type JoinTickets struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewJoinTickets(key []byte, ttl time.Duration) *JoinTickets {
+	return &JoinTickets{key: key, ttl: ttl}
+}
+
+// TTL is how long an issued ticket is valid.
+func (t JoinTickets) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue returns a ticket to join the room, which has the form
+// "<room id>.<expiry unix time>.<signature>".
+func (t JoinTickets) Issue(roomId int) string {
+	return t.issue(strconv.Itoa(roomId))
+}
+
+// IssueFor returns a ticket to join the room while it is in the given
+// generation, which has the form "<room id>-<generation>.<expiry unix
+// time>.<signature>".
+func (t JoinTickets) IssueFor(roomId int, generation int) string {
+	return t.issue(fmt.Sprintf("%d-%d", roomId, generation))
+}
+
+// Verify checks that the ticket was issued for the room and has not expired.
+func (t JoinTickets) Verify(ticket string, roomId int) error {
+	return t.verify(ticket, strconv.Itoa(roomId))
+}
+
+// VerifyFor checks that the ticket was issued for the room in the given
+// generation and has not expired.
+func (t JoinTickets) VerifyFor(ticket string, roomId int, generation int) error {
+	return t.verify(ticket, fmt.Sprintf("%d-%d", roomId, generation))
+}
+
+func (t JoinTickets) issue(subject string) string {
+	payload := fmt.Sprintf("%s.%d", subject, time.Now().Add(t.ttl).Unix())
+	return payload + "." + base64.RawURLEncoding.EncodeToString(t.sign(payload))
+}
+
+func (t JoinTickets) verify(ticket string, subject string) error {
+	payload, signature, ok := cutLast(ticket, ".")
+	if !ok {
+		return ErrInvalidTicket
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, t.sign(payload)) {
+		return ErrInvalidTicket
+	}
+
+	subjectStr, expiryStr, ok := strings.Cut(payload, ".")
+	if !ok || subjectStr != subject {
+		return ErrInvalidTicket
+	}
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return ErrInvalidTicket
+	}
+	return nil
+}
+
+func (t JoinTickets) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
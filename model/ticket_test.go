@@ -0,0 +1,37 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinTickets(t *testing.T) {
+	tickets := NewJoinTickets([]byte("key"), time.Hour)
+	ticket := tickets.Issue(7)
+	if err := tickets.Verify(ticket, 7); err != nil {
+		t.Fatalf("verify ticket: %v", err)
+	}
+
+	payload, signature, _ := cutLast(ticket, ".")
+	subject, _, _ := strings.Cut(payload, ".")
+	forged := map[string]string{
+		"another room":         tickets.Issue(8),
+		"pass of a generation": tickets.IssueFor(7, 0),
+		"tampered signature":   payload + "." + strings.ToUpper(signature),
+		"extended expiry": subject + "." +
+			strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10) + "." + signature,
+		"another key":   NewJoinTickets([]byte("other"), time.Hour).Issue(7),
+		"expired":       NewJoinTickets([]byte("key"), -time.Second).Issue(7),
+		"no signature":  payload,
+		"empty":         "",
+		"bad signature": payload + ".!!",
+	}
+	for name, ticket := range forged {
+		if err := tickets.Verify(ticket, 7); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("verify %s: got %v, want %v", name, err, ErrInvalidTicket)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// newToken returns a random URL-safe token of the given number of bytes.
+func newToken(size int) string {
+	bytes := make([]byte, size)
+	// crypto/rand.Read never returns an error and always fills the slice.
+	_, _ = rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
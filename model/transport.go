@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrTransportClosed = errors.New("transport is closed")
+
+// Transport carries the messages of a client over a single connection,
+// e.g. a WebSocket or a series of HTTP requests.
+type Transport interface {
+	// ReadMessage blocks until the next message arrives. It returns io.EOF
+	// once the remote side has closed the connection on purpose.
+	ReadMessage() ([]byte, error)
+	// WriteMessage sends the message, it fails if the message is not sent
+	// before the deadline.
+	WriteMessage(data []byte, deadline time.Time) error
+	// Ping asks the remote side for a sign of life, which is reported
+	// to the pong handler.
+	Ping(deadline time.Time) error
+	// SetPongHandler sets the function called on every sign of life
+	// of the remote side.
+	SetPongHandler(handle func())
+	// SetReadDeadline makes ReadMessage fail if nothing is received
+	// before the given time.
+	SetReadDeadline(t time.Time) error
+	// Shutdown informs the remote side that the connection is closed
+	// on purpose.
+	Shutdown(deadline time.Time) error
+	// Close closes the connection immediately.
+	Close() error
+}
+
+// webSocketTransport is a Transport over a gorilla WebSocket connection.
+type webSocketTransport struct {
+	conn *websocket.Conn
+}
+
+func NewWebSocketTransport(conn *websocket.Conn) Transport {
+	return &webSocketTransport{conn: conn}
+}
+
+func (t *webSocketTransport) ReadMessage() ([]byte, error) {
+	_, data, err := t.conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil, io.EOF
+	}
+	return data, err
+}
+
+func (t *webSocketTransport) WriteMessage(data []byte, deadline time.Time) error {
+	_ = t.conn.SetWriteDeadline(deadline)
+	return t.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t *webSocketTransport) Ping(deadline time.Time) error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+func (t *webSocketTransport) SetPongHandler(handle func()) {
+	t.conn.SetPongHandler(func(string) error {
+		handle()
+		return nil
+	})
+}
+
+func (t *webSocketTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+func (t *webSocketTransport) Shutdown(deadline time.Time) error {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	return t.conn.WriteControl(websocket.CloseMessage, message, deadline)
+}
+
+func (t *webSocketTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSignalingOverMemoryTransports(t *testing.T) {
+	pc := NewPeerConnection(2, DefaultSignalTimeouts)
+	defer pc.Close()
+
+	a, b := newMemoryTransport(), newMemoryTransport()
+	defer a.Close()
+	defer b.Close()
+	pc.AddClient(NewClient(a, testKeepAlive), "")
+	pc.AddClient(NewClient(b, testKeepAlive), "")
+
+	if got := a.expect(t, Role); got.Data != Impolite {
+		t.Errorf("role of the offerer: got %q, want %q", got.Data, Impolite)
+	}
+	if got := b.expect(t, Role); got.Data != Polite {
+		t.Errorf("role of the answerer: got %q, want %q", got.Data, Polite)
+	}
+	request := a.expect(t, RequestOffer)
+	if got := b.expect(t, Offer); got.SDP != "offer" {
+		t.Errorf("offer: got %q, want %q", got.SDP, "offer")
+	}
+	if got := a.expect(t, Answer); got.SDP != "answer" {
+		t.Errorf("answer: got %q, want %q", got.SDP, "answer")
+	}
+
+	candidate := json.RawMessage(`{"candidate":"c"}`)
+	a.send(Message{MessageType: IceCandidate, Peer: request.Peer, Candidate: candidate})
+	if got := b.expect(t, IceCandidate); string(got.Candidate) != string(candidate) {
+		t.Errorf("candidate: got %s, want %s", got.Candidate, candidate)
+	}
+}
+
+func TestPollingTransport(t *testing.T) {
+	ctx := context.Background()
+	transport := NewPollingTransport()
+	client := NewClient(transport, testKeepAlive)
+
+	if err := transport.Push(ctx, []byte("ping")); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	data, err := client.Receive()
+	if err != nil || string(data) != "ping" {
+		t.Fatalf("receive: got %q, %v, want %q", data, err, "ping")
+	}
+
+	for _, message := range []string{"one", "two"} {
+		if err := client.Send([]byte(message)); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	client.Close()
+
+	// The queued messages are polled before the end of the connection.
+	polled := []string{}
+	for {
+		messages, err := transport.Poll(ctx, testTimeout)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		for _, message := range messages {
+			polled = append(polled, string(message))
+		}
+	}
+	if strings.Join(polled, ",") != "one,two" {
+		t.Errorf("polled %v, want [one two]", polled)
+	}
+}
+
+func TestPollingTransportHangUp(t *testing.T) {
+	transport := NewPollingTransport()
+	client := NewClient(transport, testKeepAlive)
+
+	transport.HangUp()
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("client not closed after the polling client left")
+	}
+}
+
+func TestPollingTransportReadDeadline(t *testing.T) {
+	transport := NewPollingTransport()
+	_ = transport.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	if _, err := transport.ReadMessage(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read past the deadline: got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(NewWebSocketTransport(conn), testKeepAlive)
+		clients <- client
+		client.Wait()
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	client := <-clients
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data, err := client.Receive()
+	if err != nil || string(data) != "ping" {
+		t.Fatalf("receive: got %q, %v, want %q", data, err, "ping")
+	}
+
+	if err := client.Send([]byte("pong")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	_, data, err = conn.ReadMessage()
+	if err != nil || string(data) != "pong" {
+		t.Fatalf("read: got %q, %v, want %q", data, err, "pong")
+	}
+
+	// A normal closure ends the client instead of waiting for a resume.
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("client not closed after a normal closure")
+	}
+}
@@ -1,9 +1,11 @@
 package validation
 
 import (
+	"cmp"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Validate performs a series of validation checks on the provided object
@@ -37,6 +39,14 @@ func Equal[T comparable](values []T, messages ...string) Constraint[T] {
 	return makeConstraint(check, fmt.Sprintf("is not %v", values), messages...)
 }
 
+// InRange checks if the value of obj is between min and max inclusive.
+// messages are optional parameter to replace the default
+// error message.
+func InRange[T cmp.Ordered](min, max T, messages ...string) Constraint[T] {
+	check := func(t T) bool { return t < min || t > max }
+	return makeConstraint(check, "is out of range", messages...)
+}
+
 // NotEmpty checks if the string is not empty.
 // messages are optional parameter to replace the default
 // error message.
@@ -80,6 +90,17 @@ func AnInteger(message ...string) Constraint[string] {
 	return makeConstraint(check, "must be an integer", message...)
 }
 
+// ATime checks if the string is a valid time in the given layout.
+// messages are optional parameter to replace the default
+// error message.
+func ATime(layout string, message ...string) Constraint[string] {
+	check := func(s string) bool {
+		_, err := time.Parse(layout, s)
+		return err != nil
+	}
+	return makeConstraint(check, "must be a time", message...)
+}
+
 func makeConstraint[T any](check func(T) bool, defaultMessage string, messages ...string) Constraint[T] {
 	return func(t T) *ValidationError {
 		if check(t) {
@@ -3,8 +3,19 @@ export class PeerConnection {
     this.connectionConfig = connectionConfig;
     this.localStream = localStream;
     this.remoteStream = remoteStream;
+    this.remoteStreamId = null;
+    this.extraTracks = new Map(); // track -> stream
+    this.senders = new Map(); // track -> RTCRtpSender
     this.peerConnection = null;
-    this.iceCandidateTimeout = 2 * 1000; // milliseconds
+    this.onicecandidate = null;
+    this.onoffer = null;
+    this.onremotestream = null;
+
+    // Perfect negotiation state, the role is assigned by the server.
+    this.polite = false;
+    this.negotiated = false;
+    this.makingOffer = false;
+    this.ignoreOffer = false;
   }
 
   _createConnection() {
@@ -13,14 +24,50 @@ export class PeerConnection {
     }
 
     this.peerConnection = new RTCPeerConnection(this.connectionConfig);
+    this.negotiated = false;
+    this.senders.clear();
+    this.remoteStreamId = null;
 
     this.remoteStream.getTracks().forEach((track) => {
       track.stop();
       this.remoteStream.removeTrack(track);
     });
 
+    this.peerConnection.onicecandidate = (event) => {
+      if (this.onicecandidate) {
+        this.onicecandidate(event.candidate);
+      }
+    };
+
+    this.peerConnection.onnegotiationneeded = async () => {
+      // The initial offer is requested by the server.
+      if (!this.negotiated) return;
+      try {
+        this.makingOffer = true;
+        await this.peerConnection.setLocalDescription();
+        if (this.onoffer) {
+          this.onoffer(this.peerConnection.localDescription);
+        }
+      } catch (err) {
+        console.log("Failed to renegotiate:", err);
+      } finally {
+        this.makingOffer = false;
+      }
+    };
+
     this.peerConnection.ontrack = (event) => {
-      event.streams[0].getTracks().forEach((track) => {
+      const stream = event.streams[0];
+      if (!this.remoteStreamId) {
+        this.remoteStreamId = stream.id;
+      }
+      if (stream.id !== this.remoteStreamId) {
+        // Additional streams, e.g. a shared screen.
+        if (this.onremotestream) {
+          this.onremotestream(stream);
+        }
+        return;
+      }
+      stream.getTracks().forEach((track) => {
         this.remoteStream.addTrack(track);
       });
     };
@@ -28,56 +75,91 @@ export class PeerConnection {
     this.localStream.getTracks().forEach((track) => {
       this.peerConnection.addTrack(track, this.localStream);
     });
+    this.extraTracks.forEach((stream, track) => {
+      this.senders.set(track, this.peerConnection.addTrack(track, stream));
+    });
   }
 
   async createOffer() {
     this._createConnection();
 
-    const iceCandidatePromise = this.waitIceCandidates();
-
     const offer = await this.peerConnection.createOffer();
     await this.peerConnection.setLocalDescription(offer);
 
-    await iceCandidatePromise;
-
     return this.peerConnection.localDescription;
   }
 
+  // createAnswer answers the initial offer or a renegotiation offer.
+  // It returns null if the offer is ignored because of a collision.
   async createAnswer(offer) {
-    this._createConnection();
+    if (!this.negotiated) {
+      this._createConnection();
 
-    const iceCandidatePromise = this.waitIceCandidates();
+      await this.peerConnection.setRemoteDescription(offer);
+      const answer = await this.peerConnection.createAnswer();
+      await this.peerConnection.setLocalDescription(answer);
+      this.negotiated = true;
 
-    await this.peerConnection.setRemoteDescription(offer);
-    const answer = await this.peerConnection.createAnswer();
-    await this.peerConnection.setLocalDescription(answer);
+      return this.peerConnection.localDescription;
+    }
 
-    await iceCandidatePromise;
+    const offerCollision =
+      this.makingOffer || this.peerConnection.signalingState !== "stable";
+    this.ignoreOffer = !this.polite && offerCollision;
+    if (this.ignoreOffer) return null;
 
+    // Rolls back the own offer implicitly if there is one.
+    await this.peerConnection.setRemoteDescription(offer);
+    await this.peerConnection.setLocalDescription();
     return this.peerConnection.localDescription;
   }
 
-  async waitIceCandidates() {
-    let icIsAdded = false;
-    this.peerConnection.onicecandidate = (event) => {
-      if (event.candidate) {
-        icIsAdded = true;
+  async addIceCandidate(candidate) {
+    if (!this.peerConnection) return;
+    try {
+      if (candidate) {
+        await this.peerConnection.addIceCandidate(candidate);
+      } else {
+        // End of candidates
+        await this.peerConnection.addIceCandidate();
       }
-    };
-    return new Promise((resolve) => {
-      const intervalId = setInterval(() => {
-        if (icIsAdded) {
-          resolve();
-          clearInterval(intervalId);
-        }
-      }, this.iceCandidateTimeout);
-    });
+    } catch (err) {
+      if (!this.ignoreOffer) {
+        console.log("Failed to add ICE candidate:", err);
+      }
+    }
   }
 
   async addAnswer(answer) {
-    if (!this.peerConnection.currentRemoteDescription) {
+    if (this.peerConnection.signalingState === "have-local-offer") {
       await this.peerConnection.setRemoteDescription(answer);
     }
+    this.negotiated = true;
+  }
+
+  // restartIce gathers new candidates after the network changed,
+  // it renegotiates the connection.
+  restartIce() {
+    if (this.peerConnection && this.negotiated) {
+      this.peerConnection.restartIce();
+    }
+  }
+
+  // addTrack sends an additional track, renegotiating the connection.
+  addTrack(track, stream) {
+    this.extraTracks.set(track, stream);
+    if (this.peerConnection) {
+      this.senders.set(track, this.peerConnection.addTrack(track, stream));
+    }
+  }
+
+  removeTrack(track) {
+    this.extraTracks.delete(track);
+    const sender = this.senders.get(track);
+    if (this.peerConnection && sender) {
+      this.peerConnection.removeTrack(sender);
+    }
+    this.senders.delete(track);
   }
 
   wait() {
@@ -86,4 +168,12 @@ export class PeerConnection {
       this.remoteStream.removeTrack(track);
     });
   }
+
+  close() {
+    if (this.peerConnection) {
+      this.peerConnection.close();
+      this.peerConnection = null;
+    }
+    this.wait();
+  }
 }
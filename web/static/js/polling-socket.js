@@ -0,0 +1,103 @@
+// PollingSocket mimics a WebSocket over plain HTTP requests for networks
+// which do not let WebSocket upgrades through. It posts messages one by one
+// and receives them by long polling.
+export class PollingSocket {
+  constructor(url) {
+    this.url = url;
+    this.sessionUrl = null;
+    this.readyState = WebSocket.CONNECTING;
+    this.onopen = null;
+    this.onclose = null;
+    this.onmessage = null;
+    this.onerror = null;
+    // Messages are posted one after another to keep their order.
+    this.sendQueue = Promise.resolve();
+    this.open();
+  }
+
+  async open() {
+    try {
+      const response = await fetch(this.url, { method: "POST" });
+      if (!response.ok) {
+        throw new Error(`Polling session failed: ${response.status}`);
+      }
+      const { session } = await response.json();
+      this.sessionUrl = `${new URL(this.url).origin}/poll/${session}`;
+    } catch (err) {
+      this.fail(err);
+      return;
+    }
+
+    this.readyState = WebSocket.OPEN;
+    this.call(this.onopen, {});
+    this.poll();
+  }
+
+  async poll() {
+    while (this.readyState === WebSocket.OPEN) {
+      let messages;
+      try {
+        const response = await fetch(this.sessionUrl, { cache: "no-store" });
+        if (response.status === 410) {
+          // The server closed the session on purpose.
+          this.finish(1000);
+          return;
+        }
+        if (!response.ok) {
+          throw new Error(`Polling failed: ${response.status}`);
+        }
+        messages = await response.json();
+      } catch (err) {
+        this.fail(err);
+        return;
+      }
+      messages.forEach((message) => {
+        this.call(this.onmessage, { data: JSON.stringify(message) });
+      });
+    }
+  }
+
+  send(data) {
+    if (this.readyState !== WebSocket.OPEN) {
+      throw new Error("Polling session is not open");
+    }
+    this.sendQueue = this.sendQueue
+      .then(() => fetch(this.sessionUrl, { method: "POST", body: data }))
+      .then((response) => {
+        if (!response.ok) {
+          throw new Error(`Sending failed: ${response.status}`);
+        }
+      })
+      .catch((err) => this.fail(err));
+  }
+
+  close() {
+    if (this.readyState === WebSocket.OPEN) {
+      fetch(this.sessionUrl, { method: "DELETE" }).catch(() => {});
+    }
+    this.finish(1000);
+  }
+
+  fail(err) {
+    if (this.readyState === WebSocket.CLOSED) {
+      return;
+    }
+    console.log(err);
+    this.call(this.onerror, { error: err });
+    this.finish(1006);
+  }
+
+  finish(code) {
+    if (this.readyState === WebSocket.CLOSED) {
+      return;
+    }
+    this.readyState = WebSocket.CLOSED;
+    this.call(this.onclose, { code: code });
+  }
+
+  call(func, event) {
+    if (func) {
+      func(event);
+    }
+  }
+}
@@ -2,27 +2,43 @@ import { PeerConnection } from "./peer-connection.js";
 import { PeerChatWebsocket } from "./web-socket.js";
 
 const peerConnectionConfig = {
-  iceServers: [
-    {
-      urls: ["stun:stun0.l.google.com:19302", "stun:stun2.l.google.com:19302"],
-    },
-  ],
+  iceServers: room.iceServers,
 };
 
 const hostname = window.location.hostname;
 const port = window.location.port;
 const protocol = secured ? "wss" : "ws";
 const URL = `${protocol}://${hostname}:${port}/ws/room/${room.id}`;
+const POLLING_URL = `${window.location.origin}/poll/room/${room.id}`;
+
+// nameQuery returns the query with the display name of the participant,
+// who is asked for it once and may leave it empty.
+const nameQuery = () => {
+  let name = localStorage.getItem("peer-chat-name");
+  if (name === null) {
+    name = (prompt(locale.get("room-name-prompt")) || "").trim();
+    localStorage.setItem("peer-chat-name", name);
+  }
+  return name ? `?name=${encodeURIComponent(name)}` : "";
+};
+
+// roster keeps who takes part in the room by their peer ids, as the server
+// tells with roster messages.
+const roster = new Map();
+const rosterListeners = [];
+const rosterName = (peer) =>
+  roster.get(peer)?.name || `${locale.get("roster-participant")} ${peer}`;
 
 class Page {
   constructor() {
     this.localVideo = document.getElementById("local-video");
-    this.remoteVideo = document.getElementById("remote-video");
+    this.remoteVideos = document.getElementById("remote-videos");
     this.loaderContainer = document.getElementById("loader-container");
     this.loaderMessage = document.getElementById("loader-message");
     this.disconnectBtn = document.getElementById("disconnect-control");
     this.microBtn = document.getElementById("micro-control");
     this.cameraBtn = document.getElementById("camera-control");
+    this.screenBtn = document.getElementById("screen-control");
     this.muteMicro = document.getElementById("mute-micro");
     this.muteCamera = document.getElementById("mute-camera");
     this.errorMessageContainer = document.querySelector(
@@ -30,6 +46,8 @@ class Page {
     );
     this.errorMessageContainer.style.display = "none";
     this.errorMessage = document.querySelector(".err-message");
+    this.notice = document.getElementById("notice");
+    this.notice.style.display = "none";
 
     this.microBtn.addEventListener("click", () => {
       const isOn = this.muteMicro.style.visibility === "hidden";
@@ -51,13 +69,29 @@ class Page {
         this.muteCamera.style.visibility = "hidden";
       }
     });
+    this.screenBtn.addEventListener("click", () => this.toggleScreen());
     this.disconnectBtn.addEventListener("click", () => {
       window.location.href = "/home";
     });
   }
-  setStreams(local, remote) {
+  setLocalStream(local) {
     this.localVideo.srcObject = local;
-    this.remoteVideo.srcObject = remote;
+  }
+  addRemoteStream(peer, remote) {
+    const video = document.createElement("video");
+    video.className = "video-player";
+    video.id = `remote-video-${peer}-${remote.id}`;
+    video.dataset.peer = peer;
+    video.autoplay = true;
+    video.playsInline = true;
+    video.srcObject = remote;
+    this.remoteVideos.appendChild(video);
+  }
+  removeRemoteStream(peer, remote) {
+    const selector = remote
+      ? `#remote-video-${peer}-${CSS.escape(remote.id)}`
+      : `[data-peer="${peer}"]`;
+    this.remoteVideos.querySelectorAll(selector).forEach((v) => v.remove());
   }
   setLoading(message) {
     this.loaderContainer.style.visibility = "";
@@ -71,10 +105,19 @@ class Page {
     this.errorMessage.innerHTML = message;
     this.errorMessageContainer.style.display = "flex";
   }
+  setNotice(message) {
+    this.notice.innerText = message;
+    this.notice.style.display = "";
+    clearTimeout(this.noticeTimeout);
+    this.noticeTimeout = setTimeout(() => {
+      this.notice.style.display = "none";
+    }, 10 * 1000);
+  }
   turnOnMicrophone() {}
   turnOffMicrophone() {}
   turnOnCamera() {}
   turnOffCamera() {}
+  toggleScreen() {}
 }
 
 const init = async () => {
@@ -90,14 +133,27 @@ const init = async () => {
     // video: true,
     audio: true,
   });
-  const remoteStream = new MediaStream();
-  page.setStreams(localStream, remoteStream);
+  page.setLocalStream(localStream);
 
-  const peerConnection = new PeerConnection(
-    peerConnectionConfig,
-    localStream,
-    remoteStream,
-  );
+  // Every remote peer of the room gets its own connection and video.
+  const createPeerConnection = (peer) => {
+    const remoteStream = new MediaStream();
+    page.addRemoteStream(peer, remoteStream);
+    const peerConnection = new PeerConnection(
+      peerConnectionConfig,
+      localStream,
+      remoteStream,
+    );
+    peerConnection.onremotestream = (stream) => {
+      page.addRemoteStream(peer, stream);
+      stream.onremovetrack = () => {
+        if (stream.getTracks().length === 0) {
+          page.removeRemoteStream(peer, stream);
+        }
+      };
+    };
+    return peerConnection;
+  };
 
   page.turnOnCamera = () => {
     const video = localStream
@@ -127,7 +183,35 @@ const init = async () => {
   page.microBtn.click();
   page.cameraBtn.click();
 
-  const websocket = new PeerChatWebsocket(URL, peerConnection);
+  const query = nameQuery();
+  const websocket = new PeerChatWebsocket(
+    URL + query,
+    createPeerConnection,
+    POLLING_URL + query,
+  );
+  websocket.onpeerclose = (peer) => page.removeRemoteStream(peer);
+
+  // Screen sharing adds a track to every connection, which renegotiates it.
+  let screenTrack = null;
+  const stopScreen = () => {
+    websocket.removeTrack(screenTrack);
+    screenTrack.stop();
+    screenTrack = null;
+  };
+  page.toggleScreen = async () => {
+    if (screenTrack) {
+      stopScreen();
+      return;
+    }
+    const screenStream = await navigator.mediaDevices.getDisplayMedia({
+      video: true,
+    });
+    screenTrack = screenStream.getVideoTracks()[0];
+    screenTrack.onended = () => {
+      if (screenTrack) stopScreen();
+    };
+    websocket.addTrack(screenTrack, screenStream);
+  };
   websocket.onerror = () => {
     const message = locale.get("room-error");
     page.setError(message);
@@ -141,17 +225,204 @@ const init = async () => {
       case "Wait for room":
         page.setLoading(locale.get("room-wait-room"));
         break;
+      case "Wait for host":
+        page.setLoading(locale.get("room-wait-host"));
+        break;
       default:
         page.setLoading(locale.get("room-wait-unknown"));
         throw new Error(`Unknown reason to wait: ${obj.data}`);
     }
   };
+  websocket.messageHandlers["queue-position"] = (event) => {
+    const obj = JSON.parse(event.data);
+    page.setLoading(locale.get("room-queue-position").replace("{position}", obj.data));
+  };
+  websocket.messageHandlers["room-expiring"] = (event) => {
+    const obj = JSON.parse(event.data);
+    const minutes = Math.max(1, Math.round((new Date(obj.data) - Date.now()) / 60000));
+    page.setNotice(locale.get("room-expiring").replace("{minutes}", minutes));
+  };
+  websocket.messageHandlers["room-expired"] = () => {
+    page.setError(locale.get("room-expired"));
+  };
+  websocket.messageHandlers["server-shutdown"] = () => {
+    page.setNotice(locale.get("server-shutdown"));
+  };
+  websocket.messageHandlers["kicked"] = (event) => {
+    const obj = JSON.parse(event.data);
+    page.setError(locale.get(obj.data === "ban" ? "room-banned" : "room-kicked"));
+  };
+  websocket.messageHandlers["denied"] = () => {
+    page.hideLoading();
+    page.setError(locale.get("room-denied"));
+  };
   websocket.messageHandlers["error"] = (event) => {
     console.log(event.data);
   };
+  trackRoster(websocket);
+  chat(websocket);
+  if (room.host) {
+    manageRoom(websocket);
+    manageLobby(websocket, page);
+  }
   websocket.messageHandlers["offer"] = () => page.hideLoading();
   websocket.messageHandlers["answer"] = () => page.hideLoading();
   websocket.handle();
 };
 
+// trackRoster keeps the roster up to date and shows it.
+const trackRoster = (websocket) => {
+  const list = document.getElementById("roster-list");
+  const render = () => {
+    list.innerHTML = "";
+    for (const [peer, entry] of roster) {
+      const row = document.createElement("div");
+      row.className = "roster-entry";
+      let text = rosterName(peer);
+      if (peer === websocket.peer) text += ` (${locale.get("roster-you")})`;
+      if (!entry.active) text += ` · ${locale.get("roster-waiting")}`;
+      row.innerText = text;
+      list.appendChild(row);
+    }
+  };
+  rosterListeners.push(render);
+
+  websocket.messageHandlers["roster"] = (event) => {
+    const obj = JSON.parse(event.data);
+    switch (obj.data) {
+      case "joined":
+        roster.set(obj.peer, { name: obj.name, active: false });
+        break;
+      case "left":
+        roster.delete(obj.peer);
+        break;
+      case "active":
+      case "waiting":
+        const entry = roster.get(obj.peer) || { name: obj.name };
+        entry.active = obj.data === "active";
+        roster.set(obj.peer, entry);
+        break;
+    }
+    rosterListeners.forEach((listener) => listener());
+  };
+  // A new session starts with a new roster.
+  websocket.messageHandlers["session"] = () => roster.clear();
+};
+
+// chat shows the chat of the room, which the server relays over the socket,
+// so it works while the peers are not linked.
+const chat = (websocket) => {
+  const form = document.getElementById("chat-form");
+  const messages = document.getElementById("chat-messages");
+  const input = document.getElementById("chat-input");
+  const unread = document.getElementById("chat-unread");
+  unread.style.display = "none";
+
+  const show = (author, text) => {
+    const message = document.createElement("div");
+    message.className = "chat-message";
+    const name = document.createElement("strong");
+    name.innerText = `${author}: `;
+    const body = document.createElement("span");
+    body.innerText = text;
+    message.append(name, body);
+    messages.appendChild(message);
+    messages.scrollTop = messages.scrollHeight;
+    if (form.style.display === "none") unread.style.display = "";
+  };
+
+  websocket.messageHandlers["chat"] = (event) => {
+    const obj = JSON.parse(event.data);
+    show(obj.name || rosterName(obj.peer), obj.data);
+  };
+  input.addEventListener("submit", (event) => {
+    event.preventDefault();
+    const text = input.text.value.trim();
+    if (!text) return;
+    websocket.send({ type: "chat", data: text });
+    show(locale.get("chat-you"), text);
+    input.reset();
+  });
+  document.getElementById("chat-control").addEventListener("click", () => {
+    unread.style.display = "none";
+  });
+};
+
+// manageRoom lets the host lock the room and kick or ban its participants.
+const manageRoom = (websocket) => {
+  const lock = document.getElementById("host-lock");
+  lock.checked = room.locked;
+  lock.addEventListener("change", () => {
+    websocket.send({ type: "lock", data: String(lock.checked) });
+  });
+  websocket.messageHandlers["locked"] = (event) => {
+    lock.checked = JSON.parse(event.data).data === "true";
+  };
+
+  const participants = document.getElementById("host-participants");
+  const noParticipants = document.getElementById("host-no-participants");
+  const render = () => {
+    participants.innerHTML = "";
+    const peers = [...roster.keys()].filter((peer) => peer !== websocket.peer);
+    noParticipants.style.display = peers.length ? "none" : "";
+    for (const peer of peers) {
+      const row = document.createElement("div");
+      row.className = "host-participant";
+      const name = document.createElement("span");
+      name.innerText = rosterName(peer);
+      row.appendChild(name);
+      for (const type of ["kick", "ban"]) {
+        const button = document.createElement("button");
+        button.className = "usual-button transparent-button";
+        button.innerText = locale.get(`host-form-${type}`);
+        button.addEventListener("click", () => {
+          websocket.send({ type: type, peer: peer });
+          row.remove();
+        });
+        row.appendChild(button);
+      }
+      participants.appendChild(row);
+    }
+  };
+  rosterListeners.push(render);
+};
+
+// manageLobby lets the host admit or deny the guests which knock on the room.
+const manageLobby = (websocket, page) => {
+  const knocks = document.getElementById("host-knocks");
+  const noKnocks = document.getElementById("host-no-knocks");
+  const update = () => {
+    noKnocks.style.display = knocks.children.length ? "none" : "";
+  };
+  websocket.messageHandlers["knock"] = (event) => {
+    const obj = JSON.parse(event.data);
+    const guest = obj.data || `${locale.get("host-form-guest")} ${obj.peer}`;
+    const row = document.createElement("div");
+    row.className = "host-participant";
+    row.id = `host-knock-${obj.peer}`;
+    const name = document.createElement("span");
+    name.innerText = guest;
+    row.appendChild(name);
+    for (const type of ["admit", "deny"]) {
+      const button = document.createElement("button");
+      button.className = "usual-button transparent-button";
+      button.innerText = locale.get(`host-form-${type}`);
+      button.addEventListener("click", () => {
+        websocket.send({ type: type, peer: obj.peer });
+        row.remove();
+        update();
+      });
+      row.appendChild(button);
+    }
+    knocks.appendChild(row);
+    update();
+    page.setNotice(locale.get("room-knock").replace("{name}", guest));
+  };
+  websocket.messageHandlers["knock-left"] = (event) => {
+    const obj = JSON.parse(event.data);
+    document.getElementById(`host-knock-${obj.peer}`)?.remove();
+    update();
+  };
+};
+
 init();
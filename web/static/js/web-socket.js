@@ -1,45 +1,152 @@
+import { PollingSocket } from "./polling-socket.js";
+
 export class PeerChatWebsocket {
-  constructor(url, peerConnection) {
+  // The fallback URL is used for HTTP long polling when the WebSocket
+  // cannot be opened.
+  constructor(url, createPeerConnection, fallbackUrl) {
+    this.url = url;
+    this.fallbackUrl = fallbackUrl;
+    this.polling = false;
+    this.opened = false;
     this.websocket = new WebSocket(url);
-    this.peerConnection = peerConnection;
+    // The session token lets a dropped connection be resumed.
+    this.token = null;
+    this.peer = null;
+    // The server turned the client away, e.g. from a locked room, with
+    // an error before the connection was closed.
+    this.rejected = false;
+    this.joined = false;
+    this.reconnectAttempts = 0;
+    this.maxReconnectAttempts = 10;
+    this.reconnectDelay = 2 * 1000; // milliseconds
+    // Creates a PeerConnection for the remote peer with the given id.
+    this.createPeerConnection = createPeerConnection;
+    this.peerConnections = new Map();
+    this.sharedTracks = new Map(); // track -> stream
+    this.onpeerclose = null;
     this.messageHandlers = {};
-    this.holdInterval = 30 * 1000; // milliseconds
+    // Messages are handled one by one, so ICE candidates are never applied
+    // before the description they belong to.
+    this.messageQueue = Promise.resolve();
   }
 
   handle() {
-    this.websocket.onopen = (event) => this.call(this.onopen.bind(this), event);
-    this.websocket.onclose = (event) =>
-      this.call(this.onclose.bind(this), event);
-    this.websocket.onmessage = (event) =>
-      this.call(this.onmessage.bind(this), event);
-    this.websocket.onerror = (event) =>
-      this.call(this.onerror.bind(this), event);
+    this.joined = false;
+    this.websocket.onopen = (event) => {
+      this.opened = true;
+      this.call(this.onopen, event);
+    };
+    this.websocket.onclose = (event) => this.reconnect(event);
+    this.websocket.onmessage = (event) => {
+      this.messageQueue = this.messageQueue
+        .then(() => this.onmessage(event))
+        .catch((err) => console.log(err));
+    };
+    this.websocket.onerror = (event) => {
+      // Failed attempts to resume or to open the WebSocket are handled
+      // by reconnect.
+      if (this.reconnectAttempts === 0 && !this.canFallBack()) {
+        this.call(this.onerror, event);
+      }
+    };
+  }
+
+  // reconnect resumes the session after the connection dropped. A normal
+  // closure means the server closed the session on purpose.
+  reconnect(event) {
+    if (this.rejected) {
+      this.call(this.onerror, event);
+      this.call(this.onclose, event);
+      return;
+    }
+
+    if (this.canFallBack()) {
+      this.polling = true;
+      this.websocket = new PollingSocket(this.fallbackUrl);
+      this.handle();
+      return;
+    }
+
+    const normalClosure = 1000;
+    if (
+      !this.token ||
+      event.code === normalClosure ||
+      this.reconnectAttempts >= this.maxReconnectAttempts
+    ) {
+      if (this.reconnectAttempts > 0) {
+        this.call(this.onerror, event);
+      }
+      this.call(this.onclose, event);
+      return;
+    }
+
+    this.reconnectAttempts++;
+    setTimeout(() => {
+      const url = new URL(this.polling ? this.fallbackUrl : this.url);
+      url.searchParams.set("resume", this.token);
+      this.websocket = this.polling
+        ? new PollingSocket(url.href)
+        : new WebSocket(url.href);
+      this.handle();
+    }, this.reconnectDelay);
+  }
+
+  // canFallBack reports whether the WebSocket upgrade failed, so the
+  // connection should be retried over HTTP long polling.
+  canFallBack() {
+    return !this.opened && !this.polling && !!this.fallbackUrl;
   }
 
   async onmessage(event) {
     const obj = JSON.parse(event.data);
+    // An error which comes before anything else turns the client away.
+    this.rejected = !this.joined && obj.type === "error";
+    this.joined = true;
     switch (obj.type) {
       case "request-offer":
-        const offer = await this.peerConnection.createOffer();
-        this.websocket.send(JSON.stringify(offer));
-        this.unhold();
+        const offer = await this.getPeerConnection(obj.peer).createOffer();
+        this.send({ type: offer.type, sdp: offer.sdp, peer: obj.peer });
+        break;
+      case "session":
+        if (this.token && this.token !== obj.data) {
+          // The old session expired, the server links the peers again.
+          for (const peer of [...this.peerConnections.keys()]) {
+            this.closePeerConnection(peer);
+          }
+        }
+        this.token = obj.data;
+        this.peer = obj.peer; // The own peer id
+        this.reconnectAttempts = 0;
+        break;
+      case "resumed":
+        this.reconnectAttempts = 0;
+        this.peerConnections.forEach((pc) => pc.restartIce());
+        break;
+      case "role":
+        this.getPeerConnection(obj.peer).polite = obj.data === "polite";
         break;
       case "offer":
-        const answer = await this.peerConnection.createAnswer(obj);
-        this.websocket.send(JSON.stringify(answer));
-        this.hold();
+        const answer = await this.getPeerConnection(obj.peer).createAnswer(obj);
+        if (answer) {
+          this.send({ type: answer.type, sdp: answer.sdp, peer: obj.peer });
+        }
         break;
       case "answer":
-        await this.peerConnection.addAnswer(obj);
-        this.hold();
+        await this.getPeerConnection(obj.peer).addAnswer(obj);
+        break;
+      case "ice-candidate":
+        await this.getPeerConnection(obj.peer).addIceCandidate(obj.candidate);
+        break;
+      case "peer-left":
+        this.closePeerConnection(obj.peer);
         break;
       case "wait":
-        await this.peerConnection.wait();
-        this.hold();
+        for (const peer of [...this.peerConnections.keys()]) {
+          this.closePeerConnection(peer);
+        }
         break;
       case "error":
         throw new Error(`Server Error:`, obj.data);
-        this.unhold();
     }
 
     if (this.messageHandlers) {
@@ -48,18 +155,47 @@ export class PeerChatWebsocket {
     }
   }
 
-  hold() {
-    if (!this.holdIntervalId) {
-      this.holdIntervalId = setInterval(() => {
-        this.websocket.send(JSON.stringify(HoldMessage));
-      }, this.holdInterval);
+  getPeerConnection(peer) {
+    let peerConnection = this.peerConnections.get(peer);
+    if (!peerConnection) {
+      peerConnection = this.createPeerConnection(peer);
+      peerConnection.onicecandidate = (candidate) => {
+        this.send({ type: "ice-candidate", peer: peer, candidate: candidate });
+      };
+      peerConnection.onoffer = (offer) => {
+        this.send({ type: offer.type, sdp: offer.sdp, peer: peer });
+      };
+      this.sharedTracks.forEach((stream, track) => {
+        peerConnection.addTrack(track, stream);
+      });
+      this.peerConnections.set(peer, peerConnection);
+    }
+    return peerConnection;
+  }
+
+  closePeerConnection(peer) {
+    const peerConnection = this.peerConnections.get(peer);
+    if (peerConnection) {
+      peerConnection.close();
+      this.peerConnections.delete(peer);
+      this.call(this.onpeerclose, peer);
     }
   }
 
-  unhold() {
-    if (this.holdIntervalId) {
-      clearInterval(this.holdIntervalId);
-      this.holdIntervalId = 0;
+  // addTrack shares an additional track, e.g. a screen, with every peer.
+  addTrack(track, stream) {
+    this.sharedTracks.set(track, stream);
+    this.peerConnections.forEach((pc) => pc.addTrack(track, stream));
+  }
+
+  removeTrack(track) {
+    this.sharedTracks.delete(track);
+    this.peerConnections.forEach((pc) => pc.removeTrack(track));
+  }
+
+  send(message) {
+    if (this.websocket.readyState === WebSocket.OPEN) {
+      this.websocket.send(JSON.stringify(message));
     }
   }
 
@@ -69,9 +205,3 @@ export class PeerChatWebsocket {
     }
   }
 }
-
-const HoldMessage = {
-  type: "hold",
-  data: "",
-  sdp: "",
-};